@@ -0,0 +1,127 @@
+package adapter
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/foliagecp/sdk/clients/go/db"
+	sfplugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/ui-app-lib/auth"
+	"github.com/foliagecp/ui-app-lib/internal/common"
+)
+
+const (
+	// _CONTROLLER_PRINCIPAL is the principal of sessions the controller is started for, it's kept in the controller body
+	// and passed to CONTROLLER_CONSTRUCT in request options
+	_CONTROLLER_PRINCIPAL = "principal"
+	// _CONTROLLER_DENIED lists result keys the principal isn't allowed to read
+	_CONTROLLER_DENIED = "denied"
+)
+
+// deniedReporter is implemented by decorators which construct other objects, their denied keys are reported too
+type deniedReporter interface {
+	// denied returns paths of denied keys relative to the decorator result
+	denied() []string
+}
+
+func parsePrincipal(data *easyjson.JSON) auth.Principal {
+	var principal auth.Principal
+
+	if data == nil || !data.PathExists(_CONTROLLER_PRINCIPAL) {
+		return principal
+	}
+
+	if err := json.Unmarshal(data.GetByPath(_CONTROLLER_PRINCIPAL).ToBytes(), &principal); err != nil {
+		slog.Warn("invalid principal", "err", err.Error())
+	}
+
+	return principal
+}
+
+// principalOptions carries the controller principal to CONTROLLER_CONSTRUCT, nil if nothing is authorized
+func principalOptions(controllerBody *easyjson.JSON) *easyjson.JSON {
+	if config.Authorizer == nil || !controllerBody.PathExists(_CONTROLLER_PRINCIPAL) {
+		return nil
+	}
+
+	return easyjson.NewJSONObjectWithKeyValue(_CONTROLLER_PRINCIPAL, controllerBody.GetByPath(_CONTROLLER_PRINCIPAL)).GetPtr()
+}
+
+// authorize asks the configured authorizer, everything is allowed without it. Errors deny access.
+func authorize(ctx *sfplugins.StatefunContextProcessor, req auth.AccessRequest) bool {
+	if config.Authorizer == nil {
+		return true
+	}
+
+	allowed, err := config.Authorizer.Authorize(ctx, req)
+	if err != nil {
+		slog.Warn("failed to authorize", "subject", req.Principal.Subject, "object_id", req.ObjectID, "err", err.Error())
+		return false
+	}
+
+	return allowed
+}
+
+// authorizeAttach checks whether the principal may have the object attached to a controller
+func authorizeAttach(ctx *sfplugins.StatefunContextProcessor, cmdb db.CMDBSyncClient, principal auth.Principal, objectID string) bool {
+	if config.Authorizer == nil {
+		return true
+	}
+
+	objectType, _ := common.ObjectType(cmdb, objectID)
+
+	return authorize(ctx, auth.AccessRequest{
+		Principal:  principal,
+		ObjectID:   objectID,
+		ObjectType: objectType,
+		Attach:     true,
+	})
+}
+
+// authorizeDecorator checks the property path or every function of the call before the decorator runs,
+// @each is checked by its collection decorator, children are checked in their own construct
+func authorizeDecorator(ctx *sfplugins.StatefunContextProcessor, req auth.AccessRequest, d controllerDecorator) bool {
+	switch d := d.(type) {
+	case *controllerProperty:
+		// ACLs address properties as object paths, the same way redaction rules do
+		req.Path = strings.Join(propertySegments(d.path), ".")
+		return authorize(ctx, req)
+	case *controllerFunction:
+		for _, name := range d.call.names() {
+			req.Function = name
+			if !authorize(ctx, req) {
+				return false
+			}
+		}
+
+		return true
+	case *controllerEach:
+		return authorizeDecorator(ctx, req, d.each)
+	}
+
+	return false
+}
+
+// names returns names of the call and all its nested calls
+func (b *boundCall) names() []string {
+	names := []string{b.name}
+
+	for _, v := range b.args {
+		if nested, ok := v.(*boundCall); ok {
+			names = append(names, nested.names()...)
+		}
+	}
+
+	return names
+}
+
+// constructAccess is the access request of the constructed object, the principal comes in request options
+func constructAccess(ctx *sfplugins.StatefunContextProcessor, objectID, objectType string) auth.AccessRequest {
+	return auth.AccessRequest{
+		Principal:  parsePrincipal(ctx.Options),
+		ObjectID:   objectID,
+		ObjectType: objectType,
+	}
+}
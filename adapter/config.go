@@ -0,0 +1,79 @@
+package adapter
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/foliagecp/sdk/statefun/system"
+	"github.com/foliagecp/ui-app-lib/auth"
+)
+
+// Config holds controller functions settings, use DefaultConfig as a base
+type Config struct {
+	// ReleaseGracePeriod is how long a controller without subscribers is kept alive
+	ReleaseGracePeriod time.Duration
+	// SweepInterval is how often all controllers are checked for being orphaned
+	SweepInterval time.Duration
+	// CheckUpdates skips sending controller result if it's equal to the previous one
+	CheckUpdates bool
+	// MaxIdHandlers is passed to every controller function type config, -1 means unlimited
+	MaxIdHandlers int
+	// UpdateAckWait is message ack wait of controller update function
+	UpdateAckWait time.Duration
+	// UpdateDebounce is how long controller updates are collected to be sent in one message,
+	// zero sends every update immediately. Controllers may override it with debounce_ms.
+	UpdateDebounce time.Duration
+	// UpdateMaxLatency bounds how long an update may wait while new ones keep coming, zero means no bound
+	UpdateMaxLatency time.Duration
+	// Authorizer is consulted before an object is attached to a controller and before every decorator runs,
+	// nil allows everything. It gets the principal of the session, so sessions should be authenticated.
+	Authorizer auth.Authorizer
+	// Redaction: object type -> rules of values never sent to clients, added to annotations of the type body.
+	// Types without a domain are types of this domain.
+	Redaction map[string][]RedactRule
+	// RedactMask replaces values redacted with RedactMask
+	RedactMask string
+	// MaxDecoratorCalls bounds decorator calls of one construct including constructs of @each children,
+	// the object gets QUOTA_EXCEEDED error instead of the result. Zero means unlimited.
+	MaxDecoratorCalls int
+}
+
+// DefaultConfig returns default settings, CheckUpdates is taken from UI_APP_LIB_CHECK_UPDATES env
+func DefaultConfig() Config {
+	return Config{
+		ReleaseGracePeriod: ControllerReleaseGracePeriod,
+		SweepInterval:      ControllerSweepInterval,
+		CheckUpdates:       system.GetEnvMustProceed("UI_APP_LIB_CHECK_UPDATES", true),
+		MaxIdHandlers:      -1,
+		UpdateAckWait:      30 * time.Second,
+		UpdateDebounce:     0,
+		UpdateMaxLatency:   time.Second,
+		RedactMask:         "***",
+	}
+}
+
+// config is set once by RegisterFunctions before the runtime starts
+var config = DefaultConfig()
+
+// validated replaces values which would break the runtime with defaults, e.g. a zero SweepInterval panics the sweeper.
+// Negative values of settings where zero means "disabled" or "unlimited" become zero.
+func (c Config) validated() Config {
+	def := DefaultConfig()
+
+	if c.SweepInterval <= 0 {
+		slog.Warn("invalid adapter config, default is used", "field", "SweepInterval", "value", c.SweepInterval.String())
+		c.SweepInterval = def.SweepInterval
+	}
+
+	if c.UpdateAckWait <= 0 {
+		slog.Warn("invalid adapter config, default is used", "field", "UpdateAckWait", "value", c.UpdateAckWait.String())
+		c.UpdateAckWait = def.UpdateAckWait
+	}
+
+	c.ReleaseGracePeriod = max(c.ReleaseGracePeriod, 0)
+	c.UpdateDebounce = max(c.UpdateDebounce, 0)
+	c.UpdateMaxLatency = max(c.UpdateMaxLatency, 0)
+	c.MaxDecoratorCalls = max(c.MaxDecoratorCalls, 0)
+
+	return c
+}
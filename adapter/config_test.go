@@ -0,0 +1,29 @@
+package adapter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_Validated(t *testing.T) {
+	cfg := Config{
+		ReleaseGracePeriod: -time.Second,
+		SweepInterval:      0,
+		UpdateAckWait:      -time.Second,
+		UpdateDebounce:     -time.Second,
+		UpdateMaxLatency:   -time.Second,
+		MaxDecoratorCalls:  -1,
+	}.validated()
+
+	require.Equal(t, ControllerSweepInterval, cfg.SweepInterval)
+	require.Equal(t, DefaultConfig().UpdateAckWait, cfg.UpdateAckWait)
+	require.Zero(t, cfg.ReleaseGracePeriod)
+	require.Zero(t, cfg.UpdateDebounce)
+	require.Zero(t, cfg.UpdateMaxLatency)
+	require.Zero(t, cfg.MaxDecoratorCalls)
+
+	// valid values are kept
+	require.Equal(t, DefaultConfig(), DefaultConfig().validated())
+}
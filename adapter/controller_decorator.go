@@ -2,132 +2,290 @@ package adapter
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
-	"strconv"
-	"strings"
+	"slices"
 
 	"github.com/foliagecp/easyjson"
 	sf "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/ui-app-lib/adapter/declaration"
 	inStatefun "github.com/foliagecp/ui-app-lib/internal/statefun"
+	"github.com/foliagecp/ui-app-lib/protocol"
 )
 
-// decorators
+// nested declaration keys
 const (
-	_PROPERTY = "@property"
-	_FUNCTION = "@function"
+	_EACH   = "@each"
+	_FIELDS = "fields"
+	// _CONSTRUCT_CHAIN passes ids of constructs waiting for their @each children in request options
+	_CONSTRUCT_CHAIN = "chain"
 )
 
 type controllerDecorator interface {
-	Decorate(ctx *sf.StatefunContextProcessor) easyjson.JSON
+	// Decorate builds the value, objects read on the way are added to deps
+	Decorate(ctx *sf.StatefunContextProcessor, deps dependencies) easyjson.JSON
 }
 
 type controllerProperty struct {
-	id   string
-	path string
+	id        string
+	path      string
+	redaction redaction
 }
 
-func (c *controllerProperty) Decorate(ctx *sf.StatefunContextProcessor) easyjson.JSON {
-	return ctx.GetObjectContext().GetByPath(c.path)
+func (c *controllerProperty) Decorate(ctx *sf.StatefunContextProcessor, deps dependencies) easyjson.JSON {
+	deps.add(c.id)
+	return c.redaction.apply(c.path, ctx.GetObjectContext().GetByPath(c.path))
 }
 
 type controllerFunction struct {
-	id       string
-	function string
-	args     []string
+	id   string
+	call *boundCall
+	// withheld is set if the last result couldn't be redacted, so it isn't returned
+	withheld bool
 }
 
-func (c *controllerFunction) Decorate(ctx *sf.StatefunContextProcessor) easyjson.JSON {
-	switch c.function {
-	case "getChildrenUUIDSByLinkType":
-		lt := ""
-		if len(c.args) > 0 {
-			lt = c.args[0]
+func (c *controllerFunction) Decorate(ctx *sf.StatefunContextProcessor, deps dependencies) easyjson.JSON {
+	c.withheld = false
+
+	read := make(dependencies)
+
+	result, err := c.call.eval(ctx, c.id, read)
+	deps.add(read.list()...)
+
+	if err != nil {
+		slog.Warn("@function failed", "function", c.call.name, "id", c.id, "err", err.Error())
+		return easyjson.NewJSONNull()
+	}
+
+	redacted, ok := redactResult(ctx, c.call.fn.Result, result, read)
+	if !ok {
+		c.withheld = true
+		return easyjson.NewJSONNull()
+	}
+
+	return redacted
+}
+
+// controllerEach applies the sub-declaration to every object returned by the collection decorator,
+// the result is an object: child id -> constructed sub-declaration
+type controllerEach struct {
+	id     string
+	each   controllerDecorator
+	fields easyjson.JSON
+	// deniedPaths of the last Decorate: "<child id>.<key>"
+	deniedPaths []string
+	// budget of the parent construct, children spend it too
+	budget *callBudget
+}
+
+func (c *controllerEach) denied() []string {
+	return c.deniedPaths
+}
+
+func (c *controllerEach) Decorate(ctx *sf.StatefunContextProcessor, deps dependencies) easyjson.JSON {
+	c.deniedPaths = nil
+
+	ids, ok := c.each.Decorate(ctx, deps).AsArrayString()
+	if !ok {
+		slog.Warn("@each: collection is not a list of ids", "id", c.id)
+		return easyjson.NewJSONNull()
+	}
+
+	construct := easyjson.NewJSONObject()
+
+	// object can't be constructed from inside of its own construct or constructs waiting for it
+	chain := append(constructChain(ctx.Options), c.id)
+
+	for _, childID := range ids {
+		if slices.Contains(chain, childID) || construct.PathExists(childID) {
+			continue
+		}
+
+		budget := c.budget
+		if budget == nil {
+			budget = newCallBudget(ctx.Options)
+			c.budget = budget
 		}
 
-		children := getChildrenUUIDSByLinkType(ctx, c.id, lt)
-		return easyjson.JSONFromArray(children)
-	case "getInOutLinkTypes":
-		out := getInOutLinkTypes(ctx, c.id)
-		return easyjson.JSONFromArray(out)
-	case "getOutLinkTypes":
-		out := getOutLinkTypes(ctx, c.id)
-		return easyjson.JSONFromArray(out)
-	case "getLinksByType":
-		if len(c.args) != 1 {
-			return easyjson.NewJSON("invalid arguments")
+		if !budget.take(1) {
+			break
 		}
 
-		lt := c.args[0]
-		out := getLinksByType(ctx, c.id, lt)
-		return easyjson.NewJSON(out)
-	case "typesNavigation":
-		if len(c.args) != 1 {
-			return easyjson.NewJSON("invalid arguments")
+		// children are constructed for the same principal and spend the same budget
+		result, err := ctx.Request(sf.AutoRequestSelect, inStatefun.CONTROLLER_CONSTRUCT, childID, &c.fields, chainOptions(budget.options(ctx.Options), chain))
+		if err != nil {
+			slog.Warn("@each: failed to construct child", "id", c.id, "child", childID, "err", err.Error())
+			continue
+		}
+
+		if result.GetByPath("result.code").AsStringDefault("") == string(protocol.ERR_QUOTA_EXCEEDED) {
+			budget.exceeded = true
+			break
 		}
 
-		radius, _ := strconv.Atoi(c.args[0])
-		return typesNavigation(ctx, c.id, radius)
+		budget.spend(int(result.GetByPath(_CONSTRUCT_CALLS).AsNumericDefault(0)))
+
+		if result.GetByPath("status").AsStringDefault("failed") != "ok" {
+			slog.Warn("@each: failed to construct child", "id", c.id, "child", childID, "err", result.GetByPath("result.message").AsStringDefault(""))
+			continue
+		}
+
+		childDeps, _ := result.GetByPath(_CONTROLLER_OBJECT_DEPENDENCIES).AsArrayString()
+		deps.add(childID)
+		deps.add(childDeps...)
+
+		construct.SetByPath(childID, result.GetByPath("result"))
+
+		childDenied, _ := result.GetByPath(_CONTROLLER_DENIED).AsArrayString()
+		for _, path := range childDenied {
+			c.deniedPaths = append(c.deniedPaths, childID+"."+path)
+		}
+	}
+
+	return construct
+}
+
+// constructChain returns ids of constructs waiting for this one, the outermost first
+func constructChain(options *easyjson.JSON) []string {
+	if options == nil {
+		return nil
 	}
 
-	return easyjson.NewJSONObject()
+	chain, _ := options.GetByPath(_CONSTRUCT_CHAIN).AsArrayString()
+
+	return chain
+}
+
+// chainOptions passes the chain to a child construct next to the other options
+func chainOptions(options *easyjson.JSON, chain []string) *easyjson.JSON {
+	child := easyjson.NewJSONObject()
+	if options != nil {
+		child = options.Clone()
+	}
+
+	child.SetByPath(_CONSTRUCT_CHAIN, easyjson.JSONFromArray(chain))
+
+	return &child
 }
 
-func parseDecorators(objectID string, payload *easyjson.JSON) map[string]controllerDecorator {
+// parseDecorators builds decorators of the declaration, every invalid decorator is reported in the joined error.
+// Values of the declaration are decorators or nested declarations:
+//
+//	{"@each": "<decorator returning ids>", "fields": {<declaration>}}
+func parseDecorators(objectID string, payload *easyjson.JSON) (map[string]controllerDecorator, error) {
 	decorators := make(map[string]controllerDecorator)
 
-	rawDecorators := make(map[string]string)
+	rawDecorators := make(map[string]json.RawMessage)
 	if err := json.Unmarshal(payload.ToBytes(), &rawDecorators); err != nil {
-		return decorators
+		return decorators, fmt.Errorf("declaration must be an object: %w", err)
 	}
 
-	for key, body := range rawDecorators {
-		tokens := strings.Split(body, ":")
+	errs := make([]error, 0)
 
-		if len(tokens) < 2 {
-			continue
+	for key, raw := range rawDecorators {
+		var (
+			decorator controllerDecorator
+			err       error
+			body      string
+		)
+
+		if json.Unmarshal(raw, &body) == nil {
+			decorator, err = parseDecorator(objectID, body)
+		} else {
+			decorator, err = parseEach(objectID, raw)
 		}
 
-		decorator := tokens[0]
-		value := tokens[1]
-
-		switch decorator {
-		case _PROPERTY:
-			// TODO: add check value
-			decorators[key] = &controllerProperty{
-				id:   objectID,
-				path: value,
-			}
-		case _FUNCTION:
-			f, args, err := extractFunctionAndArgs(value)
-			if err != nil {
-				slog.Warn(err.Error())
-				continue
-			}
-
-			decorators[key] = &controllerFunction{
-				id:       objectID,
-				function: f,
-				args:     args,
-			}
-		default:
-			slog.Warn("parse decorator: unknown decorator", "decorator", decorator)
+		if err != nil {
+			errs = append(errs, prefixErrors(key+": ", err))
+			continue
 		}
+
+		decorators[key] = decorator
+	}
+
+	return decorators, errors.Join(errs...)
+}
+
+func parseDecorator(objectID, body string) (controllerDecorator, error) {
+	decorator, err := declaration.Parse(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if decorator.Kind == declaration.PROPERTY {
+		return &controllerProperty{
+			id:   objectID,
+			path: decorator.Path,
+		}, nil
+	}
+
+	call, err := bindCall(decorator.Call)
+	if err != nil {
+		return nil, err
 	}
 
-	return decorators
+	return &controllerFunction{
+		id:   objectID,
+		call: call,
+	}, nil
 }
 
-func extractFunctionAndArgs(s string) (string, []string, error) {
-	split := strings.Split(strings.TrimRight(s, ")"), "(")
-	if len(split) != 2 {
-		return "", nil, fmt.Errorf("@function: invalid function format: %s", s)
+func parseEach(objectID string, raw json.RawMessage) (controllerDecorator, error) {
+	var nested struct {
+		Each   *string         `json:"@each"`
+		Fields json.RawMessage `json:"fields"`
+	}
+
+	if err := json.Unmarshal(raw, &nested); err != nil {
+		return nil, fmt.Errorf("value must be a decorator or a nested declaration")
+	}
+
+	if nested.Each == nil {
+		return nil, fmt.Errorf("nested declaration: missing %s", _EACH)
+	}
+
+	each, err := parseDecorator(objectID, *nested.Each)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", _EACH, err)
+	}
+
+	fields, ok := easyjson.JSONFromBytes(nested.Fields)
+	if !ok || !fields.IsObject() {
+		return nil, fmt.Errorf("nested declaration: %s must be an object", _FIELDS)
 	}
 
-	funcName := split[0]
-	funcArgs := strings.Split(strings.TrimSpace(split[1]), ",")
+	// fields are constructed on children, only validate them here
+	if _, err := parseDecorators("", &fields); err != nil {
+		return nil, prefixErrors(_FIELDS+": ", err)
+	}
+
+	return &controllerEach{
+		id:     objectID,
+		each:   each,
+		fields: fields,
+	}, nil
+}
+
+// prefixErrors prefixes every error joined in err, so nested keys are reported with their full path
+func prefixErrors(prefix string, err error) error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return fmt.Errorf("%s%w", prefix, err)
+	}
+
+	errs := make([]error, 0)
+	for _, e := range joined.Unwrap() {
+		errs = append(errs, fmt.Errorf("%s%w", prefix, e))
+	}
+
+	return errors.Join(errs...)
+}
 
-	return funcName, funcArgs, nil
+// validateDeclaration reports declaration errors before the controller is started
+func validateDeclaration(payload *easyjson.JSON) error {
+	_, err := parseDecorators("", payload)
+	return err
 }
 
 func getChildrenUUIDSByLinkType(ctx *sf.StatefunContextProcessor, id, filterLinkType string) []string {
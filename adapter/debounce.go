@@ -0,0 +1,234 @@
+package adapter
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/foliagecp/sdk/clients/go/db"
+	"github.com/foliagecp/sdk/statefun"
+	sfplugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/ui-app-lib/internal/egress"
+	inStatefun "github.com/foliagecp/ui-app-lib/internal/statefun"
+)
+
+const (
+	// _CONTROLLER_DEBOUNCE overrides Config.UpdateDebounce for the controller, in milliseconds
+	_CONTROLLER_DEBOUNCE = "debounce_ms"
+
+	_PENDING       = "pending"
+	_PENDING_SINCE = "pending_since"
+	_PENDING_UNTIL = "pending_until"
+	_FLUSH         = "flush"
+	_RESCHEDULE    = "reschedule"
+)
+
+// debouncer fires a single timer per controller, rescheduling replaces the previous timer
+type debouncer struct {
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+	fire   func(controllerID string)
+}
+
+func newDebouncer() *debouncer {
+	return &debouncer{
+		timers: make(map[string]*time.Timer),
+	}
+}
+
+func (d *debouncer) Schedule(controllerID string, at time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if t, ok := d.timers[controllerID]; ok {
+		t.Stop()
+	}
+
+	var t *time.Timer
+	t = time.AfterFunc(time.Until(at), func() {
+		d.mu.Lock()
+		if d.timers[controllerID] == t {
+			delete(d.timers, controllerID)
+		}
+		d.mu.Unlock()
+
+		if d.fire != nil {
+			d.fire(controllerID)
+		}
+	})
+
+	d.timers[controllerID] = t
+}
+
+func (d *debouncer) Cancel(controllerID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if t, ok := d.timers[controllerID]; ok {
+		t.Stop()
+		delete(d.timers, controllerID)
+	}
+}
+
+var updateDebouncer = newDebouncer()
+
+func startDebouncer(runtime *statefun.Runtime) {
+	updateDebouncer.fire = func(controllerID string) {
+		signalFlush(runtime.Signal, controllerID)
+	}
+}
+
+// restoreDebouncer asks every controller to reschedule its pending updates, the timers are lost with the previous process
+func restoreDebouncer(runtime *statefun.Runtime) error {
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(runtime.Request)
+	if err != nil {
+		return err
+	}
+
+	controllers, err := controllerIDs(runtime, cmdb)
+	if err != nil {
+		return err
+	}
+
+	payload := easyjson.NewJSONObjectWithKeyValue(_RESCHEDULE, easyjson.NewJSON(true))
+
+	for _, controllerID := range controllers {
+		if err := runtime.Signal(sfplugins.JetstreamGlobalSignal, inStatefun.CONTROLLER_UPDATE, controllerID, &payload, nil); err != nil {
+			slog.Warn("failed to reschedule controller updates", "id", controllerID, "err", err.Error())
+		}
+	}
+
+	return nil
+}
+
+func signalFlush(signal sfplugins.SFSignalFunc, controllerID string) {
+	payload := easyjson.NewJSONObjectWithKeyValue(_FLUSH, easyjson.NewJSON(true))
+
+	if err := signal(sfplugins.JetstreamGlobalSignal, inStatefun.CONTROLLER_UPDATE, controllerID, &payload, nil); err != nil {
+		slog.Warn("failed to flush controller updates", "id", controllerID, "err", err.Error())
+	}
+}
+
+// updateDebounce returns debounce window of the controller, zero means updates are sent immediately
+func updateDebounce(body *easyjson.JSON) time.Duration {
+	if ms, ok := body.GetByPath(_CONTROLLER_DEBOUNCE).AsNumeric(); ok {
+		return time.Duration(ms) * time.Millisecond
+	}
+
+	return config.UpdateDebounce
+}
+
+/*
+addPending keeps the update of the object until the flush, following updates of the object are merged into it.
+The flush happens when no updates come during the window, but not later than Config.UpdateMaxLatency
+after the first pending update.
+
+Pending updates are kept in the function context:
+
+	{
+		"pending": {
+			"<object id>": {...} // objectUpdate
+		},
+		"pending_since": int, // unix milliseconds of the first pending update
+		"pending_until": int // unix milliseconds of the flush, the timer is rescheduled from it after restart
+	}
+*/
+func addPending(ctx *sfplugins.StatefunContextProcessor, update objectUpdate, window time.Duration) {
+	now := time.Now()
+
+	fctx := ctx.GetFunctionContext()
+	if !fctx.GetByPath(_PENDING).IsNonEmptyObject() {
+		fctx.SetByPath(_PENDING, easyjson.NewJSONObject())
+		fctx.SetByPath(_PENDING_SINCE, easyjson.NewJSON(now.UnixMilli()))
+	}
+
+	path := _PENDING + "." + update.ObjectID
+	if fctx.PathExists(path) {
+		if prev, err := parseUpdate(fctx.GetByPath(path)); err == nil {
+			update = prev.merge(update)
+		}
+	}
+
+	fctx.SetByPath(path, update.toJSON())
+
+	since := time.UnixMilli(int64(fctx.GetByPath(_PENDING_SINCE).AsNumericDefault(float64(now.UnixMilli()))))
+
+	at := now.Add(window)
+	if limit := since.Add(config.UpdateMaxLatency); config.UpdateMaxLatency > 0 && limit.Before(at) {
+		at = limit
+	}
+
+	fctx.SetByPath(_PENDING_UNTIL, easyjson.NewJSON(at.UnixMilli()))
+	ctx.SetFunctionContext(fctx)
+
+	updateDebouncer.Schedule(ctx.Self.ID, at)
+}
+
+// reschedulePending restores the flush timer of pending updates, they are flushed at once if it's already late
+func reschedulePending(ctx *sfplugins.StatefunContextProcessor, plugin string) {
+	fctx := ctx.GetFunctionContext()
+	if !fctx.GetByPath(_PENDING).IsNonEmptyObject() {
+		return
+	}
+
+	at := time.UnixMilli(int64(fctx.GetByPath(_PENDING_UNTIL).AsNumericDefault(0)))
+	if !at.After(time.Now()) {
+		flushPending(ctx, plugin, "")
+		return
+	}
+
+	updateDebouncer.Schedule(ctx.Self.ID, at)
+}
+
+// dropPending forgets the pending update of the object, e.g. when the object has been removed
+func dropPending(ctx *sfplugins.StatefunContextProcessor, objectID string) {
+	fctx := ctx.GetFunctionContext()
+	if !fctx.PathExists(_PENDING + "." + objectID) {
+		return
+	}
+
+	fctx.RemoveByPath(_PENDING + "." + objectID)
+	ctx.SetFunctionContext(fctx)
+}
+
+// flushPending sends all pending updates in one message to every subscriber except the skipped one
+func flushPending(ctx *sfplugins.StatefunContextProcessor, plugin string, skip string) {
+	updateDebouncer.Cancel(ctx.Self.ID)
+
+	fctx := ctx.GetFunctionContext()
+	pending := fctx.GetByPath(_PENDING)
+
+	fctx.RemoveByPath(_PENDING)
+	fctx.RemoveByPath(_PENDING_SINCE)
+	fctx.RemoveByPath(_PENDING_UNTIL)
+	ctx.SetFunctionContext(fctx)
+
+	if !pending.IsNonEmptyObject() {
+		return
+	}
+
+	updates := make(map[string]objectUpdate)
+	for _, objectID := range pending.ObjectKeys() {
+		update, err := parseUpdate(pending.GetByPath(objectID))
+		if err != nil {
+			slog.Warn("invalid pending update", "object_id", objectID, "err", err.Error())
+			continue
+		}
+
+		updates[objectID] = update
+	}
+
+	sendUpdates(ctx, plugin, updates, skip)
+}
+
+// sendToSubscribers sends the same message to every subscriber whatever its encoding is
+func sendToSubscribers(ctx *sfplugins.StatefunContextProcessor, msg any) {
+	subscribers := getChildrenUUIDSByLinkType(ctx, ctx.Self.ID, inStatefun.SUBSCRIBER_TYPE)
+
+	for _, subID := range subscribers {
+		if err := egress.SendMessageToSession(ctx, subID, msg); err != nil {
+			slog.Warn(err.Error())
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package adapter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebouncer_Reschedule(t *testing.T) {
+	fired := make(chan string, 10)
+
+	d := newDebouncer()
+	d.fire = func(controllerID string) {
+		fired <- controllerID
+	}
+
+	d.Schedule("a", time.Now().Add(50*time.Millisecond))
+	d.Schedule("a", time.Now().Add(100*time.Millisecond))
+	d.Schedule("b", time.Now().Add(50*time.Millisecond))
+	d.Cancel("b")
+
+	select {
+	case id := <-fired:
+		require.Equal(t, "a", id)
+	case <-time.After(time.Second):
+		t.Fatal("timer didn't fire")
+	}
+
+	select {
+	case id := <-fired:
+		t.Fatalf("unexpected fire of %s", id)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestUpdateDebounce(t *testing.T) {
+	body := easyjson.NewJSONObject()
+	require.Equal(t, config.UpdateDebounce, updateDebounce(&body))
+
+	body.SetByPath(_CONTROLLER_DEBOUNCE, easyjson.NewJSON(250))
+	require.Equal(t, 250*time.Millisecond, updateDebounce(&body))
+
+	body.SetByPath(_CONTROLLER_DEBOUNCE, easyjson.NewJSON(0))
+	require.Equal(t, time.Duration(0), updateDebounce(&body))
+}
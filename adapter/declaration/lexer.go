@@ -0,0 +1,206 @@
+package declaration
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var numberPattern = regexp.MustCompile(`^[-+]?(\d+\.?\d*|\.\d+)([eE][-+]?\d+)?$`)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenNumber
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokenEOF:
+		return "end of input"
+	case tokenWord:
+		return "word"
+	case tokenString:
+		return "string"
+	case tokenNumber:
+		return "number"
+	case tokenLParen:
+		return "\"(\""
+	case tokenRParen:
+		return "\")\""
+	case tokenComma:
+		return "\",\""
+	}
+
+	return "unknown token"
+}
+
+type token struct {
+	kind tokenKind
+	// text is unescaped value of strings and raw text of other tokens
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenWord, tokenNumber:
+		return fmt.Sprintf("%s %q", t.kind, t.text)
+	case tokenString:
+		return fmt.Sprintf("string %q", t.text)
+	}
+
+	return t.kind.String()
+}
+
+// lexer splits source into tokens, positions are 1-based and counted from base
+type lexer struct {
+	src  string
+	off  int
+	base int
+}
+
+func newLexer(src string, base int) *lexer {
+	return &lexer{src: src, base: base}
+}
+
+func (l *lexer) pos() int {
+	return l.base + l.off + 1
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipSpaces()
+
+	if l.off >= len(l.src) {
+		return token{kind: tokenEOF, pos: l.pos()}, nil
+	}
+
+	pos := l.pos()
+	c := l.src[l.off]
+
+	switch c {
+	case '(':
+		l.off++
+		return token{kind: tokenLParen, text: "(", pos: pos}, nil
+	case ')':
+		l.off++
+		return token{kind: tokenRParen, text: ")", pos: pos}, nil
+	case ',':
+		l.off++
+		return token{kind: tokenComma, text: ",", pos: pos}, nil
+	case '"', '\'':
+		return l.string(c)
+	}
+
+	return l.word(), nil
+}
+
+func (l *lexer) skipSpaces() {
+	for l.off < len(l.src) && isSpace(l.src[l.off]) {
+		l.off++
+	}
+}
+
+// word reads everything up to a space or a delimiter, words which look like numbers are numbers
+func (l *lexer) word() token {
+	pos := l.pos()
+	start := l.off
+
+	for l.off < len(l.src) && !isSpace(l.src[l.off]) && !isDelimiter(l.src[l.off]) {
+		l.off++
+	}
+
+	text := l.src[start:l.off]
+
+	if numberPattern.MatchString(text) {
+		return token{kind: tokenNumber, text: text, pos: pos}
+	}
+
+	return token{kind: tokenWord, text: text, pos: pos}
+}
+
+func (l *lexer) string(quote byte) (token, error) {
+	pos := l.pos()
+	l.off++
+
+	var sb strings.Builder
+
+	for {
+		if l.off >= len(l.src) {
+			return token{}, &SyntaxError{Pos: pos, Msg: "unterminated string"}
+		}
+
+		c := l.src[l.off]
+
+		switch c {
+		case quote:
+			l.off++
+			return token{kind: tokenString, text: sb.String(), pos: pos}, nil
+		case '\\':
+			escapePos := l.pos()
+			l.off++
+
+			if l.off >= len(l.src) {
+				return token{}, &SyntaxError{Pos: pos, Msg: "unterminated string"}
+			}
+
+			r, err := l.escape()
+			if err != nil {
+				return token{}, &SyntaxError{Pos: escapePos, Msg: err.Error()}
+			}
+
+			sb.WriteRune(r)
+		default:
+			r, size := utf8.DecodeRuneInString(l.src[l.off:])
+			sb.WriteRune(r)
+			l.off += size
+		}
+	}
+}
+
+func (l *lexer) escape() (rune, error) {
+	c := l.src[l.off]
+	l.off++
+
+	switch c {
+	case '"', '\'', '\\', '/':
+		return rune(c), nil
+	case 'n':
+		return '\n', nil
+	case 't':
+		return '\t', nil
+	case 'r':
+		return '\r', nil
+	case 'u':
+		if l.off+4 > len(l.src) {
+			return 0, fmt.Errorf("invalid unicode escape")
+		}
+
+		code, err := strconv.ParseUint(l.src[l.off:l.off+4], 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid unicode escape")
+		}
+
+		l.off += 4
+
+		return rune(code), nil
+	}
+
+	return 0, fmt.Errorf("unknown escape \\%c", c)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isDelimiter(c byte) bool {
+	return c == '(' || c == ')' || c == ',' || c == '"' || c == '\''
+}
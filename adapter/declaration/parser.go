@@ -0,0 +1,250 @@
+/*
+Package declaration parses decorators of controller declarations:
+
+	decorator = "@property:" path | "@function:" call
+	path      = [ string | raw text up to the end, colons included ]   // empty path is the whole object body
+	call      = name "(" [ arg { "," arg } ] ")"
+	arg       = string | number | word | call
+	string    = '"' chars '"' | "'" chars "'"   // \" \' \\ \/ \n \t \r \uXXXX escapes
+	number    = [+-] digits [ "." digits ] [ ("e"|"E") [+-] digits ]
+	word      = any chars except spaces and ( ) , " '
+
+Words are untyped literals, e.g. link types in getChildrenUUIDSByLinkType(child).
+*/
+package declaration
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	PROPERTY = "@property"
+	FUNCTION = "@function"
+)
+
+// SyntaxError points at the place in the decorator source where parsing failed
+type SyntaxError struct {
+	// Pos is 1-based byte offset in the decorator source
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at position %d: %s", e.Pos, e.Msg)
+}
+
+type Decorator struct {
+	// Kind is PROPERTY or FUNCTION
+	Kind string
+	// Path of PROPERTY decorator
+	Path string
+	// Call of FUNCTION decorator
+	Call *Call
+}
+
+type Call struct {
+	Name string
+	Args []Arg
+	Pos  int
+}
+
+type ArgKind int
+
+const (
+	ArgString ArgKind = iota
+	ArgNumber
+	ArgWord
+	ArgCall
+)
+
+type Arg struct {
+	Kind ArgKind
+	// Value is unescaped string, raw number or word, empty for calls
+	Value string
+	Call  *Call
+	Pos   int
+}
+
+// Parse parses a single decorator, e.g. "@function:getLinksByType('parent')"
+func Parse(src string) (*Decorator, error) {
+	start := len(src) - len(strings.TrimLeft(src, " \t\r\n"))
+
+	if start >= len(src) || src[start] != '@' {
+		return nil, &SyntaxError{Pos: start + 1, Msg: "decorator must start with @"}
+	}
+
+	colon := strings.IndexByte(src[start:], ':')
+	if colon < 0 {
+		return nil, &SyntaxError{Pos: len(src) + 1, Msg: "expected \":\" after decorator name"}
+	}
+
+	kind := src[start : start+colon]
+	rest := start + colon + 1
+
+	switch kind {
+	case PROPERTY:
+		path, err := parsePath(src[rest:], rest)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Decorator{Kind: PROPERTY, Path: path}, nil
+	case FUNCTION:
+		call, err := ParseCall(src[rest:], rest)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Decorator{Kind: FUNCTION, Call: call}, nil
+	}
+
+	return nil, &SyntaxError{Pos: start + 1, Msg: fmt.Sprintf("unknown decorator %s", kind)}
+}
+
+func parsePath(src string, base int) (string, error) {
+	trimmed := strings.TrimSpace(src)
+	if trimmed == "" {
+		return "", nil
+	}
+
+	if trimmed[0] != '"' && trimmed[0] != '\'' {
+		return trimmed, nil
+	}
+
+	p := &parser{lex: newLexer(src, base)}
+
+	if err := p.advance(); err != nil {
+		return "", err
+	}
+
+	path := p.tok.text
+
+	if err := p.advance(); err != nil {
+		return "", err
+	}
+
+	if p.tok.kind != tokenEOF {
+		return "", p.unexpected("end of input")
+	}
+
+	return path, nil
+}
+
+// ParseCall parses a function call, base is added to error positions
+func ParseCall(src string, base int) (*Call, error) {
+	p := &parser{lex: newLexer(src, base)}
+
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	call, err := p.call()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind != tokenEOF {
+		return nil, p.unexpected("end of input")
+	}
+
+	return call, nil
+}
+
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+
+	p.tok = tok
+
+	return nil
+}
+
+func (p *parser) unexpected(want string) error {
+	return &SyntaxError{Pos: p.tok.pos, Msg: fmt.Sprintf("expected %s, got %s", want, p.tok)}
+}
+
+func (p *parser) call() (*Call, error) {
+	if p.tok.kind != tokenWord {
+		return nil, p.unexpected("function name")
+	}
+
+	call := &Call{Name: p.tok.text, Pos: p.tok.pos, Args: make([]Arg, 0)}
+
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind != tokenLParen {
+		return nil, p.unexpected("\"(\"")
+	}
+
+	return p.args(call)
+}
+
+// args parses call arguments, the current token is "("
+func (p *parser) args(call *Call) (*Call, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind == tokenRParen {
+		return call, p.advance()
+	}
+
+	for {
+		arg, err := p.arg()
+		if err != nil {
+			return nil, err
+		}
+
+		call.Args = append(call.Args, arg)
+
+		switch p.tok.kind {
+		case tokenComma:
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		case tokenRParen:
+			return call, p.advance()
+		default:
+			return nil, p.unexpected("\",\" or \")\"")
+		}
+	}
+}
+
+func (p *parser) arg() (Arg, error) {
+	tok := p.tok
+
+	switch tok.kind {
+	case tokenString:
+		return Arg{Kind: ArgString, Value: tok.text, Pos: tok.pos}, p.advance()
+	case tokenNumber:
+		return Arg{Kind: ArgNumber, Value: tok.text, Pos: tok.pos}, p.advance()
+	case tokenWord:
+		if err := p.advance(); err != nil {
+			return Arg{}, err
+		}
+
+		if p.tok.kind != tokenLParen {
+			return Arg{Kind: ArgWord, Value: tok.text, Pos: tok.pos}, nil
+		}
+
+		// word followed by "(" is a nested call
+		call, err := p.args(&Call{Name: tok.text, Pos: tok.pos, Args: make([]Arg, 0)})
+		if err != nil {
+			return Arg{}, err
+		}
+
+		return Arg{Kind: ArgCall, Call: call, Pos: tok.pos}, nil
+	}
+
+	return Arg{}, p.unexpected("argument")
+}
@@ -0,0 +1,91 @@
+package declaration
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse_Property(t *testing.T) {
+	cases := map[string]string{
+		"@property:body.name":         "body.name",
+		"  @property: body.name ":     "body.name",
+		"@property:body.urn:ietf:x":   "body.urn:ietf:x",
+		`@property:"body.a, b (c)"`:   "body.a, b (c)",
+		`@property:'body.\'quoted\''`: "body.'quoted'",
+		`@property:"body.A\t\\\/"`:    "body.A\t\\/",
+		"@property:":                  "",
+		"@property:  ":                "",
+	}
+
+	for src, path := range cases {
+		t.Run(src, func(t *testing.T) {
+			d, err := Parse(src)
+			require.NoError(t, err)
+			require.Equal(t, PROPERTY, d.Kind)
+			require.Equal(t, path, d.Path)
+		})
+	}
+}
+
+func TestParse_Function(t *testing.T) {
+	d, err := Parse(`@function:f(word, "a, (b)", 'c\'d', -1.5e3, g(), h(1, i("x")))`)
+	require.NoError(t, err)
+	require.Equal(t, FUNCTION, d.Kind)
+
+	call := d.Call
+	require.Equal(t, "f", call.Name)
+	require.Equal(t, 11, call.Pos)
+	require.Len(t, call.Args, 6)
+
+	require.Equal(t, Arg{Kind: ArgWord, Value: "word", Pos: 13}, call.Args[0])
+	require.Equal(t, Arg{Kind: ArgString, Value: "a, (b)", Pos: 19}, call.Args[1])
+	require.Equal(t, Arg{Kind: ArgString, Value: "c'd", Pos: 29}, call.Args[2])
+	require.Equal(t, Arg{Kind: ArgNumber, Value: "-1.5e3", Pos: 37}, call.Args[3])
+
+	require.Equal(t, ArgCall, call.Args[4].Kind)
+	require.Equal(t, "g", call.Args[4].Call.Name)
+	require.Empty(t, call.Args[4].Call.Args)
+
+	h := call.Args[5].Call
+	require.Equal(t, "h", h.Name)
+	require.Len(t, h.Args, 2)
+	require.Equal(t, ArgNumber, h.Args[0].Kind)
+	require.Equal(t, "i", h.Args[1].Call.Name)
+	require.Equal(t, Arg{Kind: ArgString, Value: "x", Pos: 57}, h.Args[1].Call.Args[0])
+}
+
+func TestParse_Errors(t *testing.T) {
+	cases := []struct {
+		src string
+		pos int
+	}{
+		{"body.name", 1},
+		{"  value", 3},
+		{"@property", 10},
+		{"@unknown:value", 1},
+		{"@function:f", 12},
+		{"@function:f(a", 14},
+		{"@function:f(a b)", 15},
+		{"@function:f(a,)", 15},
+		{"@function:f(a))", 15},
+		{`@function:f("abc`, 13},
+		{`@function:f("\q")`, 14},
+		{`@function:f("\u00zz")`, 14},
+		{`@function:f(g(1)`, 17},
+		{"@function:(a)", 11},
+		{`@property:"a" b`, 15},
+	}
+
+	for _, c := range cases {
+		t.Run(c.src, func(t *testing.T) {
+			_, err := Parse(c.src)
+			require.Error(t, err)
+
+			var syntaxErr *SyntaxError
+			require.True(t, errors.As(err, &syntaxErr), err.Error())
+			require.Equal(t, c.pos, syntaxErr.Pos, err.Error())
+		})
+	}
+}
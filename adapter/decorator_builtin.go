@@ -0,0 +1,76 @@
+package adapter
+
+import (
+	"github.com/foliagecp/easyjson"
+)
+
+// built-in functions return ids, link types and links, no values of objects
+func init() {
+	builtin := map[string]DecoratorFunction{
+		"getChildrenUUIDSByLinkType": {
+			Args:   []DecoratorArg{{Name: "link_type", Type: ArgString, Optional: true}},
+			Result: ResultPlain,
+			Handler: func(dctx *DecoratorContext) (easyjson.JSON, error) {
+				children := getChildrenUUIDSByLinkType(dctx.Ctx, dctx.ObjectID, dctx.String(0, ""))
+				dctx.Depend(children...)
+				return easyjson.JSONFromArray(children), nil
+			},
+		},
+		"getInOutLinkTypes": {
+			Result: ResultPlain,
+			Handler: func(dctx *DecoratorContext) (easyjson.JSON, error) {
+				return easyjson.JSONFromArray(getInOutLinkTypes(dctx.Ctx, dctx.ObjectID)), nil
+			},
+		},
+		"getOutLinkTypes": {
+			Result: ResultPlain,
+			Handler: func(dctx *DecoratorContext) (easyjson.JSON, error) {
+				return easyjson.JSONFromArray(getOutLinkTypes(dctx.Ctx, dctx.ObjectID)), nil
+			},
+		},
+		"getLinksByType": {
+			Args:   []DecoratorArg{{Name: "link_type", Type: ArgString}},
+			Result: ResultPlain,
+			Handler: func(dctx *DecoratorContext) (easyjson.JSON, error) {
+				links := getLinksByType(dctx.Ctx, dctx.ObjectID, dctx.String(0, ""))
+				// in links are changed on their sources, so both ends are watched
+				for _, l := range links {
+					dctx.Depend(l.Source, l.Target)
+				}
+				return easyjson.NewJSON(links), nil
+			},
+		},
+		"typesNavigation": {
+			Args:   []DecoratorArg{{Name: "radius", Type: ArgInt}},
+			Result: ResultPlain,
+			Handler: func(dctx *DecoratorContext) (easyjson.JSON, error) {
+				nav := typesNavigation(dctx.Ctx, dctx.ObjectID, dctx.Int(0, 0))
+				dctx.Depend(navigationObjects(nav)...)
+				return nav, nil
+			},
+		},
+	}
+
+	for name, fn := range builtin {
+		if err := RegisterDecoratorFunction(name, fn); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// navigationObjects returns ids of objects listed in the types navigation nodes
+func navigationObjects(nav easyjson.JSON) []string {
+	ids := make([]string, 0)
+
+	nodes := nav.GetByPath("nodes")
+	for i := 0; i < nodes.ArraySize(); i++ {
+		objects := nodes.ArrayElement(i).GetByPath("objects")
+		for j := 0; j < objects.ArraySize(); j++ {
+			if id, ok := objects.ArrayElement(j).GetByPath("id").AsString(); ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	return ids
+}
@@ -0,0 +1,384 @@
+package adapter
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/foliagecp/easyjson"
+	sf "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/ui-app-lib/adapter/declaration"
+)
+
+// ArgType is a type of @function argument, the raw declaration value is converted to it before the call
+type ArgType int
+
+const (
+	ArgString ArgType = iota
+	ArgInt
+	ArgNumber
+	ArgBool
+)
+
+func (t ArgType) String() string {
+	switch t {
+	case ArgString:
+		return "string"
+	case ArgInt:
+		return "int"
+	case ArgNumber:
+		return "number"
+	case ArgBool:
+		return "bool"
+	}
+
+	return "unknown"
+}
+
+// DecoratorArg describes an argument of @function
+type DecoratorArg struct {
+	Name string
+	Type ArgType
+	// Optional arguments may be omitted, only trailing arguments can be optional
+	Optional bool
+}
+
+// DecoratorContext is passed to @function handler
+type DecoratorContext struct {
+	// Ctx of CONTROLLER_CONSTRUCT running on the object
+	Ctx *sf.StatefunContextProcessor
+	// ObjectID is the object the controller is constructed for
+	ObjectID string
+	// Args are converted according to the function arguments, omitted optional arguments are absent
+	Args []any
+
+	deps dependencies
+}
+
+// Depend marks objects read by the function, the controller object is updated when any of them changes.
+// The object itself is always a dependency.
+func (d *DecoratorContext) Depend(ids ...string) {
+	if d.deps != nil {
+		d.deps.add(ids...)
+	}
+}
+
+// String returns i-th argument or def if it's absent
+func (d *DecoratorContext) String(i int, def string) string {
+	if v, ok := d.arg(i).(string); ok {
+		return v
+	}
+	return def
+}
+
+// Int returns i-th argument or def if it's absent
+func (d *DecoratorContext) Int(i int, def int) int {
+	if v, ok := d.arg(i).(int); ok {
+		return v
+	}
+	return def
+}
+
+// Number returns i-th argument or def if it's absent
+func (d *DecoratorContext) Number(i int, def float64) float64 {
+	if v, ok := d.arg(i).(float64); ok {
+		return v
+	}
+	return def
+}
+
+// Bool returns i-th argument or def if it's absent
+func (d *DecoratorContext) Bool(i int, def bool) bool {
+	if v, ok := d.arg(i).(bool); ok {
+		return v
+	}
+	return def
+}
+
+func (d *DecoratorContext) arg(i int) any {
+	if i < 0 || i >= len(d.Args) {
+		return nil
+	}
+	return d.Args[i]
+}
+
+type DecoratorHandler func(dctx *DecoratorContext) (easyjson.JSON, error)
+
+// ResultKind tells how values of a @function result are redacted before they reach clients
+type ResultKind int
+
+const (
+	// ResultUnknown results may carry values of any object in any shape, they can't be redacted,
+	// so the result is withheld if the object or any object the function depends on has redaction rules
+	ResultUnknown ResultKind = iota
+	// ResultPlain results carry no values of objects, e.g. ids, link types or links, they aren't redacted
+	ResultPlain
+	// ResultObjects results are objects: object id -> object body, every body is redacted by rules of its own type
+	ResultObjects
+)
+
+// DecoratorFunction is a function available in controller declarations as @function:name(args...)
+type DecoratorFunction struct {
+	Args    []DecoratorArg
+	Handler DecoratorHandler
+	// Result is the kind of the result, functions returning values of objects should set ResultObjects
+	Result ResultKind
+}
+
+var decoratorRegistry = struct {
+	sync.RWMutex
+	functions map[string]DecoratorFunction
+}{
+	functions: make(map[string]DecoratorFunction),
+}
+
+// RegisterDecoratorFunction makes the function available in controller declarations,
+// it should be called before the runtime starts
+func RegisterDecoratorFunction(name string, fn DecoratorFunction) error {
+	if name == "" {
+		return fmt.Errorf("decorator function: empty name")
+	}
+
+	if fn.Handler == nil {
+		return fmt.Errorf("decorator function %s: nil handler", name)
+	}
+
+	optional := false
+	for _, arg := range fn.Args {
+		if optional && !arg.Optional {
+			return fmt.Errorf("decorator function %s: required argument %s follows optional one", name, arg.Name)
+		}
+		optional = arg.Optional
+	}
+
+	decoratorRegistry.Lock()
+	defer decoratorRegistry.Unlock()
+
+	if _, ok := decoratorRegistry.functions[name]; ok {
+		return fmt.Errorf("decorator function %s: already registered", name)
+	}
+
+	decoratorRegistry.functions[name] = fn
+
+	return nil
+}
+
+/*
+RegisterDecoratorStatefun makes the statefun available in controller declarations as @function:name(args...).
+Its result is of ResultUnknown kind, so the statefun should report every object it reads in dependencies.
+The statefun is requested on the object with the named arguments:
+
+	Request: {
+		"<arg name>": value,
+		...
+	}
+
+	Response: {
+		"status": "ok" | "failed",
+		"message": string,
+		"data": any, // becomes the decorator result
+		"dependencies": []string // optional, objects read by the statefun
+	}
+*/
+func RegisterDecoratorStatefun(name, typename string, args ...DecoratorArg) error {
+	return RegisterDecoratorFunction(name, DecoratorFunction{
+		Args: args,
+		Handler: func(dctx *DecoratorContext) (easyjson.JSON, error) {
+			payload := easyjson.NewJSONObject()
+			for i, v := range dctx.Args {
+				payload.SetByPath(args[i].Name, easyjson.NewJSON(v))
+			}
+
+			result, err := requestDecoratorStatefun(dctx.Ctx, typename, dctx.ObjectID, &payload)
+			if err != nil {
+				return result, err
+			}
+
+			deps, _ := result.GetByPath("dependencies").AsArrayString()
+			dctx.Depend(deps...)
+
+			return result.GetByPath("data"), nil
+		},
+	})
+}
+
+// DecoratorFunctionNames returns names of all registered functions
+func DecoratorFunctionNames() []string {
+	decoratorRegistry.RLock()
+	defer decoratorRegistry.RUnlock()
+
+	names := make([]string, 0, len(decoratorRegistry.functions))
+	for name := range decoratorRegistry.functions {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func lookupDecoratorFunction(name string) (DecoratorFunction, bool) {
+	decoratorRegistry.RLock()
+	defer decoratorRegistry.RUnlock()
+
+	fn, ok := decoratorRegistry.functions[name]
+	return fn, ok
+}
+
+// boundCall is a parsed @function call checked against the registered function
+type boundCall struct {
+	name string
+	fn   DecoratorFunction
+	// args are converted literals and nested calls, the latter are evaluated on every call
+	args []any
+}
+
+// bindCall resolves the function of the call and its nested calls, literal arguments are converted to the declared types
+func bindCall(call *declaration.Call) (*boundCall, error) {
+	fn, ok := lookupDecoratorFunction(call.Name)
+	if !ok {
+		return nil, fmt.Errorf("@function at position %d: unknown function %s", call.Pos, call.Name)
+	}
+
+	required := 0
+	for _, arg := range fn.Args {
+		if !arg.Optional {
+			required++
+		}
+	}
+
+	if len(call.Args) < required || len(call.Args) > len(fn.Args) {
+		if required == len(fn.Args) {
+			return nil, fmt.Errorf("@function %s at position %d: expects %d arguments, got %d", call.Name, call.Pos, required, len(call.Args))
+		}
+		return nil, fmt.Errorf("@function %s at position %d: expects %d to %d arguments, got %d", call.Name, call.Pos, required, len(fn.Args), len(call.Args))
+	}
+
+	args := make([]any, 0, len(call.Args))
+
+	for i, raw := range call.Args {
+		arg := fn.Args[i]
+
+		if raw.Kind == declaration.ArgCall {
+			nested, err := bindCall(raw.Call)
+			if err != nil {
+				return nil, err
+			}
+
+			args = append(args, nested)
+			continue
+		}
+
+		converted, err := convertArg(arg.Type, raw.Value)
+		if err != nil {
+			return nil, fmt.Errorf("@function %s: argument %s at position %d: %w", call.Name, arg.Name, raw.Pos, err)
+		}
+
+		args = append(args, converted)
+	}
+
+	return &boundCall{name: call.Name, fn: fn, args: args}, nil
+}
+
+// eval evaluates nested calls first and passes their results as arguments
+func (b *boundCall) eval(ctx *sf.StatefunContextProcessor, objectID string, deps dependencies) (easyjson.JSON, error) {
+	deps.add(objectID)
+
+	args := make([]any, 0, len(b.args))
+
+	for i, v := range b.args {
+		nested, ok := v.(*boundCall)
+		if !ok {
+			args = append(args, v)
+			continue
+		}
+
+		result, err := nested.eval(ctx, objectID, deps)
+		if err != nil {
+			return easyjson.JSON{}, err
+		}
+
+		arg := b.fn.Args[i]
+
+		converted, err := convertResult(arg.Type, result)
+		if err != nil {
+			return easyjson.JSON{}, fmt.Errorf("@function %s: argument %s: result of %s: %w", b.name, arg.Name, nested.name, err)
+		}
+
+		args = append(args, converted)
+	}
+
+	return b.fn.Handler(&DecoratorContext{
+		Ctx:      ctx,
+		ObjectID: objectID,
+		Args:     args,
+		deps:     deps,
+	})
+}
+
+func convertArg(t ArgType, value string) (any, error) {
+	switch t {
+	case ArgString:
+		return value, nil
+	case ArgInt:
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not %s", value, t)
+		}
+		return v, nil
+	case ArgNumber:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not %s", value, t)
+		}
+		return v, nil
+	case ArgBool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not %s", value, t)
+		}
+		return v, nil
+	}
+
+	return nil, fmt.Errorf("unknown argument type %d", t)
+}
+
+// convertResult converts a nested call result to the argument type
+func convertResult(t ArgType, value easyjson.JSON) (any, error) {
+	switch t {
+	case ArgString:
+		if v, ok := value.AsString(); ok {
+			return v, nil
+		}
+	case ArgInt:
+		if v, ok := value.AsNumeric(); ok && v == math.Trunc(v) {
+			return int(v), nil
+		}
+	case ArgNumber:
+		if v, ok := value.AsNumeric(); ok {
+			return v, nil
+		}
+	case ArgBool:
+		if v, ok := value.AsBool(); ok {
+			return v, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s is not %s", value.ToString(), t)
+}
+
+// requestDecoratorStatefun returns the whole successful response
+func requestDecoratorStatefun(ctx *sf.StatefunContextProcessor, typename, id string, payload *easyjson.JSON) (easyjson.JSON, error) {
+	result, err := ctx.Request(sf.AutoRequestSelect, typename, id, payload, nil)
+	if err != nil {
+		return easyjson.JSON{}, err
+	}
+
+	if result.GetByPath("status").AsStringDefault("failed") == "failed" {
+		return easyjson.JSON{}, fmt.Errorf("%s: %s", typename, result.GetByPath("message").AsStringDefault("failed"))
+	}
+
+	return *result, nil
+}
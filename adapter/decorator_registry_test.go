@@ -0,0 +1,200 @@
+package adapter
+
+import (
+	"testing"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterDecoratorFunction(t *testing.T) {
+	handler := func(dctx *DecoratorContext) (easyjson.JSON, error) {
+		return easyjson.NewJSON(dctx.ObjectID + ":" + dctx.String(0, "") + ":" + dctx.String(1, "none")), nil
+	}
+
+	err := RegisterDecoratorFunction("test.echo", DecoratorFunction{
+		Result: ResultPlain,
+		Args: []DecoratorArg{
+			{Name: "a", Type: ArgString},
+			{Name: "b", Type: ArgString, Optional: true},
+		},
+		Handler: handler,
+	})
+	require.NoError(t, err)
+	require.Contains(t, DecoratorFunctionNames(), "test.echo")
+
+	err = RegisterDecoratorFunction("test.echo", DecoratorFunction{Handler: handler})
+	require.Error(t, err)
+
+	err = RegisterDecoratorFunction("test.nil", DecoratorFunction{})
+	require.Error(t, err)
+
+	err = RegisterDecoratorFunction("test.order", DecoratorFunction{
+		Result: ResultPlain,
+		Args: []DecoratorArg{
+			{Name: "a", Type: ArgString, Optional: true},
+			{Name: "b", Type: ArgString},
+		},
+		Handler: handler,
+	})
+	require.Error(t, err)
+
+	declaration := easyjson.NewJSONObject()
+	declaration.SetByPath("one", easyjson.NewJSON("@function:test.echo(x)"))
+	declaration.SetByPath("two", easyjson.NewJSON("@function:test.echo( x , y )"))
+
+	decorators, err := parseDecorators("obj", &declaration)
+	require.NoError(t, err)
+
+	require.Equal(t, "obj:x:none", decorators["one"].Decorate(nil, make(dependencies)).AsStringDefault(""))
+	require.Equal(t, "obj:x:y", decorators["two"].Decorate(nil, make(dependencies)).AsStringDefault(""))
+}
+
+func TestParseDecorators_Errors(t *testing.T) {
+	cases := map[string]string{
+		"unknown function":   "@function:notExists()",
+		"unknown decorator":  "@unknown:value",
+		"invalid format":     "value",
+		"missing argument":   "@function:getLinksByType()",
+		"too many arguments": "@function:getOutLinkTypes(a)",
+		"invalid int":        "@function:typesNavigation(far)",
+		"unknown nested":     "@function:typesNavigation(notExists())",
+		"syntax":             "@function:getLinksByType(a b)",
+	}
+
+	for name, body := range cases {
+		t.Run(name, func(t *testing.T) {
+			declaration := easyjson.NewJSONObjectWithKeyValue("key", easyjson.NewJSON(body))
+
+			err := validateDeclaration(&declaration)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestParseDecorators_Builtin(t *testing.T) {
+	declaration := easyjson.NewJSONObject()
+	declaration.SetByPath("a", easyjson.NewJSON("@function:getChildrenUUIDSByLinkType()"))
+	declaration.SetByPath("b", easyjson.NewJSON("@function:getChildrenUUIDSByLinkType(child)"))
+	declaration.SetByPath("c", easyjson.NewJSON("@function:getInOutLinkTypes()"))
+	declaration.SetByPath("d", easyjson.NewJSON("@function:typesNavigation(2)"))
+	declaration.SetByPath("e", easyjson.NewJSON("@property:body.key"))
+
+	decorators, err := parseDecorators("obj", &declaration)
+	require.NoError(t, err)
+	require.Len(t, decorators, 5)
+
+	nav := decorators["d"].(*controllerFunction)
+	require.Equal(t, []any{2}, nav.call.args)
+}
+
+func TestParseDecorators_NestedCalls(t *testing.T) {
+	err := RegisterDecoratorFunction("test.join", DecoratorFunction{
+		Result: ResultPlain,
+		Args: []DecoratorArg{
+			{Name: "a", Type: ArgString},
+			{Name: "b", Type: ArgString},
+		},
+		Handler: func(dctx *DecoratorContext) (easyjson.JSON, error) {
+			return easyjson.NewJSON(dctx.String(0, "") + "|" + dctx.String(1, "")), nil
+		},
+	})
+	require.NoError(t, err)
+
+	err = RegisterDecoratorFunction("test.double", DecoratorFunction{
+		Result: ResultPlain,
+		Args:   []DecoratorArg{{Name: "n", Type: ArgInt}},
+		Handler: func(dctx *DecoratorContext) (easyjson.JSON, error) {
+			return easyjson.NewJSON(dctx.Int(0, 0) * 2), nil
+		},
+	})
+	require.NoError(t, err)
+
+	declaration := easyjson.NewJSONObject()
+	declaration.SetByPath("quoted", easyjson.NewJSON(`@function:test.join("a, (b)", 'c')`))
+	declaration.SetByPath("nested", easyjson.NewJSON(`@function:test.join(test.join(x, y), z)`))
+	declaration.SetByPath("typed", easyjson.NewJSON(`@function:test.double(test.double(2))`))
+	declaration.SetByPath("mismatch", easyjson.NewJSON(`@function:test.double(test.join(x, y))`))
+
+	decorators, err := parseDecorators("obj", &declaration)
+	require.NoError(t, err)
+
+	require.Equal(t, "a, (b)|c", decorators["quoted"].Decorate(nil, make(dependencies)).AsStringDefault(""))
+	require.Equal(t, "x|y|z", decorators["nested"].Decorate(nil, make(dependencies)).AsStringDefault(""))
+	require.Equal(t, float64(8), decorators["typed"].Decorate(nil, make(dependencies)).AsNumericDefault(0))
+	require.True(t, decorators["mismatch"].Decorate(nil, make(dependencies)).IsNull())
+}
+
+func TestParseDecorators_ErrorPosition(t *testing.T) {
+	declaration := easyjson.NewJSONObjectWithKeyValue("key", easyjson.NewJSON(`@function:test.echo("x`))
+
+	err := validateDeclaration(&declaration)
+	require.Error(t, err)
+	require.Equal(t, "key: syntax error at position 21: unterminated string", err.Error())
+}
+
+func TestParseDecorators_Each(t *testing.T) {
+	raw := `{
+		"name": "@property:body.name",
+		"disks": {
+			"@each": "@function:getChildrenUUIDSByLinkType(node_disk)",
+			"fields": {
+				"name": "@property:body.name",
+				"parts": {"@each": "@function:getChildrenUUIDSByLinkType(disk_part)", "fields": {"size": "@property:body.size"}}
+			}
+		}
+	}`
+
+	declaration, ok := easyjson.JSONFromString(raw)
+	require.True(t, ok)
+
+	decorators, err := parseDecorators("obj", &declaration)
+	require.NoError(t, err)
+	require.Len(t, decorators, 2)
+
+	each, ok := decorators["disks"].(*controllerEach)
+	require.True(t, ok)
+	require.Equal(t, "obj", each.id)
+	require.True(t, each.fields.PathExists("parts.@each"))
+
+	invalid := map[string]string{
+		"missing each":   `{"disks": {"fields": {}}}`,
+		"invalid each":   `{"disks": {"@each": "@function:notExists()", "fields": {}}}`,
+		"missing fields": `{"disks": {"@each": "@property:body.ids"}}`,
+		"invalid field":  `{"disks": {"@each": "@property:body.ids", "fields": {"name": "body.name"}}}`,
+		"not decorator":  `{"disks": 1}`,
+	}
+
+	for name, raw := range invalid {
+		t.Run(name, func(t *testing.T) {
+			declaration, ok := easyjson.JSONFromString(raw)
+			require.True(t, ok)
+
+			err := validateDeclaration(&declaration)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "disks: ")
+		})
+	}
+}
+
+func TestDecorate_Dependencies(t *testing.T) {
+	err := RegisterDecoratorFunction("test.depend", DecoratorFunction{
+		Result: ResultPlain,
+		Handler: func(dctx *DecoratorContext) (easyjson.JSON, error) {
+			dctx.Depend("child_1", "child_2", "")
+			return easyjson.JSONFromArray([]string{"child_1", "child_2"}), nil
+		},
+	})
+	require.NoError(t, err)
+
+	declaration := easyjson.NewJSONObject()
+	declaration.SetByPath("children", easyjson.NewJSON("@function:test.depend()"))
+
+	decorators, err := parseDecorators("obj", &declaration)
+	require.NoError(t, err)
+
+	deps := make(dependencies)
+	decorators["children"].Decorate(nil, deps)
+
+	require.Equal(t, []string{"child_1", "child_2", "obj"}, deps.list())
+}
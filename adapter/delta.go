@@ -0,0 +1,312 @@
+package adapter
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strconv"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/foliagecp/sdk/clients/go/db"
+	sfplugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/ui-app-lib/internal/common"
+	"github.com/foliagecp/ui-app-lib/internal/egress"
+	"github.com/foliagecp/ui-app-lib/internal/jsonpatch"
+	inStatefun "github.com/foliagecp/ui-app-lib/internal/statefun"
+	"github.com/foliagecp/ui-app-lib/protocol"
+)
+
+const (
+	// _CONTROLLER_OBJECT_SEQ is the sequence number of the controller object result, it grows with every change
+	_CONTROLLER_OBJECT_SEQ = "seq"
+	// _CONTROLLER_OBJECT_PATCHES keeps the last patches of the controller object result by their sequence numbers
+	_CONTROLLER_OBJECT_PATCHES = "patches"
+	// _SNAPSHOT asks UpdateController to send full results to the session
+	_SNAPSHOT = "snapshot"
+	// _SNAPSHOT_REPLY sends the snapshot as the reply to RESYNC instead of the subscription snapshot event
+	_SNAPSHOT_REPLY = "reply"
+	// _ACK asks UpdateController to remember results acknowledged by the session, _ACK_SEQ holds their sequence numbers
+	_ACK     = "ack"
+	_ACK_SEQ = "seq"
+)
+
+// PatchHistory is how many last patches of a controller object are kept to build patches from acknowledged results,
+// the whole document is sent to a session which has acknowledged an older one
+const PatchHistory = 32
+
+// objectUpdate is a change of the controller object result, sent by UpdateControllerObject to UpdateController
+type objectUpdate struct {
+	ObjectID string          `json:"object_id"`
+	Result   json.RawMessage `json:"result"`
+	BaseSeq  uint64          `json:"base_seq"`
+	Seq      uint64          `json:"seq"`
+	// Patch turns the result of BaseSeq into Result
+	Patch []protocol.PatchOperation `json:"patch"`
+	// Denied result keys, they are omitted from Result
+	Denied []string `json:"denied,omitempty"`
+}
+
+// nextUpdate builds the update from the previous result kept in the controller object body to the new one
+// and stores the new result with its sequence number, denied keys and the patch into the body
+func nextUpdate(body *easyjson.JSON, objectID string, result, denied easyjson.JSON) objectUpdate {
+	seq := uint64(body.GetByPath(_CONTROLLER_OBJECT_SEQ).AsNumericDefault(0))
+
+	update := objectUpdate{
+		ObjectID: objectID,
+		Result:   result.ToBytes(),
+		BaseSeq:  seq,
+		Seq:      seq + 1,
+	}
+
+	update.Denied, _ = denied.AsArrayString()
+
+	if seq == 0 || !body.PathExists(_CONTROLLER_RESULT) {
+		update.BaseSeq = 0
+		update.Patch = jsonpatch.Replace(update.Result)
+	} else if patch, err := jsonpatch.Diff(body.GetByPath(_CONTROLLER_RESULT).ToBytes(), update.Result); err == nil {
+		update.Patch = patch
+	} else {
+		slog.Warn("failed to diff controller result", "object_id", objectID, "err", err.Error())
+		update.Patch = jsonpatch.Replace(update.Result)
+	}
+
+	if update.BaseSeq == 0 {
+		body.RemoveByPath(_CONTROLLER_OBJECT_PATCHES)
+	}
+
+	data, _ := json.Marshal(update.Patch)
+	if patch, ok := easyjson.JSONFromBytes(data); ok {
+		body.SetByPath(patchPath(update.Seq), patch)
+	}
+
+	if update.Seq > PatchHistory {
+		body.RemoveByPath(patchPath(update.Seq - PatchHistory))
+	}
+
+	body.SetByPath(_CONTROLLER_RESULT, result)
+	body.SetByPath(_CONTROLLER_OBJECT_SEQ, easyjson.NewJSON(update.Seq))
+
+	if len(update.Denied) > 0 {
+		body.SetByPath(_CONTROLLER_DENIED, denied)
+	} else {
+		body.RemoveByPath(_CONTROLLER_DENIED)
+	}
+
+	return update
+}
+
+func patchPath(seq uint64) string {
+	return _CONTROLLER_OBJECT_PATCHES + "." + strconv.FormatUint(seq, 10)
+}
+
+// historyPatch joins the kept patches turning the result of from into the result of to, false if some of them is dropped
+func historyPatch(body *easyjson.JSON, from, to uint64) ([]protocol.PatchOperation, bool) {
+	patch := make([]protocol.PatchOperation, 0)
+
+	for seq := from + 1; seq <= to; seq++ {
+		if !body.PathExists(patchPath(seq)) {
+			return nil, false
+		}
+
+		var ops []protocol.PatchOperation
+		if err := json.Unmarshal(body.GetByPath(patchPath(seq)).ToBytes(), &ops); err != nil {
+			return nil, false
+		}
+
+		patch = append(patch, ops...)
+	}
+
+	return patch, len(patch) > 0
+}
+
+// historyReader returns the patch of the object from the result of from to the result of to, false if it can't be built
+type historyReader func(objectID string, from, to uint64) ([]protocol.PatchOperation, bool)
+
+// controllerHistory reads patches kept by the controller objects, the objects are read once on the first call
+func controllerHistory(ctx *sfplugins.StatefunContextProcessor) historyReader {
+	var bodies map[string]*easyjson.JSON
+
+	return func(objectID string, from, to uint64) ([]protocol.PatchOperation, bool) {
+		if bodies == nil {
+			bodies = make(map[string]*easyjson.JSON)
+
+			for _, controllerObjectID := range getChildrenUUIDSByLinkType(ctx, ctx.Self.ID, inStatefun.CONTROLLER_OBJECT_TYPE) {
+				if body, err := ctx.Domain.Cache().GetValueAsJSON(controllerObjectID); err == nil {
+					bodies[body.GetByPath("object_id").AsStringDefault("")] = body
+				}
+			}
+		}
+
+		body, ok := bodies[objectID]
+		if !ok {
+			return nil, false
+		}
+
+		return historyPatch(body, from, to)
+	}
+}
+
+// merge joins the update with the next one of the same object, the patch still starts from BaseSeq
+func (u objectUpdate) merge(next objectUpdate) objectUpdate {
+	merged := next
+	merged.BaseSeq = u.BaseSeq
+
+	if next.BaseSeq == u.Seq {
+		merged.Patch = append(append(make([]protocol.PatchOperation, 0, len(u.Patch)+len(next.Patch)), u.Patch...), next.Patch...)
+	} else {
+		// some update was lost on the way, the result is the only thing that is still right
+		merged.Patch = jsonpatch.Replace(next.Result)
+	}
+
+	return merged
+}
+
+func (u objectUpdate) toJSON() easyjson.JSON {
+	data, _ := json.Marshal(u)
+	payload, _ := easyjson.JSONFromBytes(data)
+	return payload
+}
+
+func parseUpdate(payload easyjson.JSON) (objectUpdate, error) {
+	var update objectUpdate
+	err := json.Unmarshal(payload.ToBytes(), &update)
+	return update, err
+}
+
+// sendUpdates sends every subscriber the message tailored for it, the skipped session gets a snapshot instead
+func sendUpdates(ctx *sfplugins.StatefunContextProcessor, plugin string, updates map[string]objectUpdate, skip string) {
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(ctx.Request)
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+
+	subscribers := getChildrenUUIDSByLinkType(ctx, ctx.Self.ID, inStatefun.SUBSCRIBER_TYPE)
+
+	slog.Info("Send update to subscribers", "subscribers", subscribers)
+
+	history := controllerHistory(ctx)
+
+	for _, subID := range subscribers {
+		if subID == skip {
+			continue
+		}
+
+		sub := readSubscriber(ctx, subID)
+
+		msg, ok := sub.message(plugin, updates, history)
+		if !ok {
+			continue
+		}
+
+		if err := egress.SendMessageToSession(ctx, subID, msg); err != nil {
+			slog.Warn(err.Error())
+			continue
+		}
+
+		if err := sub.save(cmdb, ctx.Self.ID); err != nil {
+			slog.Warn("failed to save subscriber state", "session_id", subID, "err", err.Error())
+		}
+	}
+}
+
+// ackResults remembers results acknowledged by the session, later patches sent to it start from them
+func ackResults(ctx *sfplugins.StatefunContextProcessor, sessionID string, seq easyjson.JSON) {
+	if _, err := common.OutLinkBody(ctx.Domain.Cache(), ctx.Self.ID, inStatefun.SUBSCRIBER_TYPE, sessionID); err != nil {
+		slog.Warn("results are acknowledged by not subscribed session", "id", ctx.Self.ID, "session_id", sessionID)
+		return
+	}
+
+	acked := make(map[string]uint64)
+	if err := json.Unmarshal(seq.ToBytes(), &acked); err != nil {
+		slog.Warn("invalid acknowledged results", "session_id", sessionID, "err", err.Error())
+		return
+	}
+
+	sub := readSubscriber(ctx, sessionID)
+	if !sub.ack(acked) {
+		return
+	}
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(ctx.Request)
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+
+	if err := sub.save(cmdb, ctx.Self.ID); err != nil {
+		slog.Warn("failed to save subscriber state", "session_id", sessionID, "err", err.Error())
+	}
+}
+
+// hasResults reports whether some object of the controller is already constructed
+func hasResults(ctx *sfplugins.StatefunContextProcessor) bool {
+	for _, controllerObjectID := range getChildrenUUIDSByLinkType(ctx, ctx.Self.ID, inStatefun.CONTROLLER_OBJECT_TYPE) {
+		if body, err := ctx.Domain.Cache().GetValueAsJSON(controllerObjectID); err == nil && body.PathExists(_CONTROLLER_RESULT) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+sendSnapshot sends full results of all controller objects with their sequence numbers to the session:
+as the reply to RESYNC or as the snapshot event of a new subscription.
+*/
+func sendSnapshot(ctx *sfplugins.StatefunContextProcessor, body *easyjson.JSON, sessionID string, reply bool) {
+	objects := make(map[string]protocol.ObjectSnapshot)
+
+	for _, controllerObjectID := range getChildrenUUIDSByLinkType(ctx, ctx.Self.ID, inStatefun.CONTROLLER_OBJECT_TYPE) {
+		objectBody, err := ctx.Domain.Cache().GetValueAsJSON(controllerObjectID)
+		if err != nil || !objectBody.PathExists(_CONTROLLER_RESULT) {
+			// not constructed yet, the first update replaces the whole document anyway
+			continue
+		}
+
+		objectID := objectBody.GetByPath("object_id").AsStringDefault("")
+		snapshot := protocol.ObjectSnapshot{
+			Seq:    uint64(objectBody.GetByPath(_CONTROLLER_OBJECT_SEQ).AsNumericDefault(0)),
+			Result: objectBody.GetByPath(_CONTROLLER_RESULT).ToBytes(),
+		}
+
+		snapshot.Denied, _ = objectBody.GetByPath(_CONTROLLER_DENIED).AsArrayString()
+		objects[objectID] = snapshot
+	}
+
+	plugin := body.GetByPath("plugin").AsStringDefault("")
+	name := body.GetByPath("name").AsStringDefault("")
+
+	sub := readSubscriber(ctx, sessionID)
+	objects = sub.snapshot(objects)
+
+	var msg any = protocol.ControllerSnapshot{
+		Event:   protocol.EventSnapshot,
+		Plugin:  plugin,
+		Name:    name,
+		Objects: objects,
+	}
+
+	if reply {
+		msg = protocol.ResyncReply{
+			Reply:   protocol.OK(protocol.RESYNC),
+			Plugin:  plugin,
+			Name:    name,
+			Objects: objects,
+		}
+	}
+
+	if err := egress.SendMessageToSession(ctx, sessionID, msg); err != nil {
+		slog.Warn("failed to send snapshot", "session_id", sessionID, "err", err.Error())
+		return
+	}
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(ctx.Request)
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+
+	if err := sub.save(cmdb, ctx.Self.ID); err != nil {
+		slog.Warn("failed to save subscriber state", "session_id", sessionID, "err", err.Error())
+	}
+}
@@ -0,0 +1,83 @@
+package adapter
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/foliagecp/ui-app-lib/internal/jsonpatch"
+	"github.com/foliagecp/ui-app-lib/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextUpdate(t *testing.T) {
+	body := easyjson.NewJSONObject()
+
+	first, ok := easyjson.JSONFromString(`{"name":"a","description":"long enough to make the patch shorter than the result"}`)
+	require.True(t, ok)
+
+	update := nextUpdate(&body, "uuid", first, easyjson.NewJSONNull())
+	require.Equal(t, uint64(0), update.BaseSeq)
+	require.Equal(t, uint64(1), update.Seq)
+	require.Equal(t, jsonpatch.Replace(first.ToBytes()), update.Patch)
+	require.Empty(t, update.Denied)
+
+	second, ok := easyjson.JSONFromString(`{"name":"b","description":"long enough to make the patch shorter than the result"}`)
+	require.True(t, ok)
+
+	update = nextUpdate(&body, "uuid", second, easyjson.JSONFromArray([]string{"secret"}))
+	require.Equal(t, uint64(1), update.BaseSeq)
+	require.Equal(t, uint64(2), update.Seq)
+	require.JSONEq(t, string(second.ToBytes()), string(update.Result))
+	require.Equal(t, []string{"secret"}, update.Denied)
+
+	data, err := json.Marshal(update.Patch)
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"op":"replace","path":"/name","value":"b"}]`, string(data))
+
+	require.Equal(t, float64(2), body.GetByPath(_CONTROLLER_OBJECT_SEQ).AsNumericDefault(0))
+	require.True(t, body.GetByPath(_CONTROLLER_RESULT).Equals(second))
+	require.True(t, body.PathExists(_CONTROLLER_DENIED))
+}
+
+func TestNextUpdate_History(t *testing.T) {
+	body := easyjson.NewJSONObject()
+	patches := make([][]protocol.PatchOperation, 0)
+
+	for i := 0; i < PatchHistory+2; i++ {
+		result := easyjson.NewJSONObjectWithKeyValue("n", easyjson.NewJSON(i))
+		patches = append(patches, nextUpdate(&body, "uuid", result, easyjson.NewJSONNull()).Patch)
+	}
+
+	last := uint64(len(patches))
+
+	// kept patches turn the older result into the last one
+	patch, ok := historyPatch(&body, last-2, last)
+	require.True(t, ok)
+	require.Equal(t, append(patches[last-2], patches[last-1]...), patch)
+
+	_, ok = historyPatch(&body, last-PatchHistory, last)
+	require.True(t, ok)
+
+	// the oldest patches are dropped
+	_, ok = historyPatch(&body, 1, last)
+	require.False(t, ok)
+}
+
+func TestObjectUpdate_Merge(t *testing.T) {
+	u1 := objectUpdate{ObjectID: "uuid", Result: []byte(`1`), BaseSeq: 1, Seq: 2, Patch: jsonpatch.Replace([]byte(`1`))}
+	u2 := objectUpdate{ObjectID: "uuid", Result: []byte(`2`), BaseSeq: 2, Seq: 3, Patch: jsonpatch.Replace([]byte(`2`))}
+
+	merged := u1.merge(u2)
+	require.Equal(t, uint64(1), merged.BaseSeq)
+	require.Equal(t, uint64(3), merged.Seq)
+	require.Len(t, merged.Patch, 2)
+
+	// gap between updates, the merged patch falls back to the whole result
+	u3 := objectUpdate{ObjectID: "uuid", Result: []byte(`4`), BaseSeq: 4, Seq: 5}
+
+	merged = merged.merge(u3)
+	require.Equal(t, uint64(1), merged.BaseSeq)
+	require.Equal(t, uint64(5), merged.Seq)
+	require.Equal(t, jsonpatch.Replace([]byte(`4`)), merged.Patch)
+}
@@ -0,0 +1,128 @@
+package adapter
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/foliagecp/sdk/clients/go/db"
+	sfplugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/ui-app-lib/internal/common"
+	inStatefun "github.com/foliagecp/ui-app-lib/internal/statefun"
+)
+
+const _CONTROLLER_OBJECT_DEPENDENCIES = "dependencies"
+
+// dependencies are objects read while a controller object was constructed,
+// links are tracked by their source object
+type dependencies map[string]struct{}
+
+func (d dependencies) add(ids ...string) {
+	for _, id := range ids {
+		if id != "" {
+			d[id] = struct{}{}
+		}
+	}
+}
+
+func (d dependencies) list() []string {
+	ids := make([]string, 0, len(d))
+	for id := range d {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
+
+// watchObject links controller object with the object and registers it as a watcher of the object type,
+// so the object changes are delivered to ControllerObjectTrigger. Returns the object type.
+func watchObject(ctx *sfplugins.StatefunContextProcessor, cmdb db.CMDBSyncClient, controllerObjectID, objectID string) (string, error) {
+	objectType, err := common.ObjectType(cmdb, objectID)
+	if err != nil {
+		return "", err
+	}
+
+	if err := cmdb.TypesLinkCreate(inStatefun.CONTROLLER_OBJECT_TYPE, objectType, inStatefun.CONTROLLER_SUBJECT_TYPE, []string{}); err != nil {
+		if !common.ErrorAlreadyExists(err) {
+			return "", fmt.Errorf("failed to create types link between controller object and uuid: %w", err)
+		}
+	}
+
+	if err := cmdb.ObjectsLinkCreate(controllerObjectID, objectID, objectID, []string{}); err != nil {
+		if !common.ErrorAlreadyExists(err) {
+			return "", fmt.Errorf("failed to create objects link between controller object and uuid: %w", err)
+		}
+	}
+
+	signalTypeWatch(ctx, _WATCH, objectType, controllerObjectID, objectID)
+
+	return objectType, nil
+}
+
+// unwatchAll unregisters the controller object from types of all objects it watches, used before its deletion
+func unwatchAll(ctx *sfplugins.StatefunContextProcessor, controllerObjectID string, body *easyjson.JSON) {
+	watched := body.GetByPath(_CONTROLLER_OBJECT_DEPENDENCIES)
+
+	for _, objectID := range watched.ObjectKeys() {
+		objectType := watched.GetByPath(objectID).AsStringDefault("")
+		signalTypeWatch(ctx, _UNWATCH, objectType, controllerObjectID, objectID)
+	}
+}
+
+/*
+refreshDependencies watches new dependencies of the controller object and forgets the ones which aren't read anymore,
+the root object is always watched. Watched objects are stored in the body with their types:
+
+	"dependencies": {
+		"<object id>": "<object type>"
+	}
+*/
+func refreshDependencies(ctx *sfplugins.StatefunContextProcessor, body *easyjson.JSON, rootID string, deps []string) {
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(ctx.Request)
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+
+	controllerObjectID := ctx.Self.ID
+
+	old := body.GetByPath(_CONTROLLER_OBJECT_DEPENDENCIES)
+	actual := easyjson.NewJSONObject()
+
+	for _, id := range append([]string{rootID}, deps...) {
+		if id == "" || id == controllerObjectID || actual.PathExists(id) {
+			continue
+		}
+
+		if objectType, ok := old.GetByPath(id).AsString(); ok {
+			actual.SetByPath(id, easyjson.NewJSON(objectType))
+			continue
+		}
+
+		objectType, err := watchObject(ctx, cmdb, controllerObjectID, id)
+		if err != nil {
+			// dependency could be deleted in the meantime, it'll be retried on the next update
+			slog.Warn("failed to watch dependency", "id", controllerObjectID, "dependency", id, "err", err.Error())
+			continue
+		}
+
+		actual.SetByPath(id, easyjson.NewJSON(objectType))
+	}
+
+	for _, id := range old.ObjectKeys() {
+		if actual.PathExists(id) {
+			continue
+		}
+
+		if err := cmdb.ObjectsLinkDelete(controllerObjectID, id); err != nil {
+			slog.Warn("failed to forget dependency", "id", controllerObjectID, "dependency", id, "err", err.Error())
+		}
+
+		signalTypeWatch(ctx, _UNWATCH, old.GetByPath(id).AsStringDefault(""), controllerObjectID, id)
+	}
+
+	body.SetByPath(_CONTROLLER_OBJECT_DEPENDENCIES, actual)
+}
@@ -0,0 +1,17 @@
+package adapter
+
+// ResetConfig restores the default config after tests which register functions with their own
+func ResetConfig() {
+	config = DefaultConfig()
+}
+
+// LoseDebounceTimers drops flush timers of pending updates the way a restart does
+func LoseDebounceTimers() {
+	updateDebouncer.mu.Lock()
+	defer updateDebouncer.mu.Unlock()
+
+	for controllerID, t := range updateDebouncer.timers {
+		t.Stop()
+		delete(updateDebouncer.timers, controllerID)
+	}
+}
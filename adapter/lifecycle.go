@@ -0,0 +1,200 @@
+package adapter
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/foliagecp/sdk/clients/go/db"
+	"github.com/foliagecp/sdk/statefun"
+	sfplugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/ui-app-lib/internal/common"
+	inStatefun "github.com/foliagecp/ui-app-lib/internal/statefun"
+)
+
+// defaults of Config
+const (
+	// ControllerReleaseGracePeriod is how long a controller without subscribers is kept alive
+	// before it will be deleted together with its controller objects
+	ControllerReleaseGracePeriod = 30 * time.Second
+	// ControllerSweepInterval is how often all controllers are checked for being orphaned
+	ControllerSweepInterval = 1 * time.Minute
+)
+
+const (
+	_CONTROLLER_SUBSCRIBERS = "subscribers"
+	_CONTROLLER_RELEASED_AT = "released_at"
+)
+
+// attachSubscriber links controller and session with each other and refreshes subscribers counter,
+// delivery state of the session starts from scratch
+func attachSubscriber(ctx *sfplugins.StatefunContextProcessor, cmdb db.CMDBSyncClient, body *easyjson.JSON, s subscriber) error {
+	controllerID := ctx.Self.ID
+	sessionID := s.SessionID
+
+	state := s.toJSON()
+
+	if err := cmdb.ObjectsLinkCreate(controllerID, sessionID, sessionID, []string{}, state); err != nil {
+		if !common.ErrorAlreadyExists(err) {
+			return fmt.Errorf("failed to create objects link between controller and session: %w", err)
+		}
+
+		if err := cmdb.ObjectsLinkUpdate(controllerID, sessionID, []string{}, state, true); err != nil {
+			return fmt.Errorf("failed to reset subscriber state: %w", err)
+		}
+	}
+
+	if err := cmdb.ObjectsLinkCreate(sessionID, controllerID, controllerID, []string{}); err != nil {
+		if !common.ErrorAlreadyExists(err) {
+			return fmt.Errorf("failed to create objects link between session and controller: %w", err)
+		}
+	}
+
+	refreshSubscribers(ctx, body)
+	ctx.SetObjectContext(body)
+
+	return nil
+}
+
+// detachSubscriber removes links between controller and session and refreshes subscribers counter,
+// returns true if the controller has no subscribers anymore and its grace period has been started
+func detachSubscriber(ctx *sfplugins.StatefunContextProcessor, cmdb db.CMDBSyncClient, body *easyjson.JSON, sessionID string) (bool, error) {
+	controllerID := ctx.Self.ID
+
+	if err := cmdb.ObjectsLinkDelete(controllerID, sessionID); err != nil {
+		return false, fmt.Errorf("failed to delete objects link between controller and session: %w", err)
+	}
+
+	if err := cmdb.ObjectsLinkDelete(sessionID, controllerID); err != nil {
+		return false, fmt.Errorf("failed to delete objects link between session and controller: %w", err)
+	}
+
+	released := refreshSubscribers(ctx, body) == 0
+	ctx.SetObjectContext(body)
+
+	return released, nil
+}
+
+// refreshSubscribers stores actual subscribers count into controller body.
+// When the count reaches zero the release time is remembered, otherwise it's reset.
+func refreshSubscribers(ctx *sfplugins.StatefunContextProcessor, body *easyjson.JSON) int {
+	count := len(getChildrenUUIDSByLinkType(ctx, ctx.Self.ID, inStatefun.SUBSCRIBER_TYPE))
+
+	body.SetByPath(_CONTROLLER_SUBSCRIBERS, easyjson.NewJSON(count))
+
+	if count > 0 {
+		body.RemoveByPath(_CONTROLLER_RELEASED_AT)
+	} else if !body.PathExists(_CONTROLLER_RELEASED_AT) {
+		body.SetByPath(_CONTROLLER_RELEASED_AT, easyjson.NewJSON(time.Now().Unix()))
+	}
+
+	return count
+}
+
+/*
+Signal on controller id, payload is ignored.
+
+Deletes the controller if it has no subscribers longer than Config.ReleaseGracePeriod.
+Controllers without subscribers and release time (e.g. left after crash) get their grace period started.
+Subscribers are counted under the controller lock, so a session attaching meanwhile keeps the controller alive.
+*/
+func CollectController(_ sfplugins.StatefunExecutor, ctx *sfplugins.StatefunContextProcessor) {
+	controllerID := ctx.Self.ID
+
+	if err := ctx.ObjectMutexLock(controllerID, false); err != nil {
+		slog.Warn(err.Error())
+		return
+	}
+	defer ctx.ObjectMutexUnlock(controllerID)
+
+	body := ctx.GetObjectContext()
+	if !body.IsNonEmptyObject() {
+		return
+	}
+
+	if count := refreshSubscribers(ctx, body); count > 0 {
+		ctx.SetObjectContext(body)
+		return
+	}
+
+	releasedAt := int64(body.GetByPath(_CONTROLLER_RELEASED_AT).AsNumericDefault(0))
+	if time.Since(time.Unix(releasedAt, 0)) < config.ReleaseGracePeriod {
+		ctx.SetObjectContext(body)
+		return
+	}
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(ctx.Request)
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+
+	slog.Info("Delete released controller", "id", controllerID)
+
+	if err := deleteController(ctx, cmdb, controllerID); err != nil {
+		slog.Warn("failed to delete released controller", "id", controllerID, "err", err.Error())
+	}
+}
+
+// deleteController deletes controller objects together with their links to real objects and the controller itself,
+// the controller objects stop watching types of their objects
+func deleteController(ctx *sfplugins.StatefunContextProcessor, cmdb db.CMDBSyncClient, controllerID string) error {
+	for _, controllerObjectID := range getChildrenUUIDSByLinkType(ctx, controllerID, inStatefun.CONTROLLER_OBJECT_TYPE) {
+		if body, err := ctx.Domain.Cache().GetValueAsJSON(controllerObjectID); err == nil {
+			unwatchAll(ctx, controllerObjectID, body)
+		}
+
+		if err := cmdb.ObjectDelete(controllerObjectID); err != nil {
+			return fmt.Errorf("failed to delete controller object %s: %w", controllerObjectID, err)
+		}
+	}
+
+	if err := cmdb.ObjectDelete(controllerID); err != nil {
+		return fmt.Errorf("failed to delete controller %s: %w", controllerID, err)
+	}
+
+	return nil
+}
+
+// sweepControllers periodically sends every existing controller to the collector and flushes its pending updates
+func sweepControllers(runtime *statefun.Runtime) error {
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(runtime.Request)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(config.SweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		controllers, err := controllerIDs(runtime, cmdb)
+		if err != nil {
+			slog.Warn("failed to read controller type", "err", err.Error())
+			continue
+		}
+
+		for _, controllerID := range controllers {
+			if err := runtime.Signal(sfplugins.JetstreamGlobalSignal, inStatefun.CONTROLLER_GC, controllerID, nil, nil); err != nil {
+				slog.Warn(err.Error())
+			}
+
+			// debounced updates could be left pending if their timer was lost with the previous process
+			signalFlush(runtime.Signal, controllerID)
+		}
+	}
+
+	return nil
+}
+
+// controllerIDs lists all existing controllers
+func controllerIDs(runtime *statefun.Runtime, cmdb db.CMDBSyncClient) ([]string, error) {
+	controllerType, err := cmdb.TypeRead(common.SetHubPreffix(runtime.Domain, inStatefun.CONTROLLER_TYPE))
+	if err != nil {
+		return nil, err
+	}
+
+	controllers, _ := controllerType.GetByPath("object_ids").AsArrayString()
+
+	return controllers, nil
+}
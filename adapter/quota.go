@@ -0,0 +1,103 @@
+package adapter
+
+import (
+	"github.com/foliagecp/easyjson"
+	"github.com/foliagecp/ui-app-lib/protocol"
+)
+
+const (
+	// _CONSTRUCT_CALLS_LEFT passes the rest of the decorator call budget to constructs of @each children in request options
+	_CONSTRUCT_CALLS_LEFT = "calls_left"
+	// _CONSTRUCT_CALLS is how many decorator calls the construct has made including its children
+	_CONSTRUCT_CALLS = "calls"
+	// _CONTROLLER_OBJECT_ERROR is the error of the controller object sent to subscribers instead of its result
+	_CONTROLLER_OBJECT_ERROR = "error"
+)
+
+// callBudget bounds decorator calls of one construct, @each children spend the budget of their parent
+type callBudget struct {
+	// left is the budget of the construct, negative is unlimited
+	left     int
+	used     int
+	exceeded bool
+}
+
+func newCallBudget(options *easyjson.JSON) *callBudget {
+	b := &callBudget{left: -1}
+
+	if config.MaxDecoratorCalls > 0 {
+		b.left = config.MaxDecoratorCalls
+	}
+
+	if options != nil && options.PathExists(_CONSTRUCT_CALLS_LEFT) {
+		b.left = int(options.GetByPath(_CONSTRUCT_CALLS_LEFT).AsNumericDefault(0))
+	}
+
+	return b
+}
+
+// take spends n calls, false if the budget is exceeded
+func (b *callBudget) take(n int) bool {
+	if b.exceeded {
+		return false
+	}
+
+	if b.left >= 0 && b.used+n > b.left {
+		b.exceeded = true
+		return false
+	}
+
+	b.used += n
+
+	return true
+}
+
+// spend counts calls made by a child construct
+func (b *callBudget) spend(n int) {
+	b.used += n
+}
+
+// options passes the rest of the budget to a child construct next to the parent options
+func (b *callBudget) options(parent *easyjson.JSON) *easyjson.JSON {
+	if b.left < 0 {
+		return parent
+	}
+
+	options := easyjson.NewJSONObject()
+	if parent != nil {
+		options = parent.Clone()
+	}
+
+	options.SetByPath(_CONSTRUCT_CALLS_LEFT, easyjson.NewJSON(b.left-b.used))
+
+	return &options
+}
+
+// exceededReply is the failed construct reply
+func (b *callBudget) exceededReply() easyjson.JSON {
+	result := easyjson.NewJSONObject()
+	result.SetByPath("code", easyjson.NewJSON(string(protocol.ERR_QUOTA_EXCEEDED)))
+	result.SetByPath("message", easyjson.NewJSON("too many decorator calls in one construct"))
+	return result
+}
+
+// decoratorCalls is how many calls the decorator makes itself, @each children are counted by their constructs
+func decoratorCalls(d controllerDecorator) int {
+	switch d := d.(type) {
+	case *controllerFunction:
+		return len(d.call.names())
+	case *controllerEach:
+		return decoratorCalls(d.each)
+	}
+
+	return 1
+}
+
+// objectError is the payload of CONTROLLER_UPDATE telling subscribers the object result can't be built
+func objectError(objectID string, code protocol.ErrorCode, message string) easyjson.JSON {
+	payload := easyjson.NewJSONObject()
+	payload.SetByPath("object_id", easyjson.NewJSON(objectID))
+	payload.SetByPath(_CONTROLLER_OBJECT_ERROR+".code", easyjson.NewJSON(string(code)))
+	payload.SetByPath(_CONTROLLER_OBJECT_ERROR+".message", easyjson.NewJSON(message))
+	return payload
+}
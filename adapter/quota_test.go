@@ -0,0 +1,22 @@
+package adapter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallBudget(t *testing.T) {
+	b := &callBudget{left: 5}
+
+	require.True(t, b.take(3))
+	require.Equal(t, float64(2), b.options(nil).GetByPath(_CONSTRUCT_CALLS_LEFT).AsNumericDefault(0))
+
+	b.spend(2)
+	require.False(t, b.take(1))
+	require.True(t, b.exceeded)
+
+	unlimited := &callBudget{left: -1}
+	require.True(t, unlimited.take(1000))
+	require.Nil(t, unlimited.options(nil))
+}
@@ -0,0 +1,300 @@
+package adapter
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/foliagecp/easyjson"
+	sfplugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/ui-app-lib/internal/common"
+)
+
+// RedactMode is what happens to a redacted value
+type RedactMode string
+
+const (
+	// RedactStrip removes the value from the result
+	RedactStrip RedactMode = "strip"
+	// RedactMask replaces the value with Config.RedactMask
+	RedactMask RedactMode = "mask"
+)
+
+// type body annotations, lists of redacted paths
+const (
+	_TYPE_HIDDEN = "ui_hidden"
+	_TYPE_MASKED = "ui_masked"
+)
+
+// any key or array element
+const _REDACT_WILDCARD = "*"
+
+/*
+RedactRule hides values of objects at Path whatever the declaration asks for. Paths address the object as CMDB
+reads it, so body fields start with "body", "*" matches any key or array element:
+
+	{Path: "body.credentials", Mode: RedactStrip}
+	{Path: "body.accounts.*.token", Mode: RedactMask}
+
+Rules may be set by Config.Redaction or annotated on the type body:
+
+	{
+		"ui_hidden": ["body.credentials"], // stripped
+		"ui_masked": ["body.serial_key"]
+	}
+*/
+type RedactRule struct {
+	Path string
+	Mode RedactMode
+}
+
+type redactRule struct {
+	segments []string
+	mode     RedactMode
+}
+
+// redaction is the set of rules of one object type
+type redaction struct {
+	rules []redactRule
+	mask  string
+}
+
+func newRedaction(rules []RedactRule, mask string) redaction {
+	r := redaction{mask: mask}
+
+	for _, rule := range rules {
+		if rule.Path == "" {
+			continue
+		}
+
+		r.rules = append(r.rules, redactRule{segments: strings.Split(rule.Path, "."), mode: rule.Mode})
+	}
+
+	return r
+}
+
+// redactionOf joins rules of the configuration and annotations of the type body.
+// Types are compared domain qualified, so configured types may be set with or without the domain.
+func redactionOf(ctx *sfplugins.StatefunContextProcessor, objectType string) redaction {
+	rules := make([]RedactRule, 0)
+
+	if objectType != "" {
+		objectType = ctx.Domain.CreateObjectIDWithThisDomain(objectType, false)
+
+		for configured, typeRules := range config.Redaction {
+			if ctx.Domain.CreateObjectIDWithThisDomain(configured, false) == objectType {
+				rules = append(rules, typeRules...)
+			}
+		}
+
+		if typeBody, err := ctx.Domain.Cache().GetValueAsJSON(objectType); err == nil {
+			hidden, _ := typeBody.GetByPath(_TYPE_HIDDEN).AsArrayString()
+			for _, path := range hidden {
+				rules = append(rules, RedactRule{Path: path, Mode: RedactStrip})
+			}
+
+			masked, _ := typeBody.GetByPath(_TYPE_MASKED).AsArrayString()
+			for _, path := range masked {
+				rules = append(rules, RedactRule{Path: path, Mode: RedactMask})
+			}
+		}
+	}
+
+	return newRedaction(rules, config.RedactMask)
+}
+
+// propertySegments turns the @property path into the object path
+func propertySegments(path string) []string {
+	if path == "" {
+		return []string{"body"}
+	}
+
+	return append([]string{"body"}, strings.Split(path, ".")...)
+}
+
+// hides reports whether the whole @property result is stripped
+func (r redaction) hides(path string) bool {
+	segments := propertySegments(path)
+
+	for _, rule := range r.rules {
+		if rule.mode == RedactStrip && len(rule.segments) <= len(segments) && matchSegments(rule.segments, segments) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// apply redacts the decorator result read at the @property path, rules deeper than the path are applied inside the value
+func (r redaction) apply(path string, value easyjson.JSON) easyjson.JSON {
+	if len(r.rules) == 0 {
+		return value
+	}
+
+	segments := propertySegments(path)
+
+	var (
+		decoded any
+		changed bool
+	)
+
+	for _, rule := range r.rules {
+		if len(rule.segments) <= len(segments) {
+			if rule.mode == RedactMask && matchSegments(rule.segments, segments) {
+				return easyjson.NewJSON(r.mask)
+			}
+
+			continue
+		}
+
+		if !matchSegments(rule.segments[:len(segments)], segments) {
+			continue
+		}
+
+		if decoded == nil {
+			d := json.NewDecoder(bytes.NewReader(value.ToBytes()))
+			d.UseNumber()
+
+			if err := d.Decode(&decoded); err != nil {
+				return value
+			}
+		}
+
+		decoded = r.redact(decoded, rule.segments[len(segments):], rule.mode)
+		changed = true
+	}
+
+	if !changed {
+		return value
+	}
+
+	data, err := json.Marshal(decoded)
+	if err != nil {
+		return value
+	}
+
+	redacted, ok := easyjson.JSONFromBytes(data)
+	if !ok {
+		return value
+	}
+
+	return redacted
+}
+
+// redact applies the rest of the rule path to the decoded value
+func (r redaction) redact(value any, segments []string, mode RedactMode) any {
+	head, last := segments[0], len(segments) == 1
+
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			if head != _REDACT_WILDCARD && head != key {
+				continue
+			}
+
+			switch {
+			case !last:
+				v[key] = r.redact(child, segments[1:], mode)
+			case mode == RedactMask:
+				v[key] = r.mask
+			default:
+				delete(v, key)
+			}
+		}
+	case []any:
+		if head != _REDACT_WILDCARD {
+			return v
+		}
+
+		out := make([]any, 0, len(v))
+
+		for _, child := range v {
+			switch {
+			case !last:
+				out = append(out, r.redact(child, segments[1:], mode))
+			case mode == RedactMask:
+				out = append(out, r.mask)
+			}
+		}
+
+		return out
+	}
+
+	return value
+}
+
+func matchSegments(pattern, segments []string) bool {
+	for i, p := range pattern {
+		if p != _REDACT_WILDCARD && p != segments[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// bind gives the redaction to decorators, false if the whole decorator result is stripped.
+// Results of @function are redacted by their kind, see redactResult.
+func (r redaction) bind(d controllerDecorator) bool {
+	switch d := d.(type) {
+	case *controllerProperty:
+		d.redaction = r
+		return !r.hides(d.path)
+	case *controllerEach:
+		return r.bind(d.each)
+	}
+
+	return true
+}
+
+func (r redaction) empty() bool {
+	return len(r.rules) == 0
+}
+
+// redactResult redacts the @function result by rules of types of objects it carries, read are objects the function depends on.
+// False means the result can't be redacted and must be withheld.
+func redactResult(ctx *sfplugins.StatefunContextProcessor, kind ResultKind, result easyjson.JSON, read dependencies) (easyjson.JSON, bool) {
+	redactions := make(map[string]redaction)
+
+	redactionOfObject := func(id string) redaction {
+		objectType, _ := common.CachedObjectType(ctx.Domain.Cache(), ctx.Domain.CreateObjectIDWithThisDomain(id, false))
+
+		r, ok := redactions[objectType]
+		if !ok {
+			r = redactionOf(ctx, objectType)
+			redactions[objectType] = r
+		}
+
+		return r
+	}
+
+	switch kind {
+	case ResultPlain:
+		return result, true
+	case ResultObjects:
+		if !result.IsObject() {
+			return easyjson.NewJSONNull(), false
+		}
+
+		redacted := easyjson.NewJSONObject()
+
+		for _, id := range result.ObjectKeys() {
+			r := redactionOfObject(id)
+			if r.hides("") {
+				continue
+			}
+
+			redacted.SetByPath(id, r.apply("", result.GetByPath(id)))
+		}
+
+		return redacted, true
+	}
+
+	for id := range read {
+		if !redactionOfObject(id).empty() {
+			return easyjson.NewJSONNull(), false
+		}
+	}
+
+	return result, true
+}
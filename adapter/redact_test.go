@@ -0,0 +1,50 @@
+package adapter
+
+import (
+	"testing"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedaction_Apply(t *testing.T) {
+	r := newRedaction([]RedactRule{
+		{Path: "body.credentials", Mode: RedactStrip},
+		{Path: "body.serial", Mode: RedactMask},
+		{Path: "body.accounts.*.token", Mode: RedactMask},
+	}, "***")
+
+	body, ok := easyjson.JSONFromString(`{
+		"name": "device",
+		"credentials": {"login": "admin", "password": "secret"},
+		"serial": "XXXX-1234",
+		"accounts": [{"user": "a", "token": "t1"}, {"user": "b", "token": "t2"}]
+	}`)
+	require.True(t, ok)
+
+	redacted := r.apply("", body)
+	require.JSONEq(t, `{
+		"name": "device",
+		"serial": "***",
+		"accounts": [{"user": "a", "token": "***"}, {"user": "b", "token": "***"}]
+	}`, redacted.ToString())
+
+	require.True(t, r.hides("credentials"))
+	require.True(t, r.hides("credentials.password"))
+	require.False(t, r.hides("name"))
+	require.False(t, r.hides(""))
+
+	require.Equal(t, `"***"`, r.apply("serial", body.GetByPath("serial")).ToString())
+	require.Equal(t, `"device"`, r.apply("name", body.GetByPath("name")).ToString())
+}
+
+func TestRedaction_Wildcard(t *testing.T) {
+	r := newRedaction([]RedactRule{{Path: "body.*.secret", Mode: RedactStrip}}, "***")
+
+	body, ok := easyjson.JSONFromString(`{"a": {"secret": 1, "x": 2}, "b": {"secret": 3}, "c": 4}`)
+	require.True(t, ok)
+
+	require.JSONEq(t, `{"a": {"x": 2}, "b": {}, "c": 4}`, r.apply("", body).ToString())
+	require.JSONEq(t, `{"x": 2}`, r.apply("a", body.GetByPath("a")).ToString())
+	require.True(t, r.hides("b.secret"))
+}
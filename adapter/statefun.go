@@ -1,40 +1,58 @@
 package adapter
 
 import (
-	"fmt"
 	"log/slog"
+	"slices"
 	"strings"
 
 	"github.com/foliagecp/easyjson"
 	"github.com/foliagecp/sdk/clients/go/db"
 	"github.com/foliagecp/sdk/statefun"
 	sfplugins "github.com/foliagecp/sdk/statefun/plugins"
-	"github.com/foliagecp/sdk/statefun/system"
 	"github.com/foliagecp/ui-app-lib/adapter/decorators"
 	"github.com/foliagecp/ui-app-lib/internal/common"
 	"github.com/foliagecp/ui-app-lib/internal/egress"
 	"github.com/foliagecp/ui-app-lib/internal/generate"
 	inStatefun "github.com/foliagecp/ui-app-lib/internal/statefun"
+	"github.com/foliagecp/ui-app-lib/protocol"
 )
 
-var checkUpdates = system.GetEnvMustProceed("UI_APP_LIB_CHECK_UPDATES", true)
-
 const (
-	_CONTROLLER_DECLARATION = "declaration"
-	_CONTROLLER_RESULT      = "result"
+	_CONTROLLER_DECLARATION    = "declaration"
+	_CONTROLLER_RESULT         = "result"
+	_CONTROLLER_OBJECT_REMOVED = "removed"
 )
 
-func RegisterFunctions(runtime *statefun.Runtime) {
-	statefun.NewFunctionType(runtime, inStatefun.CONTROLLER_START, StartController, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1))
-	statefun.NewFunctionType(runtime, inStatefun.CONTROLLER_CLEAR, ClearController, *statefun.NewFunctionTypeConfig())
-	statefun.NewFunctionType(runtime, inStatefun.CONTROLLER_OBJECT_UPDATE, UpdateControllerObject, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1))
+// RegisterFunctions registers controller functions with the config, DefaultConfig is used if it's omitted
+func RegisterFunctions(runtime *statefun.Runtime, configs ...Config) {
+	cfg := DefaultConfig()
+	if len(configs) > 0 {
+		cfg = configs[0]
+	}
+
+	cfg = cfg.validated()
+	config = cfg
+
+	fnCfg := func() *statefun.FunctionTypeConfig {
+		return statefun.NewFunctionTypeConfig().SetMaxIdHandlers(cfg.MaxIdHandlers)
+	}
+
+	statefun.NewFunctionType(runtime, inStatefun.CONTROLLER_START, StartController, *fnCfg())
+	statefun.NewFunctionType(runtime, inStatefun.CONTROLLER_CLEAR, ClearController, *fnCfg().SetAllowedRequestProviders(sfplugins.AutoRequestSelect))
+	statefun.NewFunctionType(runtime, inStatefun.CONTROLLER_OBJECT_UPDATE, UpdateControllerObject, *fnCfg())
 	statefun.NewFunctionType(runtime, inStatefun.CONTROLLER_OBJECT_TRIGGER, ControllerObjectTrigger, *statefun.NewFunctionTypeConfig())
-	statefun.NewFunctionType(runtime, inStatefun.CONTROLLER_CONSTRUCT, ControllerConstruct, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1).SetAllowedRequestProviders(sfplugins.AutoRequestSelect))
-	statefun.NewFunctionType(runtime, inStatefun.CONTROLLER_UPDATE, UpdateController, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1).SetMsgAckWaitMs(30000))
+	statefun.NewFunctionType(runtime, inStatefun.CONTROLLER_CONSTRUCT, ControllerConstruct, *fnCfg().SetAllowedRequestProviders(sfplugins.AutoRequestSelect))
+	statefun.NewFunctionType(runtime, inStatefun.CONTROLLER_UPDATE, UpdateController, *fnCfg().SetMsgAckWaitMs(int(cfg.UpdateAckWait.Milliseconds())))
+	statefun.NewFunctionType(runtime, inStatefun.CONTROLLER_GC, CollectController, *fnCfg())
+	statefun.NewFunctionType(runtime, inStatefun.CONTROLLER_TYPE_WATCH, WatchType, *fnCfg())
 
 	decorators.Register(runtime)
 
+	startDebouncer(runtime)
+
 	runtime.RegisterOnAfterStartFunction(InitSchema, false)
+	runtime.RegisterOnAfterStartFunction(restoreDebouncer, true)
+	runtime.RegisterOnAfterStartFunction(sweepControllers, true)
 }
 
 func InitSchema(runtime *statefun.Runtime) error {
@@ -92,45 +110,99 @@ func InitSchema(runtime *statefun.Runtime) error {
 		declaration:{},
 		uuids: []string,
 		name: string,
+		debounce_ms: int, // optional, overrides Config.UpdateDebounce
+		fields: []string, // optional, top level result keys sent to the subscribing session
+		principal: {...}, // optional, principal of the session, objects and decorators are authorized for it
 	}
 
 	controller_id: {
 		name: string,
 		declaration: {...},
+		principal: {...},
 	},
+
+The caller session gets the only START_CONTROLLER reply of the controller: ok or the error which stopped it.
+Sessions of protocol versions before 2 are answered ok by the session for the whole command, so they get only errors.
+The controller is locked, so it can't be collected while the session attaches to it.
 */
 func StartController(_ sfplugins.StatefunExecutor, ctx *sfplugins.StatefunContextProcessor) {
 	self := ctx.Self
 	caller := ctx.Caller
 	payload := ctx.Payload
 
+	declaration := payload.GetByPath(_CONTROLLER_DECLARATION)
+	if err := validateDeclaration(&declaration); err != nil {
+		replyStartError(ctx, caller.ID, protocol.ERR_INVALID_DECLARATION, err.Error())
+		return
+	}
+
+	if err := ctx.ObjectMutexLock(self.ID, false); err != nil {
+		slog.Warn(err.Error())
+		replyStartError(ctx, caller.ID, protocol.ERR_INTERNAL, err.Error())
+		return
+	}
+	defer ctx.ObjectMutexUnlock(self.ID)
+
 	body := ctx.GetObjectContext()
-	body.SetByPath(_CONTROLLER_DECLARATION, payload.GetByPath(_CONTROLLER_DECLARATION))
+	body.SetByPath(_CONTROLLER_DECLARATION, declaration)
 	body.SetByPath("name", payload.GetByPath("name"))
 	body.SetByPath("plugin", payload.GetByPath("plugin"))
 
-	cmdb, _ := db.NewCMDBSyncClientFromRequestFunction(ctx.Request)
+	if payload.PathExists(_CONTROLLER_DEBOUNCE) {
+		body.SetByPath(_CONTROLLER_DEBOUNCE, payload.GetByPath(_CONTROLLER_DEBOUNCE))
+	} else {
+		body.RemoveByPath(_CONTROLLER_DEBOUNCE)
+	}
 
-	if err := cmdb.ObjectCreate(self.ID, inStatefun.CONTROLLER_TYPE, *body); err != nil {
-		cmdb.ObjectUpdate(self.ID, *body, true)
+	if payload.PathExists(_CONTROLLER_PRINCIPAL) {
+		body.SetByPath(_CONTROLLER_PRINCIPAL, payload.GetByPath(_CONTROLLER_PRINCIPAL))
+	} else {
+		body.RemoveByPath(_CONTROLLER_PRINCIPAL)
+	}
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(ctx.Request)
+	if err != nil {
+		replyStartError(ctx, caller.ID, protocol.ERR_INTERNAL, err.Error())
+		return
 	}
 
-	if err := cmdb.ObjectsLinkCreate(self.ID, caller.ID, caller.ID, []string{}); err != nil {
-		if !common.ErrorAlreadyExists(err) {
-			slog.Warn("failed to create objects link between controller and session", "err", err.Error())
+	if err := cmdb.ObjectCreate(self.ID, inStatefun.CONTROLLER_TYPE, *body); err != nil {
+		if err := cmdb.ObjectUpdate(self.ID, *body, true); err != nil {
+			slog.Warn("failed to create controller", "id", self.ID, "err", err.Error())
+			replyStartError(ctx, caller.ID, protocol.ERR_CONTROLLER_START, err.Error())
 			return
 		}
 	}
 
-	if err := cmdb.ObjectsLinkCreate(caller.ID, self.ID, self.ID, []string{}); err != nil {
-		if !common.ErrorAlreadyExists(err) {
-			slog.Warn("failed to create objects link between session and controller", "err", err.Error())
-			return
+	fields, _ := payload.GetByPath("fields").AsArrayString()
+	subscriber := newSubscriber(ctx, caller.ID, fields)
+
+	if err := attachSubscriber(ctx, cmdb, body, subscriber); err != nil {
+		slog.Warn(err.Error())
+		replyStartError(ctx, caller.ID, protocol.ERR_CONTROLLER_START, err.Error())
+		return
+	}
+
+	// results of a shared controller usually don't change when one more session subscribes,
+	// so the session gets what the controller already has at once, a new controller has nothing yet
+	if hasResults(ctx) {
+		snapshot := easyjson.NewJSONObjectWithKeyValue(_SNAPSHOT, easyjson.NewJSON(caller.ID))
+		if err := ctx.Signal(sfplugins.JetstreamGlobalSignal, inStatefun.CONTROLLER_UPDATE, self.ID, &snapshot, egress.RequestOptions(ctx)); err != nil {
+			slog.Warn("failed to request controller snapshot", "id", self.ID, "err", err.Error())
 		}
 	}
 
+	failed := make([]string, 0)
+	forbidden := make([]string, 0)
+	principal := parsePrincipal(payload)
+
 	uuids, _ := payload.GetByPath("uuids").AsArrayString()
 	for _, objectUUID := range uuids {
+		if !authorizeAttach(ctx, cmdb, principal, objectUUID) {
+			forbidden = append(forbidden, objectUUID)
+			continue
+		}
+
 		controllerObjectID := generate.UUID(self.ID + objectUUID).String()
 		controllerObjectBody := easyjson.NewJSONObject()
 		controllerObjectBody.SetByPath("object_id", easyjson.NewJSON(objectUUID))
@@ -139,44 +211,67 @@ func StartController(_ sfplugins.StatefunExecutor, ctx *sfplugins.StatefunContex
 		if err := cmdb.ObjectCreate(controllerObjectID, inStatefun.CONTROLLER_OBJECT_TYPE, controllerObjectBody); err != nil {
 			if !common.ErrorAlreadyExists(err) {
 				slog.Warn("failed to create controller object", "err", err.Error())
+				failed = append(failed, objectUUID)
 				continue
 			}
 		}
 
-		objectType, err := common.ObjectType(cmdb, objectUUID)
-		if err != nil {
-			if !common.ErrorAlreadyExists(err) {
-				slog.Warn("failed to find uuid type", "err", err.Error())
-				continue
-			}
-		}
-
-		if err := cmdb.TypesLinkCreate(inStatefun.CONTROLLER_OBJECT_TYPE, objectType, inStatefun.CONTROLLER_SUBJECT_TYPE, []string{}); err != nil {
-			if !common.ErrorAlreadyExists(err) {
-				slog.Warn("failed to create types link between controller object and uuid", "err", err.Error())
-				continue
-			}
-		}
-
-		if err := cmdb.ObjectsLinkCreate(controllerObjectID, objectUUID, objectUUID, []string{}); err != nil {
-			if !common.ErrorAlreadyExists(err) {
-				slog.Warn("failed to create objects link between controller object and uuid", "err", err.Error())
-				continue
-			}
+		if _, err := watchObject(ctx, cmdb, controllerObjectID, objectUUID); err != nil {
+			slog.Warn("failed to watch object", "id", objectUUID, "err", err.Error())
+			failed = append(failed, objectUUID)
+			continue
 		}
 
 		if err := cmdb.ObjectsLinkCreate(self.ID, controllerObjectID, controllerObjectID, []string{}); err != nil {
 			if !common.ErrorAlreadyExists(err) {
 				slog.Warn("failed to create objects link between controller and controller object", "err", err.Error())
+				failed = append(failed, objectUUID)
 				continue
 			}
 		}
 
-		cmdb.TriggerObjectSet(objectType, db.UpdateTrigger, inStatefun.CONTROLLER_OBJECT_TRIGGER)
-
 		// send to update сontroller object
 		ctx.Signal(sfplugins.JetstreamGlobalSignal, inStatefun.CONTROLLER_OBJECT_UPDATE, controllerObjectID, nil, nil)
 	}
+
+	problems := make([]string, 0)
+	code := protocol.ERR_CONTROLLER_START
+
+	if len(forbidden) > 0 {
+		code = protocol.ERR_FORBIDDEN
+		problems = append(problems, "objects are forbidden: "+strings.Join(forbidden, ", "))
+	}
+
+	if len(failed) > 0 {
+		problems = append(problems, "failed to attach objects: "+strings.Join(failed, ", "))
+	}
+
+	if len(problems) > 0 {
+		replyStartError(ctx, caller.ID, code, strings.Join(problems, "; "))
+		return
+	}
+
+	if subscriber.Version >= protocol.VersionControllerReplies {
+		replyStart(ctx, caller.ID, protocol.OK(protocol.START_CONTROLLER))
+	}
+}
+
+// replyStartError tells the session client that its controller couldn't be started
+func replyStartError(ctx *sfplugins.StatefunContextProcessor, sessionID string, code protocol.ErrorCode, message string) {
+	replyStart(ctx, sessionID, protocol.Error(protocol.START_CONTROLLER, code, message))
+}
+
+// replyStart is the only reply to START_CONTROLLER of the controller, the session doesn't reply for started ones
+func replyStart(ctx *sfplugins.StatefunContextProcessor, sessionID string, r protocol.Reply) {
+	reply := protocol.ControllerReply{
+		Reply:  r,
+		Plugin: ctx.Payload.GetByPath("plugin").AsStringDefault(""),
+		Name:   ctx.Payload.GetByPath("name").AsStringDefault(""),
+	}
+
+	if err := egress.SendMessageToSession(ctx, sessionID, reply); err != nil {
+		slog.Warn("failed to send reply", "session_id", sessionID, "err", err.Error())
+	}
 }
 
 // fetch declaration from controller
@@ -203,7 +298,15 @@ func UpdateControllerObject(_ sfplugins.StatefunExecutor, ctx *sfplugins.Statefu
 	controllerDeclaration := controllerBody.GetByPath(_CONTROLLER_DECLARATION)
 	realObjectID := body.GetByPath("object_id").AsStringDefault("")
 
-	result, err := ctx.Request(sfplugins.AutoRequestSelect, inStatefun.CONTROLLER_CONSTRUCT, realObjectID, &controllerDeclaration, nil)
+	// removed dependencies are handled as any other change, the result is rebuilt without them,
+	// link triggers report domain qualified ids
+	removed := ctx.Domain.GetObjectIDWithoutDomain(ctx.Payload.GetByPath(_CONTROLLER_OBJECT_REMOVED).AsStringDefault(""))
+	if removed != "" && removed == ctx.Domain.GetObjectIDWithoutDomain(realObjectID) {
+		removeControllerObject(ctx, parentControllerID, realObjectID)
+		return
+	}
+
+	result, err := ctx.Request(sfplugins.AutoRequestSelect, inStatefun.CONTROLLER_CONSTRUCT, realObjectID, &controllerDeclaration, principalOptions(controllerBody))
 	if err != nil {
 		result = easyjson.NewJSONObject().GetPtr()
 	}
@@ -212,40 +315,66 @@ func UpdateControllerObject(_ sfplugins.StatefunExecutor, ctx *sfplugins.Statefu
 		return
 	}
 
+	if result.GetByPath("status").AsStringDefault("failed") != "ok" {
+		message := result.GetByPath("result.message").AsStringDefault("")
+		slog.Warn("failed to construct controller object", "id", controllerObjectID, "err", message)
+
+		if code := result.GetByPath("result.code").AsStringDefault(""); code == string(protocol.ERR_QUOTA_EXCEEDED) {
+			payload := objectError(realObjectID, protocol.ERR_QUOTA_EXCEEDED, message)
+			ctx.Signal(sfplugins.JetstreamGlobalSignal, inStatefun.CONTROLLER_UPDATE, parentControllerID, &payload, nil)
+		}
+
+		return
+	}
+
 	newResult := result.GetByPath("result")
+	denied := result.GetByPath(_CONTROLLER_DENIED)
+
+	deps, _ := result.GetByPath(_CONTROLLER_OBJECT_DEPENDENCIES).AsArrayString()
+	refreshDependencies(ctx, body, realObjectID, deps)
 
-	if checkUpdates {
-		oldResult := body.GetByPath("result")
+	if config.CheckUpdates {
+		oldResult := body.GetByPath(_CONTROLLER_RESULT)
 
-		if oldResult.Equals(newResult) {
+		if oldResult.Equals(newResult) && body.GetByPath(_CONTROLLER_DENIED).Equals(denied) {
+			ctx.SetObjectContext(body)
 			return
 		}
 	}
 
-	body.SetByPath("result", newResult)
+	update := nextUpdate(body, realObjectID, newResult, denied).toJSON()
 	ctx.SetObjectContext(body)
 
-	update := easyjson.NewJSONObject()
-	update.SetByPath("result", newResult)
-	update.SetByPath("object_id", easyjson.NewJSON(realObjectID))
-
 	slog.Info("Send update upstream to controller", "id", parentControllerID)
 	// send update to controller subs
 	ctx.Signal(sfplugins.JetstreamGlobalSignal, inStatefun.CONTROLLER_UPDATE, parentControllerID, &update, nil)
 }
 
+/*
+Triggered by the graph on watched objects:
+
+	trigger.object.update: the object has changed
+	trigger.link.create|update|delete: an out link of the object or its body has changed
+	trigger.link.delete of CONTROLLER_SUBJECT_TYPE type: triggered on controller object, the object is deleted or isn't watched anymore
+
+Controller objects watching the changed object are updated.
+*/
 func ControllerObjectTrigger(_ sfplugins.StatefunExecutor, ctxProcessor *sfplugins.StatefunContextProcessor) {
-	objectUUID := ctxProcessor.Self.ID
-	pattern := common.InLinkKeyPattern(objectUUID, ">")
+	if ctxProcessor.Payload.GetByPath("trigger.link.delete.type").AsStringDefault("") == inStatefun.CONTROLLER_SUBJECT_TYPE {
+		subjectUnlinked(ctxProcessor, ctxProcessor.Payload.GetByPath("trigger.link.delete.to").AsStringDefault(""))
+		return
+	}
 
-	for _, v := range ctxProcessor.Domain.Cache().GetKeysByPattern(pattern) {
-		s := strings.Split(v, ".")
-		if len(s) == 0 {
-			continue
-		}
+	objectUUID := ctxProcessor.Self.ID
 
-		controllerObjectID := s[len(s)-2]
+	// only controller objects are notified, the object is linked from its type and other objects too
+	controllerObjects := common.InLinkSources(ctxProcessor.Domain.Cache(), objectUUID, inStatefun.CONTROLLER_SUBJECT_TYPE)
+	if len(controllerObjects) == 0 {
+		checkTypeWatchers(ctxProcessor, objectUUID)
+		return
+	}
 
+	for _, controllerObjectID := range controllerObjects {
 		updatePayload := easyjson.NewJSONObject()
 		err := ctxProcessor.Signal(sfplugins.JetstreamGlobalSignal,
 			inStatefun.CONTROLLER_OBJECT_UPDATE, controllerObjectID, &updatePayload, nil)
@@ -255,54 +384,299 @@ func ControllerObjectTrigger(_ sfplugins.StatefunExecutor, ctxProcessor *sfplugi
 	}
 }
 
+// checkTypeWatchers makes the type of the object drop its triggers if nobody watches it, e.g. after crash
+func checkTypeWatchers(ctx *sfplugins.StatefunContextProcessor, objectID string) {
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(ctx.Request)
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+
+	objectType, err := common.ObjectType(cmdb, objectID)
+	if err != nil {
+		return
+	}
+
+	signalTypeWatch(ctx, _CHECK, objectType, "", "")
+}
+
+// subjectUnlinked updates the controller object if the watched object has been deleted,
+// objects which just aren't dependencies anymore are ignored
+func subjectUnlinked(ctx *sfplugins.StatefunContextProcessor, objectID string) {
+	if objectID == "" {
+		return
+	}
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(ctx.Request)
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+
+	if _, err := cmdb.ObjectRead(objectID); err == nil {
+		return
+	}
+
+	payload := easyjson.NewJSONObjectWithKeyValue(_CONTROLLER_OBJECT_REMOVED, easyjson.NewJSON(objectID))
+
+	if err := ctx.Signal(sfplugins.JetstreamGlobalSignal, inStatefun.CONTROLLER_OBJECT_UPDATE, ctx.Self.ID, &payload, nil); err != nil {
+		slog.Warn(err.Error())
+	}
+}
+
+// removeControllerObject deletes the controller object whose object has been deleted and notifies the controller subscribers
+func removeControllerObject(ctx *sfplugins.StatefunContextProcessor, controllerID, objectID string) {
+	slog.Info("Controller object is removed", "id", ctx.Self.ID, "object_id", objectID)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(ctx.Request)
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+
+	unwatchAll(ctx, ctx.Self.ID, ctx.GetObjectContext())
+
+	if err := cmdb.ObjectDelete(ctx.Self.ID); err != nil {
+		slog.Warn("failed to delete controller object", "id", ctx.Self.ID, "err", err.Error())
+	}
+
+	update := easyjson.NewJSONObject()
+	update.SetByPath("object_id", easyjson.NewJSON(objectID))
+	update.SetByPath(_CONTROLLER_OBJECT_REMOVED, easyjson.NewJSON(true))
+
+	ctx.Signal(sfplugins.JetstreamGlobalSignal, inStatefun.CONTROLLER_UPDATE, controllerID, &update, nil)
+}
+
+/*
+Payload:
+
+	{
+		"object_id": string,
+		"result": {...}, // new controller result of the object
+		"removed": bool, // true if the object has been deleted
+		"error": {"code": string, "message": string} // the result can't be built, e.g. decorator calls quota is exceeded
+	}
+
+	or {"flush": true} to send pending debounced updates
+	or {"reschedule": true} to restore the flush timer of pending updates after restart
+	or {"snapshot": "<session id>", "reply": bool} to send full results to the session
+	or {"ack": "<session id>", "seq": {"<object id>": 42}} to start patches sent to the session from the acknowledged results
+*/
 func UpdateController(_ sfplugins.StatefunExecutor, ctx *sfplugins.StatefunContextProcessor) {
-	self := ctx.Self
 	body := ctx.GetObjectContext()
 	controllerPlugin, _ := body.GetByPath("plugin").AsString()
 
 	payload := ctx.Payload
-	update := payload.GetByPath("result")
-	realObjectID, _ := payload.GetByPath("object_id").AsString()
 
-	path := fmt.Sprintf("payload.plugins.%s.%s", controllerPlugin, realObjectID)
+	if payload.GetByPath(_FLUSH).AsBoolDefault(false) {
+		flushPending(ctx, controllerPlugin, "")
+		return
+	}
+
+	if payload.GetByPath(_RESCHEDULE).AsBoolDefault(false) {
+		reschedulePending(ctx, controllerPlugin)
+		return
+	}
+
+	// pending updates are flushed to others first, so patches sent after the snapshot never start before it;
+	// the session itself doesn't need them, the snapshot already holds the latest results
+	if sessionID := payload.GetByPath(_SNAPSHOT).AsStringDefault(""); sessionID != "" {
+		flushPending(ctx, controllerPlugin, sessionID)
+		sendSnapshot(ctx, body, sessionID, payload.GetByPath(_SNAPSHOT_REPLY).AsBoolDefault(false))
+		return
+	}
+
+	if sessionID := payload.GetByPath(_ACK).AsStringDefault(""); sessionID != "" {
+		ackResults(ctx, sessionID, payload.GetByPath(_ACK_SEQ))
+		return
+	}
 
-	updateReply := easyjson.NewJSONObject()
-	updateReply.SetByPath(path, update)
+	update, err := parseUpdate(*payload)
+	if err != nil {
+		slog.Warn("invalid controller update", "id", ctx.Self.ID, "err", err.Error())
+		return
+	}
 
-	subscribers := getChildrenUUIDSByLinkType(ctx, self.ID, inStatefun.SUBSCRIBER_TYPE)
+	realObjectID := update.ObjectID
 
-	slog.Info("Send update to subscribers", "subscribers", subscribers)
+	if payload.PathExists(_CONTROLLER_OBJECT_ERROR) {
+		sendToSubscribers(ctx, protocol.ObjectError{
+			Event:       protocol.EventError,
+			Plugin:      controllerPlugin,
+			ObjectID:    realObjectID,
+			Code:        protocol.ErrorCode(payload.GetByPath(_CONTROLLER_OBJECT_ERROR + ".code").AsStringDefault("")),
+			Message:     payload.GetByPath(_CONTROLLER_OBJECT_ERROR + ".message").AsStringDefault(""),
+			Unsolicited: true,
+		})
 
-	for _, subID := range subscribers {
-		if err := egress.SendToSessionEgress(ctx, subID, &updateReply); err != nil {
-			slog.Warn(err.Error())
-		}
+		return
 	}
+
+	if payload.GetByPath(_CONTROLLER_OBJECT_REMOVED).AsBoolDefault(false) {
+		dropPending(ctx, realObjectID)
+
+		sendToSubscribers(ctx, protocol.ObjectRemoved{
+			Event:       protocol.EventRemoved,
+			Plugin:      controllerPlugin,
+			ObjectID:    realObjectID,
+			Unsolicited: true,
+		})
+
+		return
+	}
+
+	if window := updateDebounce(body); window > 0 {
+		addPending(ctx, update, window)
+		return
+	}
+
+	sendUpdates(ctx, controllerPlugin, map[string]objectUpdate{
+		realObjectID: update,
+	}, "")
 }
 
 /*
-@property:<json path>
+Request: declaration
 
-@function:<function.name.id>:[[arg1 value],[arg2 value],...[argN value]] - ideal
+	{
+		"<key>": "@property:<json path>" | "@function:<name>(<args>...)" | {"@each": "<decorator>", "fields": {...}}
+	}
+
+Request options:
 
-@function:getChildren(linkType) - now
+	{
+		"principal": {...}, // decorators are authorized for the principal if Config.Authorizer is set
+		"calls_left": int, // decorator call budget left by the parent construct of @each
+		"chain": []string // ids of constructs waiting for their @each children, they aren't constructed again
+	}
+
+Response:
+
+	{
+		"status": "ok" | "failed",
+		"result": {...},
+		"dependencies": []string, // objects read while constructing, the object itself isn't listed
+		"denied": []string, // result keys omitted because the principal can't read them or @function result can't be redacted, nested as "key.child_id.key"
+		"calls": int // decorator calls made including @each children, bounded by Config.MaxDecoratorCalls
+	}
+
+Failed response carries "code": "QUOTA_EXCEEDED" in the result if the construct makes too many decorator calls.
 */
 func ControllerConstruct(_ sfplugins.StatefunExecutor, ctx *sfplugins.StatefunContextProcessor) {
 	id := ctx.Self.ID
 	payload := ctx.Payload
 
-	decorators := parseDecorators(id, payload)
+	decorators, err := parseDecorators(id, payload)
+	if err != nil {
+		common.Reply(ctx, "failed", easyjson.NewJSONObjectWithKeyValue("message", easyjson.NewJSON(err.Error())))
+		return
+	}
 
 	construct := easyjson.NewJSONObject()
+	deps := make(dependencies)
+	denied := make([]string, 0)
+
+	objectType, _ := common.CachedObjectType(ctx.Domain.Cache(), id)
+	access := constructAccess(ctx, id, objectType)
+	redaction := redactionOf(ctx, objectType)
+	budget := newCallBudget(ctx.Options)
 
 	for key, d := range decorators {
-		result := d.Decorate(ctx)
+		if !authorizeDecorator(ctx, access, d) {
+			denied = append(denied, key)
+			continue
+		}
+
+		// redacted values never leave the server, whatever the declaration asks for
+		if !redaction.bind(d) {
+			continue
+		}
+
+		if !budget.take(decoratorCalls(d)) {
+			break
+		}
+
+		if e, ok := d.(*controllerEach); ok {
+			e.budget = budget
+		}
+
+		result := d.Decorate(ctx, deps)
+
+		// a @function result which can't be redacted is withheld and reported as denied
+		if f, ok := d.(*controllerFunction); ok && f.withheld {
+			denied = append(denied, key)
+			continue
+		}
+
 		construct.SetByPath(key, result)
+
+		if r, ok := d.(deniedReporter); ok {
+			for _, path := range r.denied() {
+				denied = append(denied, key+"."+path)
+			}
+		}
 	}
 
-	common.Reply(ctx, "ok", construct)
+	if budget.exceeded {
+		common.Reply(ctx, "failed", budget.exceededReply())
+		return
+	}
+
+	delete(deps, id)
+	slices.Sort(denied)
+
+	reply := easyjson.NewJSONObject()
+	reply.SetByPath("status", easyjson.NewJSON("ok"))
+	reply.SetByPath("result", construct)
+	reply.SetByPath(_CONTROLLER_OBJECT_DEPENDENCIES, easyjson.JSONFromArray(deps.list()))
+	reply.SetByPath(_CONTROLLER_DENIED, easyjson.JSONFromArray(denied))
+	reply.SetByPath(_CONSTRUCT_CALLS, easyjson.NewJSON(budget.used))
+	ctx.Reply.With(&reply)
 }
 
+/*
+	Request: {
+		"session_id": string
+	}
+
+	Response: {
+		"status"
+		"result": {
+			"released": bool // true if controller has no subscribers anymore and will be collected after grace period
+		}
+	}
+*/
 func ClearController(_ sfplugins.StatefunExecutor, ctx *sfplugins.StatefunContextProcessor) {
-	return
+	sessionID, ok := ctx.Payload.GetByPath("session_id").AsString()
+	if !ok {
+		common.Reply(ctx, "failed", easyjson.NewJSONObjectWithKeyValue("message", easyjson.NewJSON("missing session_id")))
+		return
+	}
+
+	if err := ctx.ObjectMutexLock(ctx.Self.ID, false); err != nil {
+		slog.Warn(err.Error())
+		common.Reply(ctx, "failed", easyjson.NewJSONObjectWithKeyValue("message", easyjson.NewJSON(err.Error())))
+		return
+	}
+	defer ctx.ObjectMutexUnlock(ctx.Self.ID)
+
+	cmdb, _ := db.NewCMDBSyncClientFromRequestFunction(ctx.Request)
+
+	// the body of a controller may be empty, so the controller is recognized by its type
+	objectType, err := common.ObjectType(cmdb, ctx.Self.ID)
+	if err != nil || ctx.Domain.GetObjectIDWithoutDomain(objectType) != inStatefun.CONTROLLER_TYPE {
+		common.Reply(ctx, "failed", easyjson.NewJSONObjectWithKeyValue("message", easyjson.NewJSON("controller not found")))
+		return
+	}
+
+	body := ctx.GetObjectContext()
+
+	released, err := detachSubscriber(ctx, cmdb, body, sessionID)
+	if err != nil {
+		slog.Warn(err.Error())
+		common.Reply(ctx, "failed", easyjson.NewJSONObjectWithKeyValue("message", easyjson.NewJSON(err.Error())))
+		return
+	}
+
+	common.Reply(ctx, "ok", easyjson.NewJSONObjectWithKeyValue("released", easyjson.NewJSON(released)))
 }
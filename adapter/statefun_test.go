@@ -13,9 +13,12 @@ import (
 	"github.com/foliagecp/sdk/statefun/test"
 	"github.com/foliagecp/ui-app-lib/adapter"
 	"github.com/foliagecp/ui-app-lib/adapter/decorators"
+	"github.com/foliagecp/ui-app-lib/auth"
 	"github.com/foliagecp/ui-app-lib/internal/generate"
 	inStatefun "github.com/foliagecp/ui-app-lib/internal/statefun"
+	"github.com/foliagecp/ui-app-lib/protocol"
 	"github.com/foliagecp/ui-app-lib/session"
+	"github.com/nats-io/nats.go"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -53,6 +56,7 @@ func (s *adapterTestSuite) Test_StartController_Correct() {
 	session.RegisterFunctions(s.Runtime())
 	s.RegisterFunction(inStatefun.CONTROLLER_UPDATE, adapter.UpdateController, *statefun.NewFunctionTypeConfig())
 	s.RegisterFunction(inStatefun.CONTROLLER_OBJECT_TRIGGER, adapter.UpdateControllerObject, *statefun.NewFunctionTypeConfig())
+	s.RegisterFunction(inStatefun.CONTROLLER_TYPE_WATCH, adapter.WatchType, *statefun.NewFunctionTypeConfig())
 	s.OnAfterStartFunction(adapter.InitSchema, true)
 	// -------------------------
 
@@ -117,6 +121,76 @@ func (s *adapterTestSuite) Test_StartController_Correct() {
 	s.Equal(wantTriggers, updateTriggers)
 }
 
+func (s *adapterTestSuite) Test_StartController_Snapshot() {
+	typename := inStatefun.CONTROLLER_START
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	session.RegisterFunctions(s.Runtime())
+	decorators.Register(s.Runtime())
+	s.RegisterFunction(inStatefun.CONTROLLER_UPDATE, adapter.UpdateController, *statefun.NewFunctionTypeConfig())
+	s.OnAfterStartFunction(adapter.InitSchema, true)
+
+	s.RegisterFunction(typename, adapter.StartController, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1))
+
+	// the controller is started by the session, the snapshot is sent to the caller
+	controllerName := "test_start_snapshot_controller"
+	controllerID := generate.UUID(controllerName).String()
+
+	s.RegisterFunction("test.session.start", func(_ sfplugins.StatefunExecutor, ctx *sfplugins.StatefunContextProcessor) {
+		ctx.Request(sfplugins.GolangLocalRequest, typename, controllerID, ctx.Payload, nil)
+	}, *statefun.NewFunctionTypeConfig())
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	clientID := "5"
+	sessionID := generate.SessionID(clientID).String()
+
+	err = cmdb.ObjectCreate(sessionID, inStatefun.SESSION_TYPE, easyjson.NewJSONObjectWithKeyValue("client_id", easyjson.NewJSON(clientID)))
+	s.Require().NoError(err)
+
+	sub, err := s.SubscribeEgress(inStatefun.EGRESS, clientID)
+	s.Require().NoError(err)
+
+	defer sub.Unsubscribe()
+
+	payload := easyjson.NewJSONObject()
+	payload.SetByPath("name", easyjson.NewJSON(controllerName))
+	payload.SetByPath("plugin", easyjson.NewJSON("viewer"))
+	payload.SetByPath("declaration", easyjson.NewJSONObjectWithKeyValue("name", easyjson.NewJSON("@property:name")))
+	payload.SetByPath("uuids", easyjson.JSONFromArray([]string{}))
+
+	// a new controller has nothing to send
+	_, err = s.Request(sfplugins.GolangLocalRequest, "test.session.start", sessionID, &payload, nil)
+	s.Require().NoError(err)
+
+	_, err = sub.NextMsg(time.Second)
+	s.Require().ErrorIs(err, nats.ErrTimeout)
+
+	controllerObjectID := generate.UUID(controllerID + "uuid_1").String()
+	controllerObjectBody, ok := easyjson.JSONFromString(`{"object_id": "uuid_1", "result": {"name": "a"}, "seq": 1}`)
+	s.Require().True(ok)
+
+	err = cmdb.ObjectCreate(controllerObjectID, inStatefun.CONTROLLER_OBJECT_TYPE, controllerObjectBody)
+	s.Require().NoError(err)
+
+	err = cmdb.ObjectsLinkCreate(controllerID, controllerObjectID, controllerObjectID, []string{})
+	s.Require().NoError(err)
+
+	// the constructed results are sent to the next session at once
+	_, err = s.Request(sfplugins.GolangLocalRequest, "test.session.start", sessionID, &payload, nil)
+	s.Require().NoError(err)
+
+	msg, err := sub.NextMsg(2 * time.Second)
+	s.Require().NoError(err)
+
+	wantPayload := fmt.Sprintf(`{"payload":{"event":"snapshot","plugin":"viewer","name":"%s","objects":{"uuid_1":{"seq":1,"result":{"name":"a"}}}}}`, controllerName)
+	s.JSONEq(wantPayload, string(msg.Data))
+}
+
 func (s *adapterTestSuite) Test_ControllerObjectTrigger_Correct() {
 	typename := inStatefun.CONTROLLER_OBJECT_TRIGGER
 
@@ -145,6 +219,282 @@ func (s *adapterTestSuite) Test_ControllerObjectTrigger_Correct() {
 	time.Sleep(1 * time.Second)
 }
 
+func (s *adapterTestSuite) Test_WatchType_Triggers() {
+	typename := inStatefun.CONTROLLER_TYPE_WATCH
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	s.OnAfterStartFunction(adapter.InitSchema, true)
+
+	s.RegisterFunction(typename, adapter.WatchType, *statefun.NewFunctionTypeConfig())
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	cmdb.TypeCreate("uuid_type")
+	cmdb.ObjectCreate("uuid_1", "uuid_type")
+	cmdb.ObjectCreate("uuid_2", "uuid_type")
+	cmdb.ObjectCreate("ctrl_object_1", inStatefun.CONTROLLER_OBJECT_TYPE)
+
+	watch := func(op, objectID string) {
+		payload := easyjson.NewJSONObject()
+		payload.SetByPath("op", easyjson.NewJSON(op))
+		payload.SetByPath("controller_object", easyjson.NewJSON("ctrl_object_1"))
+		payload.SetByPath("object", easyjson.NewJSON(objectID))
+
+		err := s.Signal(sfplugins.JetstreamGlobalSignal, typename, "uuid_type", &payload, nil)
+		s.Require().NoError(err)
+
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	updateTriggers := func() string {
+		typeBody, err := s.CacheValue("uuid_type")
+		s.Require().NoError(err)
+
+		return typeBody.GetByPath("triggers.update").ToString()
+	}
+
+	watch("watch", "uuid_1")
+	watch("watch", "uuid_2")
+	s.Equal(fmt.Sprintf(`["%s"]`, inStatefun.CONTROLLER_OBJECT_TRIGGER), updateTriggers())
+
+	// created objects have no controller objects watching them, so object create isn't triggered
+	typeBody, err := s.CacheValue("uuid_type")
+	s.Require().NoError(err)
+	s.False(typeBody.PathExists("triggers.create"))
+
+	watch("unwatch", "uuid_1")
+	s.Equal(fmt.Sprintf(`["%s"]`, inStatefun.CONTROLLER_OBJECT_TRIGGER), updateTriggers())
+
+	watch("unwatch", "uuid_2")
+	s.Equal(`[]`, updateTriggers())
+}
+
+func (s *adapterTestSuite) Test_WatchType_LinkedLater() {
+	typename := inStatefun.CONTROLLER_TYPE_WATCH
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	s.OnAfterStartFunction(adapter.InitSchema, true)
+
+	s.RegisterFunction(typename, adapter.WatchType, *statefun.NewFunctionTypeConfig())
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	cmdb.TypeCreate("node")
+	cmdb.TypeCreate("disk")
+	cmdb.ObjectCreate("node_1", "node")
+	cmdb.ObjectCreate("node_2", "node")
+	cmdb.ObjectCreate("ctrl_object_1", inStatefun.CONTROLLER_OBJECT_TYPE)
+
+	watch := func(objectID string) {
+		payload := easyjson.NewJSONObject()
+		payload.SetByPath("op", easyjson.NewJSON("watch"))
+		payload.SetByPath("controller_object", easyjson.NewJSON("ctrl_object_1"))
+		payload.SetByPath("object", easyjson.NewJSON(objectID))
+
+		err := s.Signal(sfplugins.JetstreamGlobalSignal, typename, "node", &payload, nil)
+		s.Require().NoError(err)
+
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	linkCreateTriggers := func() string {
+		linkData, err := cmdb.TypesLinkRead("node", "disk")
+		s.Require().NoError(err)
+
+		return linkData.GetByPath("body.triggers.create").ToString()
+	}
+
+	watch("node_1")
+
+	// the type is linked after its triggers are set, creation of types links isn't triggered
+	s.Require().NoError(cmdb.TypesLinkCreate("node", "disk", "disk", []string{}))
+	s.Equal(`null`, linkCreateTriggers())
+
+	// the link gets the triggers when one more object of the type is watched
+	watch("node_2")
+	s.Equal(fmt.Sprintf(`["%s"]`, inStatefun.CONTROLLER_OBJECT_TRIGGER), linkCreateTriggers())
+}
+
+func (s *adapterTestSuite) Test_ControllerObjectTrigger_Unwatched() {
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	s.OnAfterStartFunction(adapter.InitSchema, true)
+
+	s.RegisterFunction(inStatefun.CONTROLLER_OBJECT_TRIGGER, adapter.ControllerObjectTrigger, *statefun.NewFunctionTypeConfig())
+	s.RegisterFunction(inStatefun.CONTROLLER_TYPE_WATCH, adapter.WatchType, *statefun.NewFunctionTypeConfig())
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	// triggers are left after crash, no controller object watches the object
+	cmdb.TypeCreate("uuid_type")
+	cmdb.ObjectCreate("uuid_1", "uuid_type")
+	cmdb.TriggerObjectSet("uuid_type", db.UpdateTrigger, inStatefun.CONTROLLER_OBJECT_TRIGGER)
+
+	cmdb.ObjectUpdate("uuid_1", easyjson.NewJSONObjectWithKeyValue("key", easyjson.NewJSON("value")), true)
+
+	time.Sleep(1 * time.Second)
+
+	typeBody, err := s.CacheValue("uuid_type")
+	s.Require().NoError(err)
+
+	s.Equal(`[]`, typeBody.GetByPath("triggers.update").ToString())
+}
+
+func (s *adapterTestSuite) Test_Dependency_Update() {
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	session.RegisterFunctions(s.Runtime())
+	adapter.RegisterFunctions(s.Runtime())
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	clientID := "dependency"
+	sessionID := generate.SessionID(clientID).String()
+
+	err = cmdb.ObjectCreate(sessionID, inStatefun.SESSION_TYPE, easyjson.NewJSONObjectWithKeyValue("client_id", easyjson.NewJSON(clientID)))
+	s.Require().NoError(err)
+
+	// the disk links to the node, so the link is read by the node controller but changed on the disk
+	s.Require().NoError(cmdb.TypeCreate("node"))
+	s.Require().NoError(cmdb.TypeCreate("disk"))
+	s.Require().NoError(cmdb.TypesLinkCreate("disk", "node", "owner", []string{}))
+	s.Require().NoError(cmdb.ObjectCreate("node_1", "node"))
+	s.Require().NoError(cmdb.ObjectCreate("disk_1", "disk"))
+	s.Require().NoError(cmdb.ObjectsLinkCreate("disk_1", "node_1", "node_1", []string{}))
+
+	sub, err := s.SubscribeEgress(inStatefun.EGRESS, clientID)
+	s.Require().NoError(err)
+
+	plugin := map[string]map[string]session.Controller{
+		"viewer": {
+			"owners": {
+				Body:  protocol.Declaration{"owners": "@function:getLinksByType(owner)"},
+				UUIDs: []string{"node_1"},
+			},
+		},
+	}
+
+	payload := easyjson.NewJSON(plugin)
+	err = s.Signal(sfplugins.JetstreamGlobalSignal, inStatefun.SESSION_START_CONTROLLER, sessionID, &payload, nil)
+	s.Require().NoError(err)
+
+	owners := "payload.plugins.viewer.node_1.owners"
+	s.Equal(1, s.nextEgress(sub, owners).ArraySize())
+
+	err = cmdb.ObjectsLinkDelete("disk_1", "node_1")
+	s.Require().NoError(err)
+
+	s.Equal(0, s.nextEgress(sub, owners).ArraySize())
+}
+
+func (s *adapterTestSuite) Test_LinkCreate_Update() {
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	session.RegisterFunctions(s.Runtime())
+	adapter.RegisterFunctions(s.Runtime())
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	clientID := "link_create"
+	sessionID := generate.SessionID(clientID).String()
+
+	err = cmdb.ObjectCreate(sessionID, inStatefun.SESSION_TYPE, easyjson.NewJSONObjectWithKeyValue("client_id", easyjson.NewJSON(clientID)))
+	s.Require().NoError(err)
+
+	s.Require().NoError(cmdb.TypeCreate("node"))
+	s.Require().NoError(cmdb.TypeCreate("disk"))
+	s.Require().NoError(cmdb.TypesLinkCreate("node", "disk", "disk", []string{}))
+	s.Require().NoError(cmdb.ObjectCreate("node_1", "node"))
+	s.Require().NoError(cmdb.ObjectCreate("disk_1", "disk"))
+
+	sub, err := s.SubscribeEgress(inStatefun.EGRESS, clientID)
+	s.Require().NoError(err)
+
+	plugin := map[string]map[string]session.Controller{
+		"viewer": {
+			"disks": {
+				Body:  protocol.Declaration{"disks": "@function:getChildrenUUIDSByLinkType(disk)"},
+				UUIDs: []string{"node_1"},
+			},
+		},
+	}
+
+	payload := easyjson.NewJSON(plugin)
+	err = s.Signal(sfplugins.JetstreamGlobalSignal, inStatefun.SESSION_START_CONTROLLER, sessionID, &payload, nil)
+	s.Require().NoError(err)
+
+	disks := "payload.plugins.viewer.node_1.disks"
+	s.Equal(0, s.nextEgress(sub, disks).ArraySize())
+
+	// a disk is added to the node
+	err = cmdb.ObjectsLinkCreate("node_1", "disk_1", "disk_1", []string{})
+	s.Require().NoError(err)
+
+	s.Equal(1, s.nextEgress(sub, disks).ArraySize())
+}
+
+func (s *adapterTestSuite) Test_ObjectDelete_Removed() {
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	session.RegisterFunctions(s.Runtime())
+	adapter.RegisterFunctions(s.Runtime())
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	clientID := "object_delete"
+	sessionID := generate.SessionID(clientID).String()
+
+	err = cmdb.ObjectCreate(sessionID, inStatefun.SESSION_TYPE, easyjson.NewJSONObjectWithKeyValue("client_id", easyjson.NewJSON(clientID)))
+	s.Require().NoError(err)
+
+	s.Require().NoError(cmdb.TypeCreate("node"))
+	s.Require().NoError(cmdb.ObjectCreate("node_1", "node", easyjson.NewJSONObjectWithKeyValue("name", easyjson.NewJSON("a"))))
+
+	sub, err := s.SubscribeEgress(inStatefun.EGRESS, clientID)
+	s.Require().NoError(err)
+
+	plugin := map[string]map[string]session.Controller{
+		"viewer": {
+			"nodes": {
+				Body:  protocol.Declaration{"name": "@property:name"},
+				UUIDs: []string{"node_1"},
+			},
+		},
+	}
+
+	payload := easyjson.NewJSON(plugin)
+	err = s.Signal(sfplugins.JetstreamGlobalSignal, inStatefun.SESSION_START_CONTROLLER, sessionID, &payload, nil)
+	s.Require().NoError(err)
+
+	s.Equal("a", s.nextEgress(sub, "payload.plugins.viewer.node_1.name").AsStringDefault(""))
+
+	err = cmdb.ObjectDelete("node_1")
+	s.Require().NoError(err)
+
+	removed := s.nextEgress(sub, "payload.event")
+	s.Equal(protocol.EventRemoved, removed.AsStringDefault(""))
+}
+
 func (s *adapterTestSuite) Test_UpdateController_Correct() {
 	typename := inStatefun.CONTROLLER_UPDATE
 
@@ -195,15 +545,20 @@ func (s *adapterTestSuite) Test_UpdateController_Correct() {
 	msg, err := sub.NextMsg(2 * time.Second)
 	s.Require().NoError(err)
 
-	wantPayload := `{"payload":{"plugins":{"viewer":{"uuid_1":"some_result"}}}}`
+	wantPayload := `{"payload":{"plugins":{"viewer":{"uuid_1":"some_result"}},"unsolicited":true}}`
 	s.JSONEq(wantPayload, string(msg.Data))
 }
 
-func (s *adapterTestSuite) Test_ConstructController_Correct() {
-	typename := inStatefun.CONTROLLER_CONSTRUCT
+func (s *adapterTestSuite) Test_UpdateController_Reschedule() {
+	typename := inStatefun.CONTROLLER_UPDATE
 
 	crud.RegisterAllFunctionTypes(s.Runtime())
-	s.RegisterFunction(typename, adapter.ControllerConstruct, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1).SetAllowedRequestProviders(sfplugins.AutoRequestSelect))
+	session.RegisterFunctions(s.Runtime())
+	decorators.Register(s.Runtime())
+
+	s.OnAfterStartFunction(adapter.InitSchema, true)
+
+	s.RegisterFunction(typename, adapter.UpdateController, *statefun.NewFunctionTypeConfig())
 
 	err := s.StartRuntime()
 	s.Require().NoError(err)
@@ -211,19 +566,672 @@ func (s *adapterTestSuite) Test_ConstructController_Correct() {
 	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
 	s.Require().NoError(err)
 
-	cmdb.TypeCreate("test_uuid")
+	controllerName := "test_reschedule_controller"
+	controllerID := generate.UUID(controllerName).String()
 
-	objectBody := easyjson.NewJSONObject()
-	objectBody.SetByPath("key", easyjson.NewJSON("value"))
+	controllerBody := easyjson.NewJSONObject()
+	controllerBody.SetByPath("name", easyjson.NewJSON(controllerName))
+	controllerBody.SetByPath("plugin", easyjson.NewJSON("viewer"))
+	controllerBody.SetByPath("debounce_ms", easyjson.NewJSON(1000))
 
-	err = cmdb.ObjectCreate("uuid_1", "test_uuid", objectBody)
+	err = cmdb.ObjectCreate(controllerID, inStatefun.CONTROLLER_TYPE, controllerBody)
+	s.Require().NoError(err)
+
+	clientID := "reschedule"
+	sessionID := generate.SessionID(clientID).String()
+
+	err = cmdb.ObjectCreate(sessionID, inStatefun.SESSION_TYPE, easyjson.NewJSONObjectWithKeyValue("client_id", easyjson.NewJSON(clientID)))
+	s.Require().NoError(err)
+
+	err = cmdb.ObjectsLinkCreate(controllerID, sessionID, "sub", []string{})
+	s.Require().NoError(err)
+
+	sub, err := s.SubscribeEgress(inStatefun.EGRESS, clientID)
 	s.Require().NoError(err)
 
 	payload := easyjson.NewJSONObject()
-	payload.SetByPath("props", easyjson.NewJSON("@property:key"))
+	payload.SetByPath("object_id", easyjson.NewJSON("uuid_1"))
+	payload.SetByPath("result", easyjson.NewJSON("some_result"))
 
-	result, err := s.Request(sfplugins.GolangLocalRequest, typename, "uuid_1", &payload, nil)
+	err = s.Signal(sfplugins.JetstreamGlobalSignal, typename, controllerID, &payload, nil)
 	s.Require().NoError(err)
 
-	s.JSONEq(objectBody.GetByPath("key").ToString(), result.GetByPath("result.props").ToString())
+	// the process restarts while the update is pending, it's never sent without its timer
+	time.Sleep(300 * time.Millisecond)
+	adapter.LoseDebounceTimers()
+
+	_, err = sub.NextMsg(1500 * time.Millisecond)
+	s.Require().ErrorIs(err, nats.ErrTimeout)
+
+	// the deadline has passed, so the update is flushed at once
+	reschedule := easyjson.NewJSONObjectWithKeyValue("reschedule", easyjson.NewJSON(true))
+	err = s.Signal(sfplugins.JetstreamGlobalSignal, typename, controllerID, &reschedule, nil)
+	s.Require().NoError(err)
+
+	msg, err := sub.NextMsg(time.Second)
+	s.Require().NoError(err)
+
+	wantPayload := `{"payload":{"plugins":{"viewer":{"uuid_1":"some_result"}},"unsolicited":true}}`
+	s.JSONEq(wantPayload, string(msg.Data))
+}
+
+func (s *adapterTestSuite) Test_UpdateController_Patch() {
+	typename := inStatefun.CONTROLLER_UPDATE
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	session.RegisterFunctions(s.Runtime())
+	decorators.Register(s.Runtime())
+
+	s.OnAfterStartFunction(adapter.InitSchema, true)
+
+	s.RegisterFunction(typename, adapter.UpdateController, *statefun.NewFunctionTypeConfig())
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	controllerName := "test_patch_controller"
+	controllerID := generate.UUID(controllerName).String()
+
+	controllerBody := easyjson.NewJSONObject()
+	controllerBody.SetByPath("name", easyjson.NewJSON(controllerName))
+	controllerBody.SetByPath("plugin", easyjson.NewJSON("viewer"))
+
+	err = cmdb.ObjectCreate(controllerID, inStatefun.CONTROLLER_TYPE, controllerBody)
+	s.Require().NoError(err)
+
+	clientID := "2"
+	sessionID := generate.SessionID(clientID).String()
+	sessionBody := easyjson.NewJSONObjectWithKeyValue("client_id", easyjson.NewJSON(clientID))
+	sessionBody.SetByPath("encoding", easyjson.NewJSON(string(protocol.EncodingPatch)))
+
+	err = cmdb.ObjectCreate(sessionID, inStatefun.SESSION_TYPE, sessionBody)
+	s.Require().NoError(err)
+
+	// the session has already got the result with seq 1
+	subscriberState, ok := easyjson.JSONFromString(`{"encoding": "patch", "version": 1, "seq": {"uuid_1": 1}}`)
+	s.Require().True(ok)
+
+	err = cmdb.ObjectsLinkCreate(controllerID, sessionID, "sub", []string{}, subscriberState)
+	s.Require().NoError(err)
+
+	payload, ok := easyjson.JSONFromString(`{
+		"object_id": "uuid_1",
+		"result": {"name": "b"},
+		"base_seq": 1,
+		"seq": 2,
+		"patch": [{"op": "replace", "path": "/name", "value": "b"}]
+	}`)
+	s.Require().True(ok)
+
+	sub, err := s.SubscribeEgress(inStatefun.EGRESS, clientID)
+	s.Require().NoError(err)
+
+	err = s.Signal(sfplugins.JetstreamGlobalSignal, typename, controllerID, &payload, nil)
+	s.Require().NoError(err)
+
+	msg, err := sub.NextMsg(2 * time.Second)
+	s.Require().NoError(err)
+
+	wantPayload := `{"payload":{"plugins":{"viewer":{"uuid_1":{"base_seq":1,"seq":2,"patch":[{"op":"replace","path":"/name","value":"b"}]}}},"encoding":"patch","unsolicited":true}}`
+	s.JSONEq(wantPayload, string(msg.Data))
+}
+
+func (s *adapterTestSuite) Test_UpdateController_Ack() {
+	typename := inStatefun.CONTROLLER_UPDATE
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	session.RegisterFunctions(s.Runtime())
+	decorators.Register(s.Runtime())
+
+	s.OnAfterStartFunction(adapter.InitSchema, true)
+
+	s.RegisterFunction(typename, adapter.UpdateController, *statefun.NewFunctionTypeConfig())
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	controllerName := "test_ack_controller"
+	controllerID := generate.UUID(controllerName).String()
+
+	controllerBody := easyjson.NewJSONObject()
+	controllerBody.SetByPath("name", easyjson.NewJSON(controllerName))
+	controllerBody.SetByPath("plugin", easyjson.NewJSON("viewer"))
+
+	err = cmdb.ObjectCreate(controllerID, inStatefun.CONTROLLER_TYPE, controllerBody)
+	s.Require().NoError(err)
+
+	// patches kept by the controller object
+	controllerObjectID := generate.UUID(controllerID + "uuid_1").String()
+	controllerObjectBody, ok := easyjson.JSONFromString(`{
+		"object_id": "uuid_1",
+		"result": {"name": "d"},
+		"seq": 4,
+		"patches": {
+			"2": [{"op": "replace", "path": "/name", "value": "b"}],
+			"3": [{"op": "replace", "path": "/name", "value": "c"}],
+			"4": [{"op": "replace", "path": "/name", "value": "d"}]
+		}
+	}`)
+	s.Require().True(ok)
+
+	err = cmdb.ObjectCreate(controllerObjectID, inStatefun.CONTROLLER_OBJECT_TYPE, controllerObjectBody)
+	s.Require().NoError(err)
+
+	err = cmdb.ObjectsLinkCreate(controllerID, controllerObjectID, controllerObjectID, []string{})
+	s.Require().NoError(err)
+
+	clientID := "4"
+	sessionID := generate.SessionID(clientID).String()
+	sessionBody := easyjson.NewJSONObjectWithKeyValue("client_id", easyjson.NewJSON(clientID))
+	sessionBody.SetByPath("encoding", easyjson.NewJSON(string(protocol.EncodingPatch)))
+
+	err = cmdb.ObjectCreate(sessionID, inStatefun.SESSION_TYPE, sessionBody)
+	s.Require().NoError(err)
+
+	// results 2 and 3 are sent, but the session has applied only the first one
+	subscriberState, ok := easyjson.JSONFromString(`{"encoding": "patch", "version": 1, "seq": {"uuid_1": 3}}`)
+	s.Require().True(ok)
+
+	err = cmdb.ObjectsLinkCreate(controllerID, sessionID, "sub", []string{}, subscriberState)
+	s.Require().NoError(err)
+
+	// the session acknowledges with its own domain qualified id
+	ack, ok := easyjson.JSONFromString(fmt.Sprintf(`{"ack": "%s", "seq": {"uuid_1": 1}}`, s.Runtime().Domain.CreateObjectIDWithHubDomain(sessionID, false)))
+	s.Require().True(ok)
+
+	err = s.Signal(sfplugins.JetstreamGlobalSignal, typename, controllerID, &ack, nil)
+	s.Require().NoError(err)
+
+	s.Eventually(func() bool {
+		link, err := cmdb.ObjectsLinkRead(controllerID, sessionID)
+		return err == nil && link.GetByPath("body.acked.uuid_1").AsNumericDefault(0) == 1
+	}, 5*time.Second, 100*time.Millisecond)
+
+	payload, ok := easyjson.JSONFromString(`{
+		"object_id": "uuid_1",
+		"result": {"name": "d"},
+		"base_seq": 3,
+		"seq": 4,
+		"patch": [{"op": "replace", "path": "/name", "value": "d"}]
+	}`)
+	s.Require().True(ok)
+
+	sub, err := s.SubscribeEgress(inStatefun.EGRESS, clientID)
+	s.Require().NoError(err)
+
+	err = s.Signal(sfplugins.JetstreamGlobalSignal, typename, controllerID, &payload, nil)
+	s.Require().NoError(err)
+
+	msg, err := sub.NextMsg(2 * time.Second)
+	s.Require().NoError(err)
+
+	// the patch starts from the acknowledged result
+	wantPayload := `{"payload":{"plugins":{"viewer":{"uuid_1":{"base_seq":1,"seq":4,"patch":[
+		{"op":"replace","path":"/name","value":"b"},
+		{"op":"replace","path":"/name","value":"c"},
+		{"op":"replace","path":"/name","value":"d"}
+	]}}},"encoding":"patch","unsolicited":true}}`
+	s.JSONEq(wantPayload, string(msg.Data))
+}
+
+func (s *adapterTestSuite) Test_UpdateController_Snapshot() {
+	typename := inStatefun.CONTROLLER_UPDATE
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	session.RegisterFunctions(s.Runtime())
+	decorators.Register(s.Runtime())
+
+	s.OnAfterStartFunction(adapter.InitSchema, true)
+
+	s.RegisterFunction(typename, adapter.UpdateController, *statefun.NewFunctionTypeConfig())
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	controllerName := "test_snapshot_controller"
+	controllerID := generate.UUID(controllerName).String()
+
+	controllerBody := easyjson.NewJSONObject()
+	controllerBody.SetByPath("name", easyjson.NewJSON(controllerName))
+	controllerBody.SetByPath("plugin", easyjson.NewJSON("viewer"))
+
+	err = cmdb.ObjectCreate(controllerID, inStatefun.CONTROLLER_TYPE, controllerBody)
+	s.Require().NoError(err)
+
+	controllerObjectID := generate.UUID(controllerID + "uuid_1").String()
+	controllerObjectBody, ok := easyjson.JSONFromString(`{"object_id": "uuid_1", "result": {"name": "a"}, "seq": 3}`)
+	s.Require().True(ok)
+
+	err = cmdb.ObjectCreate(controllerObjectID, inStatefun.CONTROLLER_OBJECT_TYPE, controllerObjectBody)
+	s.Require().NoError(err)
+
+	err = cmdb.ObjectsLinkCreate(controllerID, controllerObjectID, controllerObjectID, []string{})
+	s.Require().NoError(err)
+
+	clientID := "3"
+	sessionID := generate.SessionID(clientID).String()
+
+	err = cmdb.ObjectCreate(sessionID, inStatefun.SESSION_TYPE, easyjson.NewJSONObjectWithKeyValue("client_id", easyjson.NewJSON(clientID)))
+	s.Require().NoError(err)
+
+	err = cmdb.ObjectsLinkCreate(controllerID, sessionID, "sub", []string{})
+	s.Require().NoError(err)
+
+	sub, err := s.SubscribeEgress(inStatefun.EGRESS, clientID)
+	s.Require().NoError(err)
+
+	payload := easyjson.NewJSONObjectWithKeyValue("snapshot", easyjson.NewJSON(sessionID))
+
+	err = s.Signal(sfplugins.JetstreamGlobalSignal, typename, controllerID, &payload, nil)
+	s.Require().NoError(err)
+
+	msg, err := sub.NextMsg(2 * time.Second)
+	s.Require().NoError(err)
+
+	wantPayload := fmt.Sprintf(`{"payload":{"event":"snapshot","plugin":"viewer","name":"%s","objects":{"uuid_1":{"seq":3,"result":{"name":"a"}}}}}`, controllerName)
+	s.JSONEq(wantPayload, string(msg.Data))
+}
+
+func (s *adapterTestSuite) Test_ConstructController_Correct() {
+	typename := inStatefun.CONTROLLER_CONSTRUCT
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	s.RegisterFunction(typename, adapter.ControllerConstruct, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1).SetAllowedRequestProviders(sfplugins.AutoRequestSelect))
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	cmdb.TypeCreate("test_uuid")
+
+	objectBody := easyjson.NewJSONObject()
+	objectBody.SetByPath("key", easyjson.NewJSON("value"))
+
+	err = cmdb.ObjectCreate("uuid_1", "test_uuid", objectBody)
+	s.Require().NoError(err)
+
+	payload := easyjson.NewJSONObject()
+	payload.SetByPath("props", easyjson.NewJSON("@property:key"))
+
+	result, err := s.Request(sfplugins.GolangLocalRequest, typename, "uuid_1", &payload, nil)
+	s.Require().NoError(err)
+
+	s.JSONEq(objectBody.GetByPath("key").ToString(), result.GetByPath("result.props").ToString())
+}
+
+func (s *adapterTestSuite) Test_ConstructController_ACL() {
+	typename := inStatefun.CONTROLLER_CONSTRUCT
+
+	cfg := adapter.DefaultConfig()
+	cfg.Authorizer = auth.ACLAuthorizer{}
+	defer adapter.ResetConfig()
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	adapter.RegisterFunctions(s.Runtime(), cfg)
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	s.Require().NoError(cmdb.TypeCreate("user"))
+	s.Require().NoError(cmdb.TypeCreate("node"))
+	s.Require().NoError(cmdb.TypesLinkCreate("user", "node", auth.ACLLinkType, []string{}))
+	s.Require().NoError(cmdb.ObjectCreate("alice", "user"))
+
+	nodeBody := easyjson.NewJSONObject()
+	nodeBody.SetByPath("name", easyjson.NewJSON("a"))
+	nodeBody.SetByPath("secret", easyjson.NewJSON("s"))
+	s.Require().NoError(cmdb.ObjectCreate("node_1", "node", nodeBody))
+
+	acl, ok := easyjson.JSONFromString(`{"properties": ["body.name"]}`)
+	s.Require().True(ok)
+	s.Require().NoError(cmdb.ObjectsLinkCreate("alice", "node_1", "node_1", []string{}, acl))
+
+	payload := easyjson.NewJSONObject()
+	payload.SetByPath("name", easyjson.NewJSON("@property:name"))
+	payload.SetByPath("secret", easyjson.NewJSON("@property:secret"))
+
+	options, ok := easyjson.JSONFromString(`{"principal": {"subject": "alice"}}`)
+	s.Require().True(ok)
+
+	result, err := s.Request(sfplugins.GolangLocalRequest, typename, "node_1", &payload, &options)
+	s.Require().NoError(err)
+
+	s.Equal("ok", result.GetByPath("status").AsStringDefault(""))
+	s.Equal("a", result.GetByPath("result.name").AsStringDefault(""))
+	s.False(result.PathExists("result.secret"))
+	s.JSONEq(`["secret"]`, result.GetByPath("denied").ToString())
+}
+
+func (s *adapterTestSuite) Test_ConstructController_Redaction() {
+	typename := inStatefun.CONTROLLER_CONSTRUCT
+
+	cfg := adapter.DefaultConfig()
+	cfg.Redaction = map[string][]adapter.RedactRule{
+		"node": {
+			{Path: "body.secret", Mode: adapter.RedactStrip},
+			{Path: "body.serial", Mode: adapter.RedactMask},
+		},
+	}
+	defer adapter.ResetConfig()
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	adapter.RegisterFunctions(s.Runtime(), cfg)
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	nodeBody, ok := easyjson.JSONFromString(`{"name": "a", "secret": "s", "serial": "XXXX"}`)
+	s.Require().True(ok)
+
+	s.Require().NoError(cmdb.TypeCreate("node"))
+	s.Require().NoError(cmdb.ObjectCreate("node_1", "node", nodeBody))
+
+	payload := easyjson.NewJSONObject()
+	payload.SetByPath("body", easyjson.NewJSON("@property:"))
+	payload.SetByPath("secret", easyjson.NewJSON("@property:secret"))
+	payload.SetByPath("serial", easyjson.NewJSON("@property:serial"))
+
+	result, err := s.Request(sfplugins.GolangLocalRequest, typename, "node_1", &payload, nil)
+	s.Require().NoError(err)
+
+	s.Equal("ok", result.GetByPath("status").AsStringDefault(""))
+	s.JSONEq(`{"name": "a", "serial": "***"}`, result.GetByPath("result.body").ToString())
+	s.False(result.PathExists("result.secret"))
+	s.Equal("***", result.GetByPath("result.serial").AsStringDefault(""))
+}
+
+func (s *adapterTestSuite) Test_ConstructController_RedactFunction() {
+	typename := inStatefun.CONTROLLER_CONSTRUCT
+
+	// accounts of the node are returned with their bodies, so they are redacted by rules of the account type
+	err := adapter.RegisterDecoratorFunction("test.accounts", adapter.DecoratorFunction{
+		Result: adapter.ResultObjects,
+		Handler: func(dctx *adapter.DecoratorContext) (easyjson.JSON, error) {
+			dctx.Depend("account_1")
+			accounts, _ := easyjson.JSONFromString(`{"account_1": {"user": "a", "token": "t"}}`)
+			return accounts, nil
+		},
+	})
+	s.Require().NoError(err)
+
+	// the shape of the result is unknown, so it can't be redacted
+	err = adapter.RegisterDecoratorFunction("test.raw", adapter.DecoratorFunction{
+		Handler: func(dctx *adapter.DecoratorContext) (easyjson.JSON, error) {
+			return easyjson.NewJSONObjectWithKeyValue("secret", easyjson.NewJSON("s")), nil
+		},
+	})
+	s.Require().NoError(err)
+
+	cfg := adapter.DefaultConfig()
+	cfg.Redaction = map[string][]adapter.RedactRule{
+		"node":    {{Path: "body.secret", Mode: adapter.RedactStrip}},
+		"account": {{Path: "body.token", Mode: adapter.RedactStrip}},
+	}
+	defer adapter.ResetConfig()
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	adapter.RegisterFunctions(s.Runtime(), cfg)
+
+	err = s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	s.Require().NoError(cmdb.TypeCreate("node"))
+	s.Require().NoError(cmdb.TypeCreate("account"))
+	s.Require().NoError(cmdb.TypesLinkCreate("node", "account", "account", []string{}))
+	s.Require().NoError(cmdb.ObjectCreate("node_1", "node", easyjson.NewJSONObjectWithKeyValue("secret", easyjson.NewJSON("s"))))
+	s.Require().NoError(cmdb.ObjectCreate("account_1", "account", easyjson.NewJSONObjectWithKeyValue("token", easyjson.NewJSON("t"))))
+	s.Require().NoError(cmdb.ObjectsLinkCreate("node_1", "account_1", "account_1", []string{}))
+
+	payload := easyjson.NewJSONObject()
+	payload.SetByPath("children", easyjson.NewJSON("@function:getChildrenUUIDSByLinkType(account)"))
+	payload.SetByPath("accounts", easyjson.NewJSON("@function:test.accounts()"))
+	payload.SetByPath("raw", easyjson.NewJSON("@function:test.raw()"))
+
+	result, err := s.Request(sfplugins.GolangLocalRequest, typename, "node_1", &payload, nil)
+	s.Require().NoError(err)
+
+	s.Equal("ok", result.GetByPath("status").AsStringDefault(""))
+	s.Equal(1, result.GetByPath("result.children").ArraySize())
+	s.JSONEq(`{"account_1": {"user": "a"}}`, result.GetByPath("result.accounts").ToString())
+	s.False(result.PathExists("result.raw"))
+	s.JSONEq(`["raw"]`, result.GetByPath("denied").ToString())
+}
+
+func (s *adapterTestSuite) Test_ConstructController_EachCycle() {
+	typename := inStatefun.CONTROLLER_CONSTRUCT
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	adapter.RegisterFunctions(s.Runtime())
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	// nodes are peers of each other, so constructing peers of peers leads back to the first node
+	s.Require().NoError(cmdb.TypeCreate("node"))
+	s.Require().NoError(cmdb.TypesLinkCreate("node", "node", "peer", []string{}))
+	s.Require().NoError(cmdb.ObjectCreate("node_a", "node", easyjson.NewJSONObjectWithKeyValue("name", easyjson.NewJSON("a"))))
+	s.Require().NoError(cmdb.ObjectCreate("node_b", "node", easyjson.NewJSONObjectWithKeyValue("name", easyjson.NewJSON("b"))))
+	s.Require().NoError(cmdb.ObjectsLinkCreate("node_a", "node_b", "node_b", []string{}))
+	s.Require().NoError(cmdb.ObjectsLinkCreate("node_b", "node_a", "node_a", []string{}))
+
+	payload, ok := easyjson.JSONFromString(`{
+		"peers": {
+			"@each": "@function:getChildrenUUIDSByLinkType(peer)",
+			"fields": {
+				"name": "@property:name",
+				"peers": {"@each": "@function:getChildrenUUIDSByLinkType(peer)", "fields": {"name": "@property:name"}}
+			}
+		}
+	}`)
+	s.Require().True(ok)
+
+	result, err := s.Request(sfplugins.GolangLocalRequest, typename, "node_a", &payload, nil)
+	s.Require().NoError(err)
+
+	s.Equal("ok", result.GetByPath("status").AsStringDefault(""))
+
+	peers := result.GetByPath("result.peers")
+	s.Require().Equal(1, len(peers.ObjectKeys()))
+
+	peer := peers.GetByPath(peers.ObjectKeys()[0])
+	s.Equal("b", peer.GetByPath("name").AsStringDefault(""))
+	s.Equal(`{}`, peer.GetByPath("peers").ToString())
+}
+
+func (s *adapterTestSuite) Test_ClearController_Correct() {
+	typename := inStatefun.CONTROLLER_CLEAR
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	session.RegisterFunctions(s.Runtime())
+	decorators.Register(s.Runtime())
+
+	s.OnAfterStartFunction(adapter.InitSchema, true)
+
+	s.RegisterFunction(typename, adapter.ClearController, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1).SetAllowedRequestProviders(sfplugins.AutoRequestSelect))
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	sessionID := generate.SessionID("1").String()
+	err = cmdb.ObjectCreate(sessionID, inStatefun.SESSION_TYPE, easyjson.NewJSONObjectWithKeyValue("client_id", easyjson.NewJSON("1")))
+	s.Require().NoError(err)
+
+	controllerID := generate.UUID("test_controller").String()
+	err = cmdb.ObjectCreate(controllerID, inStatefun.CONTROLLER_TYPE)
+	s.Require().NoError(err)
+
+	err = cmdb.ObjectsLinkCreate(controllerID, sessionID, sessionID, []string{})
+	s.Require().NoError(err)
+
+	err = cmdb.ObjectsLinkCreate(sessionID, controllerID, controllerID, []string{})
+	s.Require().NoError(err)
+
+	err = cmdb.TypeCreate("test_uuid")
+	s.Require().NoError(err)
+
+	err = cmdb.TypesLinkCreate(inStatefun.CONTROLLER_OBJECT_TYPE, "test_uuid", inStatefun.CONTROLLER_SUBJECT_TYPE, []string{})
+	s.Require().NoError(err)
+
+	err = cmdb.ObjectCreate("uuid_1", "test_uuid")
+	s.Require().NoError(err)
+
+	controllerObjectID := generate.UUID(controllerID + "uuid_1").String()
+	err = cmdb.ObjectCreate(controllerObjectID, inStatefun.CONTROLLER_OBJECT_TYPE)
+	s.Require().NoError(err)
+
+	err = cmdb.ObjectsLinkCreate(controllerID, controllerObjectID, controllerObjectID, []string{})
+	s.Require().NoError(err)
+
+	err = cmdb.ObjectsLinkCreate(controllerObjectID, "uuid_1", "uuid_1", []string{})
+	s.Require().NoError(err)
+
+	payload := easyjson.NewJSONObjectWithKeyValue("session_id", easyjson.NewJSON(sessionID))
+
+	result, err := s.Request(sfplugins.GolangLocalRequest, typename, controllerID, &payload, nil)
+	s.Require().NoError(err)
+
+	s.Equal("ok", result.GetByPath("status").AsStringDefault(""))
+	s.True(result.GetByPath("result.released").AsBoolDefault(false))
+
+	// controller is kept during grace period
+	controllerBody, err := s.CacheValue(controllerID)
+	s.Require().NoError(err)
+
+	s.Equal(float64(0), controllerBody.GetByPath("subscribers").AsNumericDefault(-1))
+	s.True(controllerBody.PathExists("released_at"))
+
+	_, err = s.CacheValue(controllerObjectID)
+	s.NoError(err)
+}
+
+func (s *adapterTestSuite) Test_CollectController_Released() {
+	typename := inStatefun.CONTROLLER_GC
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	session.RegisterFunctions(s.Runtime())
+	decorators.Register(s.Runtime())
+
+	s.OnAfterStartFunction(adapter.InitSchema, true)
+
+	s.RegisterFunction(typename, adapter.CollectController, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1))
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	releasedAt := time.Now().Add(-adapter.ControllerReleaseGracePeriod).Add(-time.Second).Unix()
+
+	controllerID := generate.UUID("test_controller").String()
+	err = cmdb.ObjectCreate(controllerID, inStatefun.CONTROLLER_TYPE, easyjson.NewJSONObjectWithKeyValue("released_at", easyjson.NewJSON(releasedAt)))
+	s.Require().NoError(err)
+
+	err = cmdb.TypeCreate("test_uuid")
+	s.Require().NoError(err)
+
+	err = cmdb.TypesLinkCreate(inStatefun.CONTROLLER_OBJECT_TYPE, "test_uuid", inStatefun.CONTROLLER_SUBJECT_TYPE, []string{})
+	s.Require().NoError(err)
+
+	err = cmdb.ObjectCreate("uuid_1", "test_uuid")
+	s.Require().NoError(err)
+
+	controllerObjectID := generate.UUID(controllerID + "uuid_1").String()
+	err = cmdb.ObjectCreate(controllerObjectID, inStatefun.CONTROLLER_OBJECT_TYPE)
+	s.Require().NoError(err)
+
+	err = cmdb.ObjectsLinkCreate(controllerID, controllerObjectID, controllerObjectID, []string{})
+	s.Require().NoError(err)
+
+	err = cmdb.ObjectsLinkCreate(controllerObjectID, "uuid_1", "uuid_1", []string{})
+	s.Require().NoError(err)
+
+	err = s.Signal(sfplugins.JetstreamGlobalSignal, typename, controllerID, nil, nil)
+	s.Require().NoError(err)
+
+	time.Sleep(1 * time.Second)
+
+	_, err = s.CacheValue(controllerObjectID)
+	s.Error(err)
+
+	_, err = s.CacheValue(controllerID)
+	s.Error(err)
+
+	// real object must stay untouched
+	_, err = s.CacheValue("uuid_1")
+	s.NoError(err)
+}
+
+func (s *adapterTestSuite) Test_CollectController_Orphan() {
+	typename := inStatefun.CONTROLLER_GC
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	session.RegisterFunctions(s.Runtime())
+	decorators.Register(s.Runtime())
+
+	s.OnAfterStartFunction(adapter.InitSchema, true)
+
+	s.RegisterFunction(typename, adapter.CollectController, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1))
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	controllerID := generate.UUID("test_controller").String()
+	err = cmdb.ObjectCreate(controllerID, inStatefun.CONTROLLER_TYPE, easyjson.NewJSONObjectWithKeyValue("name", easyjson.NewJSON("test_controller")))
+	s.Require().NoError(err)
+
+	err = s.Signal(sfplugins.JetstreamGlobalSignal, typename, controllerID, nil, nil)
+	s.Require().NoError(err)
+
+	time.Sleep(1 * time.Second)
+
+	// orphan gets its grace period started instead of being deleted immediately
+	controllerBody, err := s.CacheValue(controllerID)
+	s.Require().NoError(err)
+
+	s.True(controllerBody.PathExists("released_at"))
+}
+
+// nextEgress skips egress messages until one has the path and returns its value
+func (s *adapterTestSuite) nextEgress(sub *nats.Subscription, path string) easyjson.JSON {
+	for {
+		msg, err := sub.NextMsg(3 * time.Second)
+		s.Require().NoError(err)
+
+		data, ok := easyjson.JSONFromBytes(msg.Data)
+		s.Require().True(ok)
+
+		if data.PathExists(path) {
+			return data.GetByPath(path)
+		}
+	}
 }
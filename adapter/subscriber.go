@@ -0,0 +1,290 @@
+package adapter
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/foliagecp/sdk/clients/go/db"
+	sfplugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/ui-app-lib/internal/common"
+	"github.com/foliagecp/ui-app-lib/internal/jsonpatch"
+	inStatefun "github.com/foliagecp/ui-app-lib/internal/statefun"
+	"github.com/foliagecp/ui-app-lib/protocol"
+)
+
+/*
+subscriber is the delivery state of a session subscribed to the controller, it's kept in the body of
+the controller -> session link, so sessions sharing the controller get their own messages:
+
+	{
+		"encoding": "full" | "patch",
+		"version": 1,
+		"fields": ["name", ...], // top level result keys sent to the session, all if empty
+		"seq": {
+			"<object id>": 42 // last sequence number sent to the session
+		},
+		"acked": {
+			"<object id>": 41 // last sequence number acknowledged by the session, only if it has sent ACK
+		}
+	}
+*/
+type subscriber struct {
+	SessionID string            `json:"-"`
+	Encoding  protocol.Encoding `json:"encoding"`
+	Version   int               `json:"version"`
+	Fields    []string          `json:"fields,omitempty"`
+	Seq       map[string]uint64 `json:"seq"`
+	// Acked is nil until the session acknowledges some result, patches start from the last result sent till then
+	Acked map[string]uint64 `json:"acked,omitempty"`
+}
+
+// newSubscriber takes encoding and version negotiated by the session
+func newSubscriber(ctx *sfplugins.StatefunContextProcessor, sessionID string, fields []string) subscriber {
+	s := subscriber{
+		SessionID: sessionID,
+		Encoding:  protocol.EncodingFull,
+		Version:   protocol.DefaultVersion,
+		Fields:    fields,
+		Seq:       make(map[string]uint64),
+	}
+
+	if session, err := ctx.Domain.Cache().GetValueAsJSON(sessionID); err == nil {
+		s.Encoding = protocol.Encoding(session.GetByPath("encoding").AsStringDefault(string(s.Encoding)))
+		s.Version = int(session.GetByPath("version").AsNumericDefault(float64(s.Version)))
+	}
+
+	return s
+}
+
+// readSubscriber reads the state from the link, subscribers linked before the state existed get defaults of their session
+func readSubscriber(ctx *sfplugins.StatefunContextProcessor, sessionID string) subscriber {
+	body, err := common.OutLinkBody(ctx.Domain.Cache(), ctx.Self.ID, inStatefun.SUBSCRIBER_TYPE, sessionID)
+	if err != nil || !body.PathExists("encoding") {
+		return newSubscriber(ctx, sessionID, nil)
+	}
+
+	s := subscriber{}
+	if err := json.Unmarshal(body.ToBytes(), &s); err != nil {
+		return newSubscriber(ctx, sessionID, nil)
+	}
+
+	s.SessionID = sessionID
+	if s.Seq == nil {
+		s.Seq = make(map[string]uint64)
+	}
+
+	return s
+}
+
+func (s subscriber) toJSON() easyjson.JSON {
+	data, _ := json.Marshal(s)
+	body, _ := easyjson.JSONFromBytes(data)
+	return body
+}
+
+func (s subscriber) save(cmdb db.CMDBSyncClient, controllerID string) error {
+	return cmdb.ObjectsLinkUpdate(controllerID, s.SessionID, []string{}, s.toJSON(), true)
+}
+
+/*
+message tailors updates for the subscriber: results are filtered by its fields and patches start from
+the result acknowledged by it, or from the last result sent to it if it doesn't acknowledge results.
+Patches from an older result are joined from the history, if the history doesn't reach the result
+(e.g. the subscriber has just subscribed) the patch replaces the whole document. Updates it already has are skipped.
+Returns false if nothing is left to send.
+*/
+func (s *subscriber) message(plugin string, updates map[string]objectUpdate, history historyReader) (any, bool) {
+	full := make(map[string]json.RawMessage)
+	patches := make(map[string]protocol.ObjectPatch)
+	denied := make(map[string][]string)
+
+	for objectID, u := range updates {
+		last, known := s.Seq[objectID]
+		if known && u.Seq != 0 && u.Seq <= last {
+			continue
+		}
+
+		s.Seq[objectID] = u.Seq
+
+		if d := s.filterDenied(u.Denied); len(d) > 0 {
+			denied[objectID] = d
+		}
+
+		if s.Encoding != protocol.EncodingPatch {
+			full[objectID] = s.filter(u.Result)
+			continue
+		}
+
+		base := last
+		if s.Acked != nil {
+			base = s.Acked[objectID]
+		}
+
+		patch := protocol.ObjectPatch{BaseSeq: base, Seq: u.Seq}
+
+		if base == u.BaseSeq {
+			patch.Patch = s.filterPatch(u.Patch)
+		} else if ops, ok := s.history(history, objectID, base, u.Seq); ok {
+			patch.Patch = s.filterPatch(ops)
+		} else {
+			patch.Patch = jsonpatch.Replace(s.filter(u.Result))
+		}
+
+		patches[objectID] = patch
+	}
+
+	if s.Encoding == protocol.EncodingPatch {
+		if len(patches) == 0 {
+			return nil, false
+		}
+
+		return protocol.ControllerPatch{
+			Plugins: map[string]map[string]protocol.ObjectPatch{
+				plugin: patches,
+			},
+			Denied:      pluginDenied(plugin, denied),
+			Encoding:    protocol.EncodingPatch,
+			Unsolicited: true,
+		}, true
+	}
+
+	if len(full) == 0 {
+		return nil, false
+	}
+
+	return protocol.ControllerUpdate{
+		Plugins: map[string]map[string]json.RawMessage{
+			plugin: full,
+		},
+		Denied: pluginDenied(plugin, denied),
+		// update isn't an answer to any client request
+		Unsolicited: true,
+	}, true
+}
+
+// history builds the patch from the kept patches, there is nothing to build from the result the subscriber doesn't have
+func (s subscriber) history(history historyReader, objectID string, from, to uint64) ([]protocol.PatchOperation, bool) {
+	if history == nil || from == 0 {
+		return nil, false
+	}
+
+	return history(objectID, from, to)
+}
+
+// ack remembers results acknowledged by the subscriber, results which weren't sent to it and older ones are ignored.
+// Returns false if nothing has changed.
+func (s *subscriber) ack(seq map[string]uint64) bool {
+	if s.Acked == nil {
+		s.Acked = make(map[string]uint64)
+	}
+
+	changed := false
+
+	for objectID, n := range seq {
+		if n > s.Seq[objectID] || n <= s.Acked[objectID] {
+			continue
+		}
+
+		s.Acked[objectID] = n
+		changed = true
+	}
+
+	return changed
+}
+
+// snapshot filters results for the subscriber and remembers their sequence numbers as sent,
+// the subscriber acknowledging results is expected to hold them, so patches start from them
+func (s *subscriber) snapshot(objects map[string]protocol.ObjectSnapshot) map[string]protocol.ObjectSnapshot {
+	out := make(map[string]protocol.ObjectSnapshot, len(objects))
+
+	for objectID, o := range objects {
+		s.Seq[objectID] = o.Seq
+
+		if s.Acked != nil {
+			s.Acked[objectID] = o.Seq
+		}
+		out[objectID] = protocol.ObjectSnapshot{Seq: o.Seq, Result: s.filter(o.Result), Denied: s.filterDenied(o.Denied)}
+	}
+
+	return out
+}
+
+// filter keeps only the subscriber fields of an object result, other results are sent as they are
+func (s subscriber) filter(result json.RawMessage) json.RawMessage {
+	if len(s.Fields) == 0 {
+		return result
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(result, &object); err != nil {
+		return result
+	}
+
+	for key := range object {
+		if !slices.Contains(s.Fields, key) {
+			delete(object, key)
+		}
+	}
+
+	data, err := json.Marshal(object)
+	if err != nil {
+		return result
+	}
+
+	return data
+}
+
+// filterPatch drops operations on keys which aren't the subscriber fields, the whole document replacement is filtered
+func (s subscriber) filterPatch(patch []protocol.PatchOperation) []protocol.PatchOperation {
+	if len(s.Fields) == 0 {
+		return patch
+	}
+
+	out := make([]protocol.PatchOperation, 0, len(patch))
+
+	for _, op := range patch {
+		if op.Path == "" {
+			op.Value = s.filter(op.Value)
+			out = append(out, op)
+			continue
+		}
+
+		key, _, _ := strings.Cut(strings.TrimPrefix(op.Path, "/"), "/")
+		key = strings.NewReplacer("~1", "/", "~0", "~").Replace(key)
+
+		if slices.Contains(s.Fields, key) {
+			out = append(out, op)
+		}
+	}
+
+	return out
+}
+
+// filterDenied keeps denied paths under the subscriber fields
+func (s subscriber) filterDenied(denied []string) []string {
+	if len(s.Fields) == 0 {
+		return denied
+	}
+
+	out := make([]string, 0, len(denied))
+
+	for _, path := range denied {
+		key, _, _ := strings.Cut(path, ".")
+		if slices.Contains(s.Fields, key) {
+			out = append(out, path)
+		}
+	}
+
+	return out
+}
+
+// pluginDenied wraps denied keys of objects for the message, nil if nothing is denied
+func pluginDenied(plugin string, denied map[string][]string) map[string]map[string][]string {
+	if len(denied) == 0 {
+		return nil
+	}
+
+	return map[string]map[string][]string{plugin: denied}
+}
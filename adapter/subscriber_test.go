@@ -0,0 +1,150 @@
+package adapter
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/foliagecp/ui-app-lib/internal/jsonpatch"
+	"github.com/foliagecp/ui-app-lib/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriber_Message(t *testing.T) {
+	update := objectUpdate{
+		ObjectID: "uuid",
+		Result:   []byte(`{"name":"b","secret":"s"}`),
+		BaseSeq:  1,
+		Seq:      2,
+		Patch: []protocol.PatchOperation{
+			{Op: jsonpatch.OpReplace, Path: "/name", Value: []byte(`"b"`)},
+			{Op: jsonpatch.OpReplace, Path: "/secret", Value: []byte(`"s"`)},
+		},
+	}
+	updates := map[string]objectUpdate{"uuid": update}
+
+	// has the previous result, gets the filtered patch
+	sub := subscriber{Encoding: protocol.EncodingPatch, Fields: []string{"name"}, Seq: map[string]uint64{"uuid": 1}}
+
+	msg, ok := sub.message("viewer", updates, nil)
+	require.True(t, ok)
+	require.JSONEq(t, `{"plugins":{"viewer":{"uuid":{"base_seq":1,"seq":2,"patch":[{"op":"replace","path":"/name","value":"b"}]}}},"encoding":"patch","unsolicited":true}`, marshal(t, msg))
+	require.Equal(t, uint64(2), sub.Seq["uuid"])
+
+	// already sent
+	_, ok = sub.message("viewer", updates, nil)
+	require.False(t, ok)
+
+	// has missed the previous result, gets the whole document
+	sub = subscriber{Encoding: protocol.EncodingPatch, Seq: map[string]uint64{}}
+
+	msg, ok = sub.message("viewer", updates, nil)
+	require.True(t, ok)
+	require.JSONEq(t, `{"plugins":{"viewer":{"uuid":{"base_seq":0,"seq":2,"patch":[{"op":"replace","path":"","value":{"name":"b","secret":"s"}}]}}},"encoding":"patch","unsolicited":true}`, marshal(t, msg))
+
+	sub = subscriber{Encoding: protocol.EncodingFull, Fields: []string{"name"}, Seq: map[string]uint64{}}
+
+	msg, ok = sub.message("viewer", updates, nil)
+	require.True(t, ok)
+	require.JSONEq(t, `{"plugins":{"viewer":{"uuid":{"name":"b"}}},"unsolicited":true}`, marshal(t, msg))
+}
+
+func TestSubscriber_MessageAcked(t *testing.T) {
+	update := objectUpdate{
+		ObjectID: "uuid",
+		Result:   []byte(`{"name":"c"}`),
+		BaseSeq:  2,
+		Seq:      3,
+		Patch:    []protocol.PatchOperation{{Op: jsonpatch.OpReplace, Path: "/name", Value: []byte(`"c"`)}},
+	}
+	updates := map[string]objectUpdate{"uuid": update}
+
+	history := func(objectID string, from, to uint64) ([]protocol.PatchOperation, bool) {
+		if objectID != "uuid" || from != 1 || to != 3 {
+			return nil, false
+		}
+
+		return []protocol.PatchOperation{
+			{Op: jsonpatch.OpReplace, Path: "/name", Value: []byte(`"b"`)},
+			{Op: jsonpatch.OpReplace, Path: "/name", Value: []byte(`"c"`)},
+		}, true
+	}
+
+	// result 2 was sent but isn't acknowledged, the patch starts from the acknowledged one
+	sub := subscriber{Encoding: protocol.EncodingPatch, Seq: map[string]uint64{"uuid": 2}}
+	require.True(t, sub.ack(map[string]uint64{"uuid": 1}))
+
+	msg, ok := sub.message("viewer", updates, history)
+	require.True(t, ok)
+	require.JSONEq(t, `{"plugins":{"viewer":{"uuid":{"base_seq":1,"seq":3,"patch":[{"op":"replace","path":"/name","value":"b"},{"op":"replace","path":"/name","value":"c"}]}}},"encoding":"patch","unsolicited":true}`, marshal(t, msg))
+
+	// acknowledged the previous result, gets the patch as it is
+	sub = subscriber{Encoding: protocol.EncodingPatch, Seq: map[string]uint64{"uuid": 2}}
+	require.True(t, sub.ack(map[string]uint64{"uuid": 2}))
+
+	msg, ok = sub.message("viewer", updates, history)
+	require.True(t, ok)
+	require.JSONEq(t, `{"plugins":{"viewer":{"uuid":{"base_seq":2,"seq":3,"patch":[{"op":"replace","path":"/name","value":"c"}]}}},"encoding":"patch","unsolicited":true}`, marshal(t, msg))
+
+	// the history doesn't reach the acknowledged result, gets the whole document
+	sub = subscriber{Encoding: protocol.EncodingPatch, Seq: map[string]uint64{"uuid": 2}, Acked: map[string]uint64{}}
+
+	msg, ok = sub.message("viewer", updates, history)
+	require.True(t, ok)
+	require.JSONEq(t, `{"plugins":{"viewer":{"uuid":{"base_seq":0,"seq":3,"patch":[{"op":"replace","path":"","value":{"name":"c"}}]}}},"encoding":"patch","unsolicited":true}`, marshal(t, msg))
+}
+
+func TestSubscriber_Ack(t *testing.T) {
+	sub := subscriber{Seq: map[string]uint64{"uuid": 3}}
+
+	// results which weren't sent can't be acknowledged
+	require.False(t, sub.ack(map[string]uint64{"uuid": 4, "other": 1}))
+	require.Empty(t, sub.Acked)
+
+	require.True(t, sub.ack(map[string]uint64{"uuid": 3}))
+	require.Equal(t, uint64(3), sub.Acked["uuid"])
+
+	// late acknowledgement of an older result
+	require.False(t, sub.ack(map[string]uint64{"uuid": 2}))
+	require.Equal(t, uint64(3), sub.Acked["uuid"])
+
+	// snapshot results are acknowledged by the subscriber which acknowledges results
+	sub.snapshot(map[string]protocol.ObjectSnapshot{"uuid": {Seq: 5, Result: []byte(`{}`)}})
+	require.Equal(t, uint64(5), sub.Acked["uuid"])
+}
+
+func TestSubscriber_MessageDenied(t *testing.T) {
+	updates := map[string]objectUpdate{
+		"uuid": {ObjectID: "uuid", Result: []byte(`{"name":"b"}`), Seq: 1, Denied: []string{"links.child.secret", "secret"}},
+	}
+
+	sub := subscriber{Encoding: protocol.EncodingFull, Seq: map[string]uint64{}}
+
+	msg, ok := sub.message("viewer", updates, nil)
+	require.True(t, ok)
+	require.JSONEq(t, `{"plugins":{"viewer":{"uuid":{"name":"b"}}},"denied":{"viewer":{"uuid":["links.child.secret","secret"]}},"unsolicited":true}`, marshal(t, msg))
+
+	// denied keys outside of the subscriber fields aren't reported
+	sub = subscriber{Encoding: protocol.EncodingFull, Fields: []string{"name", "links"}, Seq: map[string]uint64{}}
+
+	msg, ok = sub.message("viewer", updates, nil)
+	require.True(t, ok)
+	require.JSONEq(t, `{"plugins":{"viewer":{"uuid":{"name":"b"}}},"denied":{"viewer":{"uuid":["links.child.secret"]}},"unsolicited":true}`, marshal(t, msg))
+}
+
+func TestSubscriber_Snapshot(t *testing.T) {
+	sub := subscriber{Fields: []string{"name"}, Seq: map[string]uint64{}}
+
+	objects := sub.snapshot(map[string]protocol.ObjectSnapshot{
+		"uuid": {Seq: 3, Result: []byte(`{"name":"a","secret":"s"}`)},
+	})
+
+	require.Equal(t, uint64(3), objects["uuid"].Seq)
+	require.JSONEq(t, `{"name":"a"}`, string(objects["uuid"].Result))
+	require.Equal(t, uint64(3), sub.Seq["uuid"])
+}
+
+func marshal(t *testing.T, v any) string {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return string(data)
+}
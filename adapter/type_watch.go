@@ -0,0 +1,229 @@
+package adapter
+
+import (
+	"log/slog"
+	"slices"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/foliagecp/sdk/clients/go/db"
+	sfplugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/ui-app-lib/internal/generate"
+	inStatefun "github.com/foliagecp/ui-app-lib/internal/statefun"
+)
+
+// type watch operations
+const (
+	_WATCH   = "watch"
+	_UNWATCH = "unwatch"
+	_CHECK   = "check"
+)
+
+const (
+	_TYPE_WATCHERS = "watchers"
+	// _TYPE_LINKED lists types whose links from the watched type have the triggers set
+	_TYPE_LINKED = "linked"
+)
+
+func signalTypeWatch(ctx *sfplugins.StatefunContextProcessor, op, objectType, controllerObjectID, objectID string) {
+	if objectType == "" {
+		return
+	}
+
+	payload := easyjson.NewJSONObject()
+	payload.SetByPath("op", easyjson.NewJSON(op))
+	payload.SetByPath("controller_object", easyjson.NewJSON(controllerObjectID))
+	payload.SetByPath("object", easyjson.NewJSON(objectID))
+
+	if err := ctx.Signal(sfplugins.JetstreamGlobalSignal, inStatefun.CONTROLLER_TYPE_WATCH, objectType, &payload, nil); err != nil {
+		slog.Warn("failed to signal type watch", "type", objectType, "op", op, "err", err.Error())
+	}
+}
+
+/*
+Signal on object type, keeps the triggers of the type set while any controller object watches objects of the type.
+Watchers are kept in the function context, so they survive restarts.
+
+	Payload: {
+		"op": "watch" | "unwatch" | "check",
+		"controller_object": string,
+		"object": string
+	}
+
+	Function context: {
+		"watchers": {
+			"<watcher id>": "<controller object id>"
+		},
+		"linked": []string
+	}
+
+Watchers are registered with domain qualified ids. "check" drops watchers whose controller objects don't exist
+anymore (e.g. left after crash). The triggers are set on the first watcher and removed after the last one.
+
+The graph doesn't trigger types link creation, so links to types linked after the first watcher get the triggers
+when one more object of the type is watched. Until then such links don't refresh controllers.
+*/
+func WatchType(_ sfplugins.StatefunExecutor, ctx *sfplugins.StatefunContextProcessor) {
+	objectType := ctx.Self.ID
+	payload := ctx.Payload
+
+	op := payload.GetByPath("op").AsStringDefault(_CHECK)
+	controllerObjectID := ctx.Domain.CreateObjectIDWithThisDomain(payload.GetByPath("controller_object").AsStringDefault(""), false)
+	objectID := ctx.Domain.CreateObjectIDWithThisDomain(payload.GetByPath("object").AsStringDefault(""), false)
+
+	// object context of the type is the type body, watchers are kept apart from it
+	body := ctx.GetFunctionContext()
+	watchers := body.GetByPath(_TYPE_WATCHERS)
+	if !watchers.IsObject() {
+		watchers = easyjson.NewJSONObject()
+	}
+
+	before := watchers.KeysCount()
+
+	watcherID := generate.UUID(controllerObjectID + objectID).String()
+
+	switch op {
+	case _WATCH:
+		watchers.SetByPath(watcherID, easyjson.NewJSON(controllerObjectID))
+	case _UNWATCH:
+		watchers.RemoveByPath(watcherID)
+	case _CHECK:
+		for _, key := range watchers.ObjectKeys() {
+			if _, err := ctx.Domain.Cache().GetValueAsJSON(watchers.GetByPath(key).AsStringDefault("")); err != nil {
+				watchers.RemoveByPath(key)
+			}
+		}
+	}
+
+	after := watchers.KeysCount()
+	body.SetByPath(_TYPE_WATCHERS, watchers)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(ctx.Request)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.SetFunctionContext(body)
+		return
+	}
+
+	switch {
+	case before == 0 && after > 0:
+		slog.Info("Set controller triggers", "type", objectType)
+		body.SetByPath(_TYPE_LINKED, easyjson.JSONFromArray(setTriggers(cmdb, objectType)))
+	case after == 0 && (before > 0 || op == _CHECK):
+		slog.Info("Remove controller triggers", "type", objectType)
+		removeTriggers(cmdb, objectType)
+		body.RemoveByPath(_TYPE_LINKED)
+	case after > 0 && op == _WATCH:
+		linked, _ := body.GetByPath(_TYPE_LINKED).AsArrayString()
+		body.SetByPath(_TYPE_LINKED, easyjson.JSONFromArray(setNewLinkTriggers(cmdb, objectType, linked)))
+	}
+
+	ctx.SetFunctionContext(body)
+}
+
+/*
+setTriggers makes ControllerObjectTrigger fire on:
+
+	update of objects of the type, new objects aren't watched yet and are found through links to them
+	create, update and delete of out links of the objects, e.g. a disk is added to a node
+	delete of the link between controller object and the object, it happens when the object is deleted
+*/
+func setTriggers(cmdb db.CMDBSyncClient, objectType string) []string {
+	return switchTriggers(cmdb, objectType, true)
+}
+
+// setNewLinkTriggers sets the triggers on links to types which aren't linked yet, returns all linked types
+func setNewLinkTriggers(cmdb db.CMDBSyncClient, objectType string, linked []string) []string {
+	typeData, err := cmdb.TypeRead(objectType)
+	if err != nil {
+		slog.Warn("failed to read type", "type", objectType, "err", err.Error())
+		return linked
+	}
+
+	toTypes, _ := typeData.GetByPath("to_types").AsArrayString()
+	for _, toType := range toTypes {
+		if !slices.Contains(linked, toType) {
+			switchLinkTriggers(cmdb, objectType, toType, linkTriggers, true)
+		}
+	}
+
+	return toTypes
+}
+
+// removeTriggers removes only ControllerObjectTrigger, triggers of other functions are kept
+func removeTriggers(cmdb db.CMDBSyncClient, objectType string) {
+	switchTriggers(cmdb, objectType, false)
+}
+
+var (
+	objectTriggers = []db.TriggerType{db.UpdateTrigger}
+	linkTriggers   = []db.TriggerType{db.CreateTrigger, db.UpdateTrigger, db.DeleteTrigger}
+)
+
+// switchTriggers edits trigger lists of the type and its links in place, the client helpers
+// replace the whole list on set and drop the whole body on delete. Returns types the links go to.
+func switchTriggers(cmdb db.CMDBSyncClient, objectType string, on bool) []string {
+	typeData, err := cmdb.TypeRead(objectType)
+	if err != nil {
+		slog.Warn("failed to read type", "type", objectType, "err", err.Error())
+		return nil
+	}
+
+	body := switchTriggerLists(typeData.GetByPath("body"), objectTriggers, on)
+	if err := cmdb.TypeUpdate(objectType, body, true); err != nil {
+		slog.Warn("failed to update object triggers", "type", objectType, "err", err.Error())
+	}
+
+	switchLinkTriggers(cmdb, inStatefun.CONTROLLER_OBJECT_TYPE, objectType, []db.TriggerType{db.DeleteTrigger}, on)
+
+	toTypes, _ := typeData.GetByPath("to_types").AsArrayString()
+	for _, toType := range toTypes {
+		switchLinkTriggers(cmdb, objectType, toType, linkTriggers, on)
+	}
+
+	return toTypes
+}
+
+func switchLinkTriggers(cmdb db.CMDBSyncClient, from, to string, triggers []db.TriggerType, on bool) {
+	linkData, err := cmdb.TypesLinkRead(from, to)
+	if err != nil {
+		slog.Warn("failed to read types link", "from", from, "to", to, "err", err.Error())
+		return
+	}
+
+	tags, _ := linkData.GetByPath("tags").AsArrayString()
+	if tags == nil {
+		tags = []string{}
+	}
+
+	body := switchTriggerLists(linkData.GetByPath("body"), triggers, on)
+	if err := cmdb.TypesLinkUpdate(from, to, tags, body, true); err != nil {
+		slog.Warn("failed to update link triggers", "from", from, "to", to, "err", err.Error())
+	}
+}
+
+// switchTriggerLists adds or removes ControllerObjectTrigger in "triggers.<type>" lists of the body
+func switchTriggerLists(body easyjson.JSON, triggers []db.TriggerType, on bool) easyjson.JSON {
+	if !body.IsObject() {
+		body = easyjson.NewJSONObject()
+	}
+
+	for _, tt := range triggers {
+		path := "triggers." + tt
+		functions, _ := body.GetByPath(path).AsArrayString()
+
+		list := make([]string, 0, len(functions)+1)
+		for _, f := range functions {
+			if f != inStatefun.CONTROLLER_OBJECT_TRIGGER {
+				list = append(list, f)
+			}
+		}
+
+		if on {
+			list = append(list, inStatefun.CONTROLLER_OBJECT_TRIGGER)
+		}
+
+		body.SetByPath(path, easyjson.JSONFromArray(list))
+	}
+
+	return body
+}
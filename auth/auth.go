@@ -0,0 +1,33 @@
+// Package auth verifies tokens UI clients present on START_SESSION and authorizes what they read.
+//
+// Authenticator is the extension point, JWTVerifier checks HMAC and RSA signed JWTs with the standard library only.
+// Authorizer limits objects and their fields sessions may read, ACLAuthorizer reads ACLs from CMDB links.
+package auth
+
+import "errors"
+
+var (
+	ErrMissingToken = errors.New("missing token")
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// Principal is the verified identity of the client, it's stored on the session
+type Principal struct {
+	// Subject identifies the user, e.g. the "sub" claim
+	Subject string `json:"subject"`
+	// Claims are all verified claims of the token
+	Claims map[string]any `json:"claims,omitempty"`
+}
+
+// Authenticator verifies the token sent with START_SESSION, e.g. a JWT or a signed NATS nkey challenge.
+// Errors are sent to the client, so they shouldn't disclose more than why the token is rejected.
+type Authenticator interface {
+	Authenticate(token string) (Principal, error)
+}
+
+// AuthenticatorFunc adapts a function to Authenticator
+type AuthenticatorFunc func(token string) (Principal, error)
+
+func (f AuthenticatorFunc) Authenticate(token string) (Principal, error) {
+	return f(token)
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+
+	sfplugins "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/ui-app-lib/internal/common"
+)
+
+// AccessRequest describes what is going to be read on behalf of the principal
+type AccessRequest struct {
+	Principal  Principal
+	ObjectID   string
+	// ObjectType is the type of the object for custom authorizers, ACLAuthorizer grants access per object
+	ObjectType string
+	// Path of @property as the object path, e.g. "body.name", "body" is the whole object body
+	Path string
+	// Function name of @function, nested calls are requested one by one
+	Function string
+	// Attach is true when the object is attached to a controller, Path and Function are empty then
+	Attach bool
+}
+
+// Authorizer decides whether the principal may read the object, its property or function result.
+// It's consulted before a controller object is created and before every decorator runs.
+type Authorizer interface {
+	Authorize(ctx *sfplugins.StatefunContextProcessor, req AccessRequest) (bool, error)
+}
+
+// AuthorizerFunc adapts a function to Authorizer
+type AuthorizerFunc func(ctx *sfplugins.StatefunContextProcessor, req AccessRequest) (bool, error)
+
+func (f AuthorizerFunc) Authorize(ctx *sfplugins.StatefunContextProcessor, req AccessRequest) (bool, error) {
+	return f(ctx, req)
+}
+
+// ACLLinkType is the type of links ACLAuthorizer reads
+const ACLLinkType = "ui_acl"
+
+// any property or function
+const aclWildcard = "*"
+
+/*
+ACLAuthorizer is the reference Authorizer which reads ACLs from CMDB links of ACLLinkType
+from the principal object to the object. The link body lists what may be read:
+
+	{
+		"properties": ["body.name", "body.status"], // a path allows all its nested paths, "*" allows any
+		"functions": ["getLinksByType"] // "*" allows any
+	}
+
+Any link allows to attach the object to a controller, no link denies everything.
+*/
+type ACLAuthorizer struct {
+	// PrincipalID returns id of the principal object, the subject is used if nil. Ids without a domain are in this domain.
+	PrincipalID func(p Principal) string
+}
+
+func (a ACLAuthorizer) Authorize(ctx *sfplugins.StatefunContextProcessor, req AccessRequest) (bool, error) {
+	principalID := req.Principal.Subject
+	if a.PrincipalID != nil {
+		principalID = a.PrincipalID(req.Principal)
+	}
+
+	if principalID == "" {
+		return false, nil
+	}
+
+	// objects are domain qualified, so are links to them
+	principalID = ctx.Domain.CreateObjectIDWithThisDomain(principalID, false)
+
+	store := ctx.Domain.Cache()
+
+	acl, err := common.OutLinkBody(store, principalID, ACLLinkType, req.ObjectID)
+	if err != nil {
+		return false, nil
+	}
+
+	switch {
+	case req.Attach:
+		return true, nil
+	case req.Function != "":
+		functions, _ := acl.GetByPath("functions").AsArrayString()
+		return slices.Contains(functions, aclWildcard) || slices.Contains(functions, req.Function), nil
+	default:
+		properties, _ := acl.GetByPath("properties").AsArrayString()
+		return allowsPath(properties, req.Path), nil
+	}
+}
+
+// allowsPath reports whether the path or one of its parents is listed
+func allowsPath(rules []string, path string) bool {
+	for _, rule := range rules {
+		if rule == aclWildcard || rule == path || (path != "" && strings.HasPrefix(path, rule+".")) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,21 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowsPath(t *testing.T) {
+	rules := []string{"body.name", "body.status"}
+
+	require.True(t, allowsPath(rules, "body.name"))
+	require.True(t, allowsPath(rules, "body.status.code"))
+	require.False(t, allowsPath(rules, "body.names"))
+	require.False(t, allowsPath(rules, "body"))
+	require.False(t, allowsPath(rules, ""))
+
+	require.True(t, allowsPath([]string{"*"}, ""))
+	require.True(t, allowsPath([]string{"*"}, "body.secret"))
+	require.False(t, allowsPath(nil, "body.name"))
+}
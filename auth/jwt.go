@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+)
+
+var hmacAlgorithms = map[string]func() hash.Hash{
+	"HS256": sha256.New,
+	"HS384": sha512.New384,
+	"HS512": sha512.New,
+}
+
+var rsaAlgorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+}
+
+/*
+JWTVerifier checks compact JWS tokens signed with HS256/384/512 or RS256/384/512.
+
+Only algorithms of configured keys are accepted, so an RSA public key can't be used as an HMAC secret.
+The "exp" and "nbf" claims are checked when present, "iss" and "aud" when Issuer and Audience are set.
+The "sub" claim is required and becomes Principal.Subject.
+*/
+type JWTVerifier struct {
+	// HMACSecret enables HS* algorithms
+	HMACSecret []byte
+	// RSAPublicKey enables RS* algorithms
+	RSAPublicKey *rsa.PublicKey
+	// Issuer is the required "iss" claim, any if empty
+	Issuer string
+	// Audience must be listed in the "aud" claim, any if empty
+	Audience string
+	// Leeway is the allowed clock skew for "exp" and "nbf"
+	Leeway time.Duration
+
+	now func() time.Time
+}
+
+func NewHMACVerifier(secret []byte) *JWTVerifier {
+	return &JWTVerifier{HMACSecret: secret}
+}
+
+func NewRSAVerifier(key *rsa.PublicKey) *JWTVerifier {
+	return &JWTVerifier{RSAPublicKey: key}
+}
+
+func (v *JWTVerifier) Authenticate(token string) (Principal, error) {
+	if token == "" {
+		return Principal{}, ErrMissingToken
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Principal{}, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Principal{}, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: signature: %v", ErrInvalidToken, err)
+	}
+
+	if err := v.verify(header.Alg, parts[0]+"."+parts[1], signature); err != nil {
+		return Principal{}, err
+	}
+
+	claims := make(map[string]any)
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Principal{}, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+
+	if err := v.validate(claims); err != nil {
+		return Principal{}, err
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return Principal{}, fmt.Errorf("%w: missing sub", ErrInvalidToken)
+	}
+
+	return Principal{Subject: subject, Claims: claims}, nil
+}
+
+func (v *JWTVerifier) verify(alg, signed string, signature []byte) error {
+	if newHash, ok := hmacAlgorithms[alg]; ok && len(v.HMACSecret) > 0 {
+		mac := hmac.New(newHash, v.HMACSecret)
+		mac.Write([]byte(signed))
+
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+
+		return nil
+	}
+
+	if h, ok := rsaAlgorithms[alg]; ok && v.RSAPublicKey != nil {
+		hasher := h.New()
+		hasher.Write([]byte(signed))
+
+		if err := rsa.VerifyPKCS1v15(v.RSAPublicKey, h, hasher.Sum(nil), signature); err != nil {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("%w: algorithm %q isn't accepted", ErrInvalidToken, alg)
+}
+
+func (v *JWTVerifier) validate(claims map[string]any) error {
+	now := time.Now()
+	if v.now != nil {
+		now = v.now()
+	}
+
+	if exp, ok := claims["exp"].(float64); ok && now.Add(-v.Leeway).After(time.Unix(int64(exp), 0)) {
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(v.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+
+	if v.Issuer != "" && claims["iss"] != v.Issuer {
+		return fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+	}
+
+	if v.Audience != "" && !hasAudience(claims["aud"], v.Audience) {
+		return fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+	}
+
+	return nil
+}
+
+// hasAudience checks "aud" which is either a string or an array of strings
+func hasAudience(aud any, want string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == want
+	case []any:
+		for _, v := range a {
+			if v == want {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
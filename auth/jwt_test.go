@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func sign(t *testing.T, alg string, claims map[string]any, key any) string {
+	header, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	require.NoError(t, err)
+
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var signature []byte
+
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTVerifier_HMAC(t *testing.T) {
+	secret := []byte("secret")
+	v := NewHMACVerifier(secret)
+
+	principal, err := v.Authenticate(sign(t, "HS256", map[string]any{"sub": "alice", "role": "admin"}, secret))
+	require.NoError(t, err)
+	require.Equal(t, "alice", principal.Subject)
+	require.Equal(t, "admin", principal.Claims["role"])
+
+	_, err = v.Authenticate(sign(t, "HS256", map[string]any{"sub": "alice"}, []byte("other")))
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = v.Authenticate(sign(t, "none", map[string]any{"sub": "alice"}, nil))
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = v.Authenticate(sign(t, "HS256", map[string]any{"role": "admin"}, secret))
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = v.Authenticate("")
+	require.ErrorIs(t, err, ErrMissingToken)
+
+	_, err = v.Authenticate("a.b")
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestJWTVerifier_RSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	v := NewRSAVerifier(&key.PublicKey)
+
+	principal, err := v.Authenticate(sign(t, "RS256", map[string]any{"sub": "bob"}, key))
+	require.NoError(t, err)
+	require.Equal(t, "bob", principal.Subject)
+
+	// HMAC isn't accepted without a secret, so the public key can't be used to forge tokens
+	_, err = v.Authenticate(sign(t, "HS256", map[string]any{"sub": "bob"}, []byte("secret")))
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestJWTVerifier_Claims(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Unix(1700000000, 0)
+
+	v := NewHMACVerifier(secret)
+	v.Issuer = "foliage"
+	v.Audience = "ui"
+	v.Leeway = time.Minute
+	v.now = func() time.Time { return now }
+
+	valid := map[string]any{"sub": "alice", "iss": "foliage", "aud": []string{"api", "ui"}, "exp": now.Unix() - 30}
+
+	_, err := v.Authenticate(sign(t, "HS256", valid, secret))
+	require.NoError(t, err)
+
+	cases := map[string]map[string]any{
+		"expired":   {"sub": "alice", "iss": "foliage", "aud": "ui", "exp": now.Unix() - 120},
+		"nbf":       {"sub": "alice", "iss": "foliage", "aud": "ui", "nbf": now.Unix() + 120},
+		"issuer":    {"sub": "alice", "iss": "other", "aud": "ui"},
+		"audience":  {"sub": "alice", "iss": "foliage", "aud": "api"},
+		"no issuer": {"sub": "alice", "aud": "ui"},
+	}
+
+	for name, claims := range cases {
+		_, err := v.Authenticate(sign(t, "HS256", claims, secret))
+		require.True(t, errors.Is(err, ErrInvalidToken), name)
+	}
+}
@@ -0,0 +1,34 @@
+// Command protocol-schema writes JSON Schema of ui-app-lib protocol messages.
+//
+//	go run ./cmd/protocol-schema -o protocol/schema.json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/foliagecp/ui-app-lib/protocol"
+)
+
+func main() {
+	out := flag.String("o", "", "output file, stdout if empty")
+	flag.Parse()
+
+	data, err := json.MarshalIndent(protocol.Schema(), "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	data = append(data, '\n')
+
+	if *out == "" {
+		os.Stdout.Write(data)
+		return
+	}
+
+	if err := os.WriteFile(*out, data, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,147 @@
+package uilib
+
+import (
+	"time"
+
+	"github.com/foliagecp/ui-app-lib/adapter"
+	"github.com/foliagecp/ui-app-lib/auth"
+	"github.com/foliagecp/ui-app-lib/session"
+)
+
+type Config struct {
+	Session session.Config
+	Adapter adapter.Config
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Session: session.DefaultConfig(),
+		Adapter: adapter.DefaultConfig(),
+	}
+}
+
+type Option func(c *Config)
+
+func newConfig(opts ...Option) Config {
+	cfg := DefaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return cfg
+}
+
+func WithSessionConfig(cfg session.Config) Option {
+	return func(c *Config) {
+		c.Session = cfg
+	}
+}
+
+func WithAdapterConfig(cfg adapter.Config) Option {
+	return func(c *Config) {
+		c.Adapter = cfg
+	}
+}
+
+func WithSessionInactivityTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.Session.InactivityTimeout = timeout
+	}
+}
+
+// WithHeartbeat enables PINGs every interval, the session is closed after maxMissedPongs unanswered ones
+func WithHeartbeat(interval time.Duration, maxMissedPongs int) Option {
+	return func(c *Config) {
+		c.Session.HeartbeatInterval = interval
+		c.Session.MaxMissedPongs = maxMissedPongs
+	}
+}
+
+func WithSessionClosingTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.Session.ClosingTimeout = timeout
+	}
+}
+
+func WithControllerReleaseGracePeriod(period time.Duration) Option {
+	return func(c *Config) {
+		c.Adapter.ReleaseGracePeriod = period
+	}
+}
+
+func WithControllerSweepInterval(interval time.Duration) Option {
+	return func(c *Config) {
+		c.Adapter.SweepInterval = interval
+	}
+}
+
+func WithCheckUpdates(enabled bool) Option {
+	return func(c *Config) {
+		c.Adapter.CheckUpdates = enabled
+	}
+}
+
+func WithControllerUpdateAckWait(wait time.Duration) Option {
+	return func(c *Config) {
+		c.Adapter.UpdateAckWait = wait
+	}
+}
+
+// WithControllerUpdateDebounce collects controller updates during window and sends them in one message,
+// an update waits no longer than maxLatency
+func WithControllerUpdateDebounce(window, maxLatency time.Duration) Option {
+	return func(c *Config) {
+		c.Adapter.UpdateDebounce = window
+		c.Adapter.UpdateMaxLatency = maxLatency
+	}
+}
+
+// WithAuthenticator requires START_SESSION to carry a token accepted by a, e.g. auth.NewHMACVerifier(secret)
+func WithAuthenticator(a auth.Authenticator) Option {
+	return func(c *Config) {
+		c.Session.Authenticator = a
+	}
+}
+
+// WithAuthorizer limits objects and fields sessions may read, e.g. with auth.ACLAuthorizer{}
+func WithAuthorizer(a auth.Authorizer) Option {
+	return func(c *Config) {
+		c.Adapter.Authorizer = a
+	}
+}
+
+// WithRedaction adds rules of values of objectType which never reach clients
+func WithRedaction(objectType string, rules ...adapter.RedactRule) Option {
+	return func(c *Config) {
+		if c.Adapter.Redaction == nil {
+			c.Adapter.Redaction = make(map[string][]adapter.RedactRule)
+		}
+
+		c.Adapter.Redaction[objectType] = append(c.Adapter.Redaction[objectType], rules...)
+	}
+}
+
+// WithCommandRateLimit limits commands of every session to rate per second with bursts up to burst commands
+func WithCommandRateLimit(rate float64, burst int) Option {
+	return func(c *Config) {
+		c.Session.CommandRate = rate
+		c.Session.CommandBurst = burst
+	}
+}
+
+// WithQuotas limits controllers per session, objects per controller and decorator calls per construct, zero means unlimited
+func WithQuotas(maxControllers, maxControllerObjects, maxDecoratorCalls int) Option {
+	return func(c *Config) {
+		c.Session.MaxControllers = maxControllers
+		c.Session.MaxControllerObjects = maxControllerObjects
+		c.Adapter.MaxDecoratorCalls = maxDecoratorCalls
+	}
+}
+
+// WithMaxIdHandlers sets max id handlers for all functions of the library
+func WithMaxIdHandlers(n int) Option {
+	return func(c *Config) {
+		c.Session.MaxIdHandlers = n
+		c.Adapter.MaxIdHandlers = n
+	}
+}
@@ -0,0 +1,69 @@
+package uilib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/foliagecp/ui-app-lib/adapter"
+	"github.com/foliagecp/ui-app-lib/auth"
+	"github.com/foliagecp/ui-app-lib/session"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewConfig_Defaults(t *testing.T) {
+	cfg := newConfig()
+
+	require.Equal(t, session.DefaultConfig(), cfg.Session)
+	require.Equal(t, adapter.DefaultConfig(), cfg.Adapter)
+}
+
+func TestNewConfig_Options(t *testing.T) {
+	verifier := auth.NewHMACVerifier([]byte("secret"))
+	authorizer := auth.ACLAuthorizer{}
+
+	cfg := newConfig(
+		WithSessionInactivityTimeout(15*time.Minute),
+		WithHeartbeat(10*time.Second, 5),
+		WithSessionClosingTimeout(time.Minute),
+		WithControllerReleaseGracePeriod(time.Second),
+		WithControllerSweepInterval(2*time.Second),
+		WithCheckUpdates(false),
+		WithControllerUpdateAckWait(5*time.Second),
+		WithControllerUpdateDebounce(100*time.Millisecond, 2*time.Second),
+		WithMaxIdHandlers(8),
+		WithAuthenticator(verifier),
+		WithAuthorizer(authorizer),
+		WithCommandRateLimit(10, 20),
+		WithQuotas(50, 1000, 10000),
+		WithRedaction("device", adapter.RedactRule{Path: "body.secret", Mode: adapter.RedactStrip}),
+	)
+
+	require.Equal(t, session.Config{
+		InactivityTimeout:    15 * time.Minute,
+		HeartbeatInterval:    10 * time.Second,
+		MaxMissedPongs:       5,
+		ClosingTimeout:       time.Minute,
+		MaxIdHandlers:        8,
+		Authenticator:        verifier,
+		CommandRate:          10,
+		CommandBurst:         20,
+		MaxControllers:       50,
+		MaxControllerObjects: 1000,
+	}, cfg.Session)
+
+	require.Equal(t, adapter.Config{
+		ReleaseGracePeriod: time.Second,
+		SweepInterval:      2 * time.Second,
+		CheckUpdates:       false,
+		MaxIdHandlers:      8,
+		UpdateAckWait:      5 * time.Second,
+		UpdateDebounce:     100 * time.Millisecond,
+		UpdateMaxLatency:   2 * time.Second,
+		Authorizer:         authorizer,
+		Redaction: map[string][]adapter.RedactRule{
+			"device": {{Path: "body.secret", Mode: adapter.RedactStrip}},
+		},
+		RedactMask:        "***",
+		MaxDecoratorCalls: 10000,
+	}, cfg.Adapter)
+}
@@ -2,9 +2,12 @@ package common
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/foliagecp/easyjson"
 	"github.com/foliagecp/sdk/clients/go/db"
 	"github.com/foliagecp/sdk/embedded/graph/crud"
+	"github.com/foliagecp/sdk/statefun/cache"
 	"github.com/foliagecp/sdk/statefun/plugins"
 )
 
@@ -40,3 +43,61 @@ func InLinkKeyPattern(id, target string, linkType ...string) string {
 		id, target,
 	)
 }
+
+// OutLinkTargets returns ids of all objects which the source object links to with the given link type
+func OutLinkTargets(store *cache.Store, source, ltype string) []string {
+	targets := make([]string, 0)
+
+	for _, key := range store.GetKeysByPattern(OutLinkType(source, ltype, ">")) {
+		split := strings.Split(key, ".")
+		if len(split) == 0 {
+			continue
+		}
+
+		targets = append(targets, split[len(split)-1])
+	}
+
+	return targets
+}
+
+// InLinkSources returns ids of all objects which link to the target object with the given link type
+func InLinkSources(store *cache.Store, target, ltype string) []string {
+	sources := make([]string, 0)
+
+	for _, key := range store.GetKeysByPattern(InLinkKeyPattern(target, ">")) {
+		split := strings.Split(key, ".")
+		if len(split) < 2 {
+			continue
+		}
+
+		// in link keys don't hold the link type, it's checked on the source side
+		source := split[len(split)-2]
+		if _, err := store.GetValue(OutLinkType(source, ltype, target)); err != nil {
+			continue
+		}
+
+		sources = append(sources, source)
+	}
+
+	return sources
+}
+
+// OutLinkBody returns body of the link from source to target with the given link type
+func OutLinkBody(store *cache.Store, source, ltype, target string) (*easyjson.JSON, error) {
+	name, err := store.GetValue(OutLinkType(source, ltype, target))
+	if err != nil {
+		return nil, err
+	}
+
+	return store.GetValueAsJSON(fmt.Sprintf(crud.OutLinkBodyKeyPrefPattern+crud.LinkKeySuff1Pattern, source, string(name)))
+}
+
+// CachedObjectType returns the type the object is linked to, it's read from the cache without requesting CMDB
+func CachedObjectType(store *cache.Store, id string) (string, bool) {
+	types := OutLinkTargets(store, id, crud.TO_TYPELINK)
+	if len(types) == 0 {
+		return "", false
+	}
+
+	return types[0], true
+}
@@ -4,16 +4,21 @@ import (
 	"crypto/md5"
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"strings"
 
 	"github.com/foliagecp/easyjson"
 	"github.com/foliagecp/sdk/clients/go/db"
 	sf "github.com/foliagecp/sdk/statefun/plugins"
 	inStatefun "github.com/foliagecp/ui-app-lib/internal/statefun"
+	"github.com/foliagecp/ui-app-lib/protocol"
 )
 
 const egressDelim = "="
 
+const requestIDKey = "request_id"
+
 func SendToSessionEgress(ctx *sf.StatefunContextProcessor, sessionID string, payload *easyjson.JSON) error {
 	cmdb, _ := db.NewCMDBSyncClientFromRequestFunction(ctx.Request)
 
@@ -24,12 +29,82 @@ func SendToSessionEgress(ctx *sf.StatefunContextProcessor, sessionID string, pay
 
 	clientID, ok := session.GetByPath("body.client_id").AsString()
 	if !ok {
-		return err
+		return fmt.Errorf("session %s has no client_id", sessionID)
+	}
+
+	return SendToClientEgress(ctx, clientID, payload)
+}
+
+// SendToClientEgress sends payload directly to the client, it's useful when the session object doesn't exist anymore
+func SendToClientEgress(ctx *sf.StatefunContextProcessor, clientID string, payload *easyjson.JSON) error {
+	if clientID == "" {
+		return fmt.Errorf("empty client_id")
+	}
+
+	if requestID := RequestID(ctx); requestID != "" && payload.PathExists("payload") {
+		payload.SetByPath("payload."+requestIDKey, easyjson.NewJSON(requestID))
 	}
 
 	return ctx.Signal(sf.JetstreamGlobalSignal, inStatefun.EGRESS, generateEgressID(clientID), payload, nil)
 }
 
+// RequestID returns id of the client request being processed, it's empty for unsolicited messages
+func RequestID(ctx *sf.StatefunContextProcessor) string {
+	if ctx.Options == nil {
+		return ""
+	}
+
+	return ctx.Options.GetByPath(requestIDKey).AsStringDefault("")
+}
+
+// RequestOptions returns signal options which carry the client request id to the next function
+func RequestOptions(ctx *sf.StatefunContextProcessor) *easyjson.JSON {
+	return WithRequestID(RequestID(ctx))
+}
+
+// WithRequestID returns signal options with the client request id, nil if the id is empty
+func WithRequestID(requestID string) *easyjson.JSON {
+	if requestID == "" {
+		return nil
+	}
+
+	return easyjson.NewJSONObjectWithKeyValue(requestIDKey, easyjson.NewJSON(requestID)).GetPtr()
+}
+
+// SendMessageToSession wraps the typed protocol message into the envelope and sends it to the session client
+func SendMessageToSession(ctx *sf.StatefunContextProcessor, sessionID string, msg any) error {
+	payload, err := envelope(msg)
+	if err != nil {
+		return err
+	}
+
+	return SendToSessionEgress(ctx, sessionID, payload)
+}
+
+// SendMessageToClient wraps the typed protocol message into the envelope and sends it directly to the client
+func SendMessageToClient(ctx *sf.StatefunContextProcessor, clientID string, msg any) error {
+	payload, err := envelope(msg)
+	if err != nil {
+		return err
+	}
+
+	return SendToClientEgress(ctx, clientID, payload)
+}
+
+func envelope(msg any) (*easyjson.JSON, error) {
+	data, err := json.Marshal(protocol.Envelope{Payload: msg})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %T: %w", msg, err)
+	}
+
+	payload, ok := easyjson.JSONFromBytes(data)
+	if !ok {
+		return nil, fmt.Errorf("failed to convert %T to json", msg)
+	}
+
+	return &payload, nil
+}
+
 func ClientIDFromEgressID(id string) string {
 	if len(id) == 0 {
 		return id
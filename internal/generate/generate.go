@@ -23,3 +23,7 @@ func UUID(id string) uuid.UUID {
 func SessionID(clientID string) uuid.UUID {
 	return UUID("session_client_" + clientID)
 }
+
+func UserID(user string) uuid.UUID {
+	return UUID("user_" + user)
+}
@@ -0,0 +1,166 @@
+// Package jsonpatch builds RFC 6902 patches between two JSON documents.
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/foliagecp/ui-app-lib/protocol"
+)
+
+const (
+	OpAdd     = "add"
+	OpRemove  = "remove"
+	OpReplace = "replace"
+)
+
+// Replace returns the patch which replaces the whole document
+func Replace(doc []byte) []protocol.PatchOperation {
+	return []protocol.PatchOperation{{Op: OpReplace, Path: "", Value: doc}}
+}
+
+/*
+Diff returns operations turning the old document into the new one. Objects are compared key by key,
+arrays element by element with added or removed tail, everything else is replaced.
+
+If the patch isn't shorter than the new document, the whole document is replaced instead.
+*/
+func Diff(oldDoc, newDoc []byte) ([]protocol.PatchOperation, error) {
+	oldValue, err := decode(oldDoc)
+	if err != nil {
+		return nil, fmt.Errorf("old document: %w", err)
+	}
+
+	newValue, err := decode(newDoc)
+	if err != nil {
+		return nil, fmt.Errorf("new document: %w", err)
+	}
+
+	ops := make([]protocol.PatchOperation, 0)
+	if err := diff("", oldValue, newValue, &ops); err != nil {
+		return nil, err
+	}
+
+	if len(ops) > 0 {
+		if data, err := json.Marshal(ops); err == nil && len(data) >= len(newDoc) {
+			return Replace(newDoc), nil
+		}
+	}
+
+	return ops, nil
+}
+
+func diff(path string, oldValue, newValue any, ops *[]protocol.PatchOperation) error {
+	switch o := oldValue.(type) {
+	case map[string]any:
+		if n, ok := newValue.(map[string]any); ok {
+			return diffObjects(path, o, n, ops)
+		}
+	case []any:
+		if n, ok := newValue.([]any); ok {
+			return diffArrays(path, o, n, ops)
+		}
+	}
+
+	if reflect.DeepEqual(oldValue, newValue) {
+		return nil
+	}
+
+	return appendOp(ops, OpReplace, path, newValue)
+}
+
+func diffObjects(path string, oldValue, newValue map[string]any, ops *[]protocol.PatchOperation) error {
+	for _, key := range sortedKeys(oldValue) {
+		child := path + "/" + escape(key)
+
+		n, ok := newValue[key]
+		if !ok {
+			*ops = append(*ops, protocol.PatchOperation{Op: OpRemove, Path: child})
+			continue
+		}
+
+		if err := diff(child, oldValue[key], n, ops); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range sortedKeys(newValue) {
+		if _, ok := oldValue[key]; ok {
+			continue
+		}
+
+		if err := appendOp(ops, OpAdd, path+"/"+escape(key), newValue[key]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func diffArrays(path string, oldValue, newValue []any, ops *[]protocol.PatchOperation) error {
+	common := min(len(oldValue), len(newValue))
+
+	for i := 0; i < common; i++ {
+		if err := diff(path+"/"+strconv.Itoa(i), oldValue[i], newValue[i], ops); err != nil {
+			return err
+		}
+	}
+
+	// removed from the end, so indexes of the remaining elements don't shift
+	for i := len(oldValue) - 1; i >= common; i-- {
+		*ops = append(*ops, protocol.PatchOperation{Op: OpRemove, Path: path + "/" + strconv.Itoa(i)})
+	}
+
+	for i := common; i < len(newValue); i++ {
+		if err := appendOp(ops, OpAdd, path+"/"+strconv.Itoa(i), newValue[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func appendOp(ops *[]protocol.PatchOperation, op, path string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	*ops = append(*ops, protocol.PatchOperation{Op: op, Path: path, Value: data})
+
+	return nil
+}
+
+// decode keeps numbers as they are written, so 1 and 1.0 are different values
+func decode(doc []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.UseNumber()
+
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// escape encodes the key as a JSON Pointer reference token
+func escape(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
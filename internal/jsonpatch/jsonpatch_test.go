@@ -0,0 +1,119 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/foliagecp/ui-app-lib/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	oldDoc := `{"name":"node","description":"` + strings.Repeat("x", 512) + `","status":{"cpu":10,"ram":20},"disks":["sda","sdb","sdc"],"a/b":1,"gone":true}`
+	newDoc := `{"name":"node","description":"` + strings.Repeat("x", 512) + `","status":{"cpu":15,"ram":20},"disks":["sda","sdd"],"a/b":2,"new":null}`
+
+	ops, err := Diff([]byte(oldDoc), []byte(newDoc))
+	require.NoError(t, err)
+
+	data, err := json.Marshal(ops)
+	require.NoError(t, err)
+
+	want := `[
+		{"op":"replace","path":"/a~1b","value":2},
+		{"op":"replace","path":"/disks/1","value":"sdd"},
+		{"op":"remove","path":"/disks/2"},
+		{"op":"remove","path":"/gone"},
+		{"op":"replace","path":"/status/cpu","value":15},
+		{"op":"add","path":"/new","value":null}
+	]`
+	require.JSONEq(t, want, string(data))
+
+	require.JSONEq(t, newDoc, apply(t, oldDoc, ops))
+}
+
+func TestDiff_Equal(t *testing.T) {
+	ops, err := Diff([]byte(`{"a":[1,{"b":"c"}]}`), []byte(`{"a":[1,{"b":"c"}]}`))
+	require.NoError(t, err)
+	require.Len(t, ops, 0)
+}
+
+func TestDiff_ReplaceWhenShorter(t *testing.T) {
+	ops, err := Diff([]byte(`{"a":1,"b":2,"c":3}`), []byte(`[]`))
+	require.NoError(t, err)
+	require.Equal(t, Replace([]byte(`[]`)), ops)
+}
+
+func TestDiff_InvalidDocument(t *testing.T) {
+	_, err := Diff([]byte(`{`), []byte(`{}`))
+	require.Error(t, err)
+}
+
+// apply is a minimal RFC 6902 applier for add, remove and replace, enough to check Diff output
+func apply(t *testing.T, doc string, ops []protocol.PatchOperation) string {
+	t.Helper()
+
+	var root any
+	require.NoError(t, json.Unmarshal([]byte(doc), &root))
+
+	for _, op := range ops {
+		var value any
+		if op.Op != OpRemove {
+			require.NoError(t, json.Unmarshal(op.Value, &value))
+		}
+
+		if op.Path == "" {
+			root = value
+			continue
+		}
+
+		tokens := strings.Split(op.Path, "/")[1:]
+		for i, token := range tokens {
+			tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		}
+
+		root = applyAt(t, root, tokens, op.Op, value)
+	}
+
+	data, err := json.Marshal(root)
+	require.NoError(t, err)
+
+	return string(data)
+}
+
+func applyAt(t *testing.T, node any, tokens []string, op string, value any) any {
+	token := tokens[0]
+	last := len(tokens) == 1
+
+	switch n := node.(type) {
+	case map[string]any:
+		switch {
+		case !last:
+			n[token] = applyAt(t, n[token], tokens[1:], op, value)
+		case op == OpRemove:
+			delete(n, token)
+		default:
+			n[token] = value
+		}
+		return n
+	case []any:
+		i, err := strconv.Atoi(token)
+		require.NoError(t, err)
+
+		switch {
+		case !last:
+			n[i] = applyAt(t, n[i], tokens[1:], op, value)
+		case op == OpRemove:
+			n = append(n[:i], n[i+1:]...)
+		case op == OpAdd:
+			n = append(n[:i], append([]any{value}, n[i:]...)...)
+		default:
+			n[i] = value
+		}
+		return n
+	}
+
+	t.Fatalf("path doesn't exist: %s", token)
+	return nil
+}
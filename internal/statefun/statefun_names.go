@@ -7,8 +7,14 @@ const (
 	SESSION_CLOSE            = "functions.ui.app.session.close"
 	SESSION_WATCH            = "functions.ui.app.session.watch"
 	SESSION_UPDATE_ACTIVITY  = "functions.ui.app.session.update.activity"
+	SESSION_PONG             = "functions.ui.app.session.pong"
+	SESSION_INFO             = "functions.ui.app.session.info"
 	SESSION_START_CONTROLLER = "functions.ui.app.session.controller.start"
 	SESSION_CLEAR_CONTROLLER = "functions.ui.app.session.controller.clear"
+	SESSION_RESYNC           = "functions.ui.app.session.resync"
+	SESSION_ACK              = "functions.ui.app.session.ack"
+	SESSION_BROADCAST        = "functions.ui.app.session.broadcast"
+	SESSION_DELIVER          = "functions.ui.app.session.deliver"
 	EGRESS                   = "ui"
 
 	CONTROLLER_START          = "functions.ui.app.controller.start"
@@ -17,6 +23,8 @@ const (
 	CONTROLLER_UPDATE         = "functions.ui.app.controller.update"
 	CONTROLLER_CONSTRUCT      = "functions.ui.app.controller.construct"
 	CONTROLLER_OBJECT_TRIGGER = "functions.ui.app.controller.object.trigger"
+	CONTROLLER_GC             = "functions.ui.app.controller.gc"
+	CONTROLLER_TYPE_WATCH     = "functions.ui.app.controller.type.watch"
 
 	TYPES_NAVIGATION_DECORATOR   = "functions.ui.app.decorator.types.navigation"
 	IO_LINK_TYPES_DECORATOR      = "functions.ui.app.decorator.types.link.io"
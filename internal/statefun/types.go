@@ -2,6 +2,7 @@ package statefun
 
 const (
 	SESSION_TYPE       = "ui_session"
+	USER_TYPE          = "ui_user"
 	CONTROLLER_TYPE    = "ui_controller"
 	SUBSCRIBER_TYPE    = "ui_subscriber"
 	SESSIONS_ENTYPOINT = "ui_sessions_entrypoint"
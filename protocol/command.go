@@ -0,0 +1,38 @@
+package protocol
+
+type Command string
+
+const (
+	START_SESSION    Command = "START_SESSION"
+	CLOSE_SESSION    Command = "CLOSE_SESSION"
+	START_CONTROLLER Command = "START_CONTROLLER"
+	CLEAR_CONTROLLER Command = "CLEAR_CONTROLLER"
+	PONG             Command = "PONG"
+	INFO             Command = "INFO"
+	RESYNC           Command = "RESYNC"
+	ACK              Command = "ACK"
+	BROADCAST        Command = "BROADCAST"
+)
+
+// sent by server
+const (
+	PING            Command = "PING"
+	CLOSING_SESSION Command = "CLOSING_SESSION"
+)
+
+// Commands returns all known commands
+func Commands() []Command {
+	return []Command{
+		START_SESSION,
+		CLOSE_SESSION,
+		START_CONTROLLER,
+		CLEAR_CONTROLLER,
+		PONG,
+		INFO,
+		RESYNC,
+		ACK,
+		BROADCAST,
+		PING,
+		CLOSING_SESSION,
+	}
+}
@@ -0,0 +1,43 @@
+package protocol
+
+// ErrorCode is a stable code of the error reply, frontends may switch on it
+type ErrorCode string
+
+const (
+	ERR_UNKNOWN_COMMAND      ErrorCode = "UNKNOWN_COMMAND"
+	ERR_INVALID_PAYLOAD      ErrorCode = "INVALID_PAYLOAD"
+	ERR_INTERNAL             ErrorCode = "INTERNAL"
+	ERR_UNSUPPORTED_VERSION  ErrorCode = "UNSUPPORTED_VERSION"
+	ERR_SESSION_NOT_FOUND    ErrorCode = "SESSION_NOT_FOUND"
+	ERR_SESSION_START        ErrorCode = "SESSION_START_FAILED"
+	ERR_SESSION_CLOSE        ErrorCode = "SESSION_CLOSE_FAILED"
+	ERR_CONTROLLER_NOT_FOUND ErrorCode = "CONTROLLER_NOT_FOUND"
+	ERR_CONTROLLER_START     ErrorCode = "CONTROLLER_START_FAILED"
+	ERR_CONTROLLER_CLEAR     ErrorCode = "CONTROLLER_CLEAR_FAILED"
+	ERR_INVALID_DECLARATION  ErrorCode = "INVALID_DECLARATION"
+	ERR_UNAUTHENTICATED      ErrorCode = "UNAUTHENTICATED"
+	ERR_FORBIDDEN            ErrorCode = "FORBIDDEN"
+	ERR_RATE_LIMITED         ErrorCode = "RATE_LIMITED"
+	ERR_QUOTA_EXCEEDED       ErrorCode = "QUOTA_EXCEEDED"
+)
+
+// ErrorCodes returns all known error codes
+func ErrorCodes() []ErrorCode {
+	return []ErrorCode{
+		ERR_UNKNOWN_COMMAND,
+		ERR_INVALID_PAYLOAD,
+		ERR_INTERNAL,
+		ERR_UNSUPPORTED_VERSION,
+		ERR_SESSION_NOT_FOUND,
+		ERR_SESSION_START,
+		ERR_SESSION_CLOSE,
+		ERR_CONTROLLER_NOT_FOUND,
+		ERR_CONTROLLER_START,
+		ERR_CONTROLLER_CLEAR,
+		ERR_INVALID_DECLARATION,
+		ERR_UNAUTHENTICATED,
+		ERR_FORBIDDEN,
+		ERR_RATE_LIMITED,
+		ERR_QUOTA_EXCEEDED,
+	}
+}
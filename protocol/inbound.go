@@ -0,0 +1,98 @@
+package protocol
+
+import "encoding/json"
+
+// Request is the common part of every inbound command
+type Request struct {
+	Command Command `json:"command"`
+	// RequestID is echoed back in every reply to the command
+	RequestID string `json:"request_id,omitempty"`
+	// SessionToken is issued in START_SESSION reply, other commands must carry it if the server authenticates clients
+	SessionToken string `json:"session_token,omitempty"`
+}
+
+type StartSession struct {
+	Request
+	// Version is the protocol version wanted by the client, DefaultVersion is used if empty
+	Version int `json:"version,omitempty"`
+	// Encoding of controller updates, EncodingFull if empty
+	Encoding Encoding `json:"encoding,omitempty"`
+	// Token proves the client identity, required if the server has an authenticator
+	Token string `json:"token,omitempty"`
+	// User groups sessions of the same user, e.g. browser tabs. It's ignored if the server authenticates clients,
+	// the verified subject is the user then, or if the server doesn't trust claimed users.
+	User string `json:"user,omitempty"`
+}
+
+type CloseSession struct {
+	Request
+}
+
+type Info struct {
+	Request
+}
+
+type Pong struct {
+	Request
+}
+
+type ClearController struct {
+	Request
+	Plugin string `json:"plugin"`
+	// Name of the controller, all plugin's controllers are cleared if empty
+	Name string `json:"name,omitempty"`
+}
+
+// Resync asks for full results of the controller objects, e.g. after a gap in patch sequence numbers
+type Resync struct {
+	Request
+	Plugin string `json:"plugin"`
+	// Name of the controller, all plugin's controllers are resent if empty
+	Name string `json:"name,omitempty"`
+}
+
+// Ack confirms the results the client holds, patches of the controller objects start from them then
+type Ack struct {
+	Request
+	Plugin string `json:"plugin"`
+	Name   string `json:"name"`
+	// Seq maps object ids to the sequence numbers of their applied results
+	Seq map[string]uint64 `json:"seq"`
+}
+
+// Broadcast sends the message to all sessions of the user, e.g. to share a saved layout between tabs
+type Broadcast struct {
+	Request
+	Message json.RawMessage `json:"message"`
+	// IncludeSelf delivers the message to the sending session too
+	IncludeSelf bool `json:"include_self,omitempty"`
+}
+
+// StartController is sent without command: plugin -> controller name -> controller
+type StartController map[string]map[string]Controller
+
+type Controller struct {
+	Body  Declaration `json:"body"`
+	UUIDs []string    `json:"uuids"`
+	// DebounceMs collects the controller updates during the window and sends them in one message.
+	// Sessions share the controller only if they ask for the same window.
+	DebounceMs *int `json:"debounce_ms,omitempty"`
+	// Fields are top level result keys sent to this session, all if empty. Sessions share the controller
+	// with the same declaration whatever their fields are.
+	Fields []string `json:"fields,omitempty"`
+}
+
+/*
+Declaration maps result keys to decorators or nested declarations:
+
+	{
+		"name": "@property:name",
+		"disks": {
+			"@each": "@function:getChildrenUUIDSByLinkType(node_disk)",
+			"fields": {
+				"name": "@property:name"
+			}
+		}
+	}
+*/
+type Declaration map[string]any
@@ -0,0 +1,213 @@
+package protocol
+
+import "encoding/json"
+
+// Envelope wraps every message sent to the client
+type Envelope struct {
+	Payload any `json:"payload"`
+}
+
+type Status string
+
+const (
+	StatusOK    Status = "ok"
+	StatusError Status = "error"
+)
+
+// Reply is the answer to an inbound command, Code and Message are set for errors
+type Reply struct {
+	Command   Command   `json:"command"`
+	Status    Status    `json:"status"`
+	Code      ErrorCode `json:"code,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+func OK(command Command) Reply {
+	return Reply{Command: command, Status: StatusOK}
+}
+
+func Error(command Command, code ErrorCode, message string) Reply {
+	return Reply{Command: command, Status: StatusError, Code: code, Message: message}
+}
+
+type StartSessionReply struct {
+	Reply
+	// Version is the negotiated protocol version
+	Version int `json:"version,omitempty"`
+	// Encoding of controller updates used by the session
+	Encoding Encoding `json:"encoding,omitempty"`
+	// Subject of the verified token, empty if the server doesn't authenticate clients
+	Subject string `json:"subject,omitempty"`
+	// User the session belongs to, empty if the session isn't grouped with others
+	User string `json:"user,omitempty"`
+	// SessionToken must be sent with every other command, empty if the server doesn't authenticate clients
+	SessionToken string `json:"session_token,omitempty"`
+}
+
+type CloseSessionReply struct {
+	Reply
+	// Controllers detached from the session: plugin -> controller names
+	Controllers map[string][]string `json:"controllers"`
+}
+
+type ControllerReply struct {
+	Reply
+	Plugin string `json:"plugin,omitempty"`
+	Name   string `json:"name,omitempty"`
+}
+
+type ClearControllerReply struct {
+	Reply
+	Plugin string `json:"plugin"`
+	// Controllers names which were cleared
+	Controllers []string `json:"controllers"`
+}
+
+type InfoReply struct {
+	Reply
+	ClientID string `json:"client_id"`
+	// CreationTime in unix seconds
+	CreationTime int64 `json:"creation_time"`
+	// LastActivityTime in unix seconds
+	LastActivityTime  int64  `json:"last_activity_time"`
+	InactivityTimeout string `json:"inactivity_timeout"`
+	// LifeTime is seconds since creation
+	LifeTime    int64            `json:"life_time"`
+	Controllers []ControllerInfo `json:"controllers"`
+	User        string           `json:"user,omitempty"`
+	// UserSessions is how many sessions the user has including this one
+	UserSessions int `json:"user_sessions,omitempty"`
+}
+
+type ControllerInfo struct {
+	ID     string `json:"id"`
+	Plugin string `json:"plugin"`
+	Name   string `json:"name"`
+	// Objects is count of objects the controller is started on
+	Objects int `json:"objects"`
+}
+
+type Ping struct {
+	Command   Command `json:"command"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+type ClosingSession struct {
+	Command Command `json:"command"`
+	Reason  string  `json:"reason"`
+	Timeout string  `json:"timeout"`
+}
+
+// ControllerUpdate is pushed to subscribers when controller result changes
+type ControllerUpdate struct {
+	// Plugins: plugin -> object id -> controller result
+	Plugins map[string]map[string]json.RawMessage `json:"plugins"`
+	// Denied: plugin -> object id -> result keys the session isn't allowed to read, they are omitted from the result
+	Denied map[string]map[string][]string `json:"denied,omitempty"`
+	// Unsolicited is always true, the update isn't an answer to any request
+	Unsolicited bool `json:"unsolicited"`
+}
+
+// ControllerPatch is pushed instead of ControllerUpdate to sessions started with EncodingPatch
+type ControllerPatch struct {
+	// Plugins: plugin -> object id -> patch of controller result
+	Plugins map[string]map[string]ObjectPatch `json:"plugins"`
+	// Denied: plugin -> object id -> result keys the session isn't allowed to read, they are omitted from the result
+	Denied map[string]map[string][]string `json:"denied,omitempty"`
+	// Encoding is always EncodingPatch
+	Encoding Encoding `json:"encoding"`
+	// Unsolicited is always true, the update isn't an answer to any request
+	Unsolicited bool `json:"unsolicited"`
+}
+
+// ObjectPatch turns the controller result with sequence number BaseSeq into the result with Seq.
+// The first patch of an object has zero BaseSeq and replaces the whole document.
+type ObjectPatch struct {
+	BaseSeq uint64           `json:"base_seq"`
+	Seq     uint64           `json:"seq"`
+	Patch   []PatchOperation `json:"patch"`
+}
+
+// PatchOperation is an RFC 6902 operation, only "add", "remove" and "replace" are sent
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// ResyncReply carries full results of a controller, one reply is sent for every controller matched by Resync
+type ResyncReply struct {
+	Reply
+	Plugin string `json:"plugin"`
+	Name   string `json:"name"`
+	// Objects: object id -> controller result
+	Objects map[string]ObjectSnapshot `json:"objects"`
+}
+
+const EventSnapshot = "snapshot"
+
+// ControllerSnapshot is sent only to the session which has just subscribed to the controller,
+// it carries current results of all controller objects, so the session doesn't wait for their next change
+type ControllerSnapshot struct {
+	// Event is always EventSnapshot
+	Event  string `json:"event"`
+	Plugin string `json:"plugin"`
+	Name   string `json:"name"`
+	// Objects: object id -> controller result, objects which aren't constructed yet come with ControllerUpdate later
+	Objects map[string]ObjectSnapshot `json:"objects"`
+	// RequestID of START_CONTROLLER which has subscribed the session
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type ObjectSnapshot struct {
+	// Seq of the result, patches with greater BaseSeq are applied on top of it
+	Seq    uint64          `json:"seq"`
+	Result json.RawMessage `json:"result"`
+	// Denied result keys, see ControllerUpdate.Denied
+	Denied []string `json:"denied,omitempty"`
+}
+
+const EventRemoved = "removed"
+
+// ObjectRemoved is pushed to subscribers when an object the controller is started on has been deleted
+type ObjectRemoved struct {
+	// Event is always EventRemoved
+	Event    string `json:"event"`
+	Plugin   string `json:"plugin"`
+	ObjectID string `json:"object_id"`
+	// Unsolicited is always true, the event isn't an answer to any request
+	Unsolicited bool `json:"unsolicited"`
+}
+
+const EventError = "error"
+
+// ObjectError is pushed to subscribers when the controller result of an object can't be built,
+// the last sent result stays valid
+type ObjectError struct {
+	// Event is always EventError
+	Event    string    `json:"event"`
+	Plugin   string    `json:"plugin"`
+	ObjectID string    `json:"object_id"`
+	Code     ErrorCode `json:"code"`
+	Message  string    `json:"message"`
+	// Unsolicited is always true, the event isn't an answer to any request
+	Unsolicited bool `json:"unsolicited"`
+}
+
+type BroadcastReply struct {
+	Reply
+	// Sessions is how many sessions the message is sent to
+	Sessions int `json:"sessions"`
+}
+
+const EventBroadcast = "broadcast"
+
+// BroadcastMessage is pushed to sessions of the user when one of them sends BROADCAST
+type BroadcastMessage struct {
+	// Event is always EventBroadcast
+	Event   string          `json:"event"`
+	Message json.RawMessage `json:"message"`
+	// Unsolicited is always true, the message isn't an answer to any request of the session
+	Unsolicited bool `json:"unsolicited"`
+}
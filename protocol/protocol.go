@@ -0,0 +1,71 @@
+// Package protocol describes messages exchanged between UI clients and ui-app-lib functions.
+//
+// Inbound commands are sent to ui.ingress.<client_id>, outbound messages are received from ui.egress.<client_id>,
+// every message is wrapped into Envelope. JSON Schema of all messages is kept in schema.json.
+package protocol
+
+//go:generate go run ../cmd/protocol-schema -o schema.json
+
+const (
+	// Version is the newest protocol version served
+	Version = 2
+	// MinVersion is the oldest protocol version still served
+	MinVersion = 1
+	// DefaultVersion is used by clients which don't ask for any, they were written before versions were negotiated
+	DefaultVersion = 1
+)
+
+// protocol versions which change the behaviour for clients
+const (
+	// VersionControllerReplies answers START_CONTROLLER once for every controller of the command with its plugin and name,
+	// older versions get one reply for the whole command
+	VersionControllerReplies = 2
+)
+
+// Negotiate picks the protocol version for the session from the version requested by the client.
+// Zero means the client didn't ask for any, so DefaultVersion is used.
+func Negotiate(requested int) (int, bool) {
+	if requested == 0 {
+		return DefaultVersion, true
+	}
+
+	if requested > Version {
+		return Version, true
+	}
+
+	if requested < MinVersion {
+		return 0, false
+	}
+
+	return requested, true
+}
+
+// Encoding is the way controller updates are sent to the session
+type Encoding string
+
+const (
+	// EncodingFull sends the whole controller result on every change
+	EncodingFull Encoding = "full"
+	// EncodingPatch sends RFC 6902 patches relative to the previous result
+	EncodingPatch Encoding = "patch"
+)
+
+// Encodings returns all supported encodings
+func Encodings() []Encoding {
+	return []Encoding{EncodingFull, EncodingPatch}
+}
+
+// NegotiateEncoding picks the encoding for the session, empty means EncodingFull
+func NegotiateEncoding(requested Encoding) (Encoding, bool) {
+	if requested == "" {
+		return EncodingFull, true
+	}
+
+	for _, e := range Encodings() {
+		if e == requested {
+			return e, true
+		}
+	}
+
+	return "", false
+}
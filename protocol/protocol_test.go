@@ -0,0 +1,90 @@
+package protocol
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	version, ok := Negotiate(0)
+	require.True(t, ok)
+	require.Equal(t, DefaultVersion, version)
+
+	version, ok = Negotiate(Version + 1)
+	require.True(t, ok)
+	require.Equal(t, Version, version)
+
+	version, ok = Negotiate(MinVersion)
+	require.True(t, ok)
+	require.Equal(t, MinVersion, version)
+
+	_, ok = Negotiate(-1)
+	require.False(t, ok)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	encoding, ok := NegotiateEncoding("")
+	require.True(t, ok)
+	require.Equal(t, EncodingFull, encoding)
+
+	encoding, ok = NegotiateEncoding(EncodingPatch)
+	require.True(t, ok)
+	require.Equal(t, EncodingPatch, encoding)
+
+	_, ok = NegotiateEncoding("gzip")
+	require.False(t, ok)
+}
+
+func TestReply_JSON(t *testing.T) {
+	data, err := json.Marshal(Envelope{Payload: ClearControllerReply{
+		Reply:       Error(CLEAR_CONTROLLER, ERR_CONTROLLER_NOT_FOUND, "controller not found"),
+		Plugin:      "viewer",
+		Controllers: []string{},
+	}})
+	require.NoError(t, err)
+
+	want := `{"payload":{"command":"CLEAR_CONTROLLER","status":"error","code":"CONTROLLER_NOT_FOUND","message":"controller not found","plugin":"viewer","controllers":[]}}`
+	require.JSONEq(t, want, string(data))
+}
+
+func TestSchema(t *testing.T) {
+	schema := Schema()
+	defs := schema["$defs"].(map[string]any)
+
+	for name := range Inbound {
+		require.Contains(t, defs, name)
+	}
+
+	for name := range Outbound {
+		require.Contains(t, defs, name)
+	}
+
+	command := defs["Command"].(map[string]any)
+	require.Len(t, command["enum"], len(Commands()))
+
+	clear := defs["ClearController"].(map[string]any)
+	properties := clear["properties"].(map[string]any)
+
+	// embedded Request is flattened
+	require.Contains(t, properties, "command")
+	require.Contains(t, properties, "request_id")
+	require.Equal(t, map[string]any{"$ref": "#/$defs/Command"}, properties["command"])
+	require.ElementsMatch(t, []string{"command", "plugin"}, clear["required"])
+}
+
+func TestSchema_UpToDate(t *testing.T) {
+	data, err := json.MarshalIndent(Schema(), "", "  ")
+	require.NoError(t, err)
+
+	var got, want any
+	require.NoError(t, json.Unmarshal(data, &got))
+
+	file, err := os.ReadFile("schema.json")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(file, &want))
+
+	require.Equal(t, want, got, "schema.json is outdated, run go generate ./protocol")
+}
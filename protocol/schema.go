@@ -0,0 +1,216 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Inbound lists commands sent by the client, used by schema generation
+var Inbound = map[string]any{
+	"StartSession":    StartSession{},
+	"CloseSession":    CloseSession{},
+	"StartController": StartController{},
+	"ClearController": ClearController{},
+	"Info":            Info{},
+	"Pong":            Pong{},
+	"Resync":          Resync{},
+	"Ack":             Ack{},
+	"Broadcast":       Broadcast{},
+}
+
+// Outbound lists messages sent to the client, used by schema generation
+var Outbound = map[string]any{
+	"Envelope":             Envelope{},
+	"Reply":                Reply{},
+	"StartSessionReply":    StartSessionReply{},
+	"CloseSessionReply":    CloseSessionReply{},
+	"ControllerReply":      ControllerReply{},
+	"ClearControllerReply": ClearControllerReply{},
+	"InfoReply":            InfoReply{},
+	"Ping":                 Ping{},
+	"ClosingSession":       ClosingSession{},
+	"ControllerUpdate":     ControllerUpdate{},
+	"ObjectRemoved":        ObjectRemoved{},
+	"ObjectError":          ObjectError{},
+	"BroadcastReply":       BroadcastReply{},
+	"BroadcastMessage":     BroadcastMessage{},
+	"ControllerPatch":      ControllerPatch{},
+	"ResyncReply":          ResyncReply{},
+	"ControllerSnapshot":   ControllerSnapshot{},
+}
+
+// enums are named string types with a closed set of values
+var enums = map[reflect.Type]func() []string{
+	reflect.TypeOf(Command("")): func() []string {
+		return toStrings(Commands())
+	},
+	reflect.TypeOf(ErrorCode("")): func() []string {
+		return toStrings(ErrorCodes())
+	},
+	reflect.TypeOf(Encoding("")): func() []string {
+		return toStrings(Encodings())
+	},
+	reflect.TypeOf(Status("")): func() []string {
+		return []string{string(StatusOK), string(StatusError)}
+	},
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+/*
+Schema returns JSON Schema of all protocol messages:
+
+	{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title": "ui-app-lib protocol",
+		"version": 1,
+		"$defs": {
+			"StartSession": {...},
+			...
+		}
+	}
+*/
+func Schema() map[string]any {
+	g := &schemaGenerator{defs: make(map[string]any)}
+
+	for name, msg := range Inbound {
+		g.define(name, reflect.TypeOf(msg))
+	}
+
+	for name, msg := range Outbound {
+		g.define(name, reflect.TypeOf(msg))
+	}
+
+	return map[string]any{
+		"$schema": schemaDialect,
+		"title":   "ui-app-lib protocol",
+		"version": Version,
+		"$defs":   g.defs,
+	}
+}
+
+type schemaGenerator struct {
+	defs map[string]any
+}
+
+var packagePath = reflect.TypeOf(Envelope{}).PkgPath()
+
+func (g *schemaGenerator) define(name string, t reflect.Type) {
+	if _, ok := g.defs[name]; ok {
+		return
+	}
+
+	// reserve the name before walking, types may refer to themselves
+	g.defs[name] = nil
+
+	if values, ok := enums[t]; ok {
+		g.defs[name] = map[string]any{"type": "string", "enum": values()}
+		return
+	}
+
+	if t.Kind() == reflect.Struct {
+		g.defs[name] = g.object(t)
+		return
+	}
+
+	g.defs[name] = g.schema(t)
+}
+
+// schema describes t, enums and structs of this package are referenced from $defs
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+	if t == rawMessageType {
+		return map[string]any{}
+	}
+
+	_, enum := enums[t]
+	if enum || (t.Kind() == reflect.Struct && t.Name() != "" && t.PkgPath() == packagePath) {
+		g.define(t.Name(), t)
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		return g.object(t)
+	default:
+		// interfaces accept anything
+		return map[string]any{}
+	}
+}
+
+func (g *schemaGenerator) object(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	required := make([]string, 0)
+
+	g.fields(t, properties, &required)
+
+	out := map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+
+	if len(required) > 0 {
+		out["required"] = required
+	}
+
+	return out
+}
+
+// fields collects struct fields the same way encoding/json does, embedded structs are flattened
+func (g *schemaGenerator) fields(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			g.fields(f.Type, properties, required)
+			continue
+		}
+
+		if !f.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		properties[name] = g.schema(f.Type)
+
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+func toStrings[T ~string](values []T) []string {
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		out = append(out, string(v))
+	}
+	return out
+}
@@ -0,0 +1,138 @@
+package session
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+
+	"github.com/foliagecp/easyjson"
+	sf "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/ui-app-lib/auth"
+)
+
+/*
+Verified principal is stored in the session body:
+
+	"principal": {
+		"subject": "user",
+		"claims": {...}
+	}
+*/
+const _SESSION_PRINCIPAL = "principal"
+
+/*
+The session id is derived from the client id, so it doesn't prove anything. Authenticated sessions get a random
+token on START_SESSION, every other command must carry it. The session lives until the verified token expires:
+
+	"session_token": "...",
+	"expires_at": 1695292826 // unix seconds, "exp" claim of the token, absent if the token doesn't expire
+*/
+const (
+	_SESSION_TOKEN      = "session_token"
+	_SESSION_EXPIRES_AT = "expires_at"
+)
+
+// authenticate verifies the START_SESSION token, the principal is nil if clients aren't authenticated
+func authenticate(token string) (*auth.Principal, error) {
+	if config.Authenticator == nil {
+		return nil, nil
+	}
+
+	principal, err := config.Authenticator.Authenticate(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &principal, nil
+}
+
+// verifySession checks that the command comes from the client which has started the session and the session
+// hasn't expired, any command is allowed if clients aren't authenticated
+func verifySession(params *easyjson.JSON, token string, now int64) error {
+	if config.Authenticator == nil {
+		return nil
+	}
+
+	if sessionSubject(params) == "" {
+		return errors.New("session isn't authenticated")
+	}
+
+	want := params.GetByPath(_SESSION_TOKEN).AsStringDefault("")
+	if want == "" || subtle.ConstantTimeCompare([]byte(token), []byte(want)) != 1 {
+		return errors.New("invalid session token")
+	}
+
+	if sessionExpired(params, now) {
+		return errors.New("session has expired")
+	}
+
+	return nil
+}
+
+// sessionExpired reports whether the token the session is started with has expired
+func sessionExpired(params *easyjson.JSON, now int64) bool {
+	expiresAt := int64(params.GetByPath(_SESSION_EXPIRES_AT).AsNumericDefault(0))
+	return expiresAt > 0 && expiresAt <= now
+}
+
+// principalExpiresAt returns "exp" claim of the principal, zero if the token doesn't expire
+func principalExpiresAt(principal auth.Principal) int64 {
+	return int64(principalJSON(principal).GetByPath("claims.exp").AsNumericDefault(0))
+}
+
+// renewSession extends the session till expiration of the fresh token
+func renewSession(ctx *sf.StatefunContextProcessor, principal auth.Principal) error {
+	if err := ctx.ObjectMutexLock(ctx.Self.ID, false); err != nil {
+		return err
+	}
+	defer ctx.ObjectMutexUnlock(ctx.Self.ID)
+
+	params := ctx.GetObjectContext()
+	if !params.IsNonEmptyObject() {
+		return nil
+	}
+
+	params.SetByPath(_SESSION_PRINCIPAL, principalJSON(principal))
+
+	if expiresAt := principalExpiresAt(principal); expiresAt > 0 {
+		params.SetByPath(_SESSION_EXPIRES_AT, easyjson.NewJSON(expiresAt))
+	} else {
+		params.RemoveByPath(_SESSION_EXPIRES_AT)
+	}
+
+	ctx.SetObjectContext(params)
+
+	scheduleSession(ctx.Self.ID, params)
+
+	return nil
+}
+
+// sessionTokenOption returns the session token sent with the command, Ingress moves it to signal options
+func sessionTokenOption(options *easyjson.JSON) string {
+	if options == nil {
+		return ""
+	}
+
+	return options.GetByPath(_SESSION_TOKEN).AsStringDefault("")
+}
+
+func newSessionToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(token), nil
+}
+
+func sessionSubject(params *easyjson.JSON) string {
+	return params.GetByPath(_SESSION_PRINCIPAL + ".subject").AsStringDefault("")
+}
+
+func principalJSON(principal auth.Principal) easyjson.JSON {
+	data, _ := json.Marshal(principal)
+	out, _ := easyjson.JSONFromBytes(data)
+	return out
+}
@@ -0,0 +1,76 @@
+package session
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/foliagecp/ui-app-lib/auth"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticate_Disabled(t *testing.T) {
+	principal, err := authenticate("")
+	require.NoError(t, err)
+	require.Nil(t, principal)
+
+	require.NoError(t, verifySession(easyjson.NewJSONObject().GetPtr(), "", 0))
+}
+
+func TestAuthenticate(t *testing.T) {
+	prev := config
+	t.Cleanup(func() { config = prev })
+
+	config.Authenticator = auth.AuthenticatorFunc(func(token string) (auth.Principal, error) {
+		if token != "good" {
+			return auth.Principal{}, auth.ErrInvalidToken
+		}
+		return auth.Principal{Subject: "alice", Claims: map[string]any{"role": "admin"}}, nil
+	})
+
+	_, err := authenticate("bad")
+	require.True(t, errors.Is(err, auth.ErrInvalidToken))
+
+	principal, err := authenticate("good")
+	require.NoError(t, err)
+
+	params := easyjson.NewJSONObject()
+	require.Error(t, verifySession(&params, "", 0))
+
+	params.SetByPath(_SESSION_PRINCIPAL, principalJSON(*principal))
+	params.SetByPath(_SESSION_TOKEN, easyjson.NewJSON("session"))
+	require.NoError(t, verifySession(&params, "session", 0))
+	require.Equal(t, "alice", sessionSubject(&params))
+	require.Equal(t, "admin", params.GetByPath(_SESSION_PRINCIPAL+".claims.role").AsStringDefault(""))
+}
+
+func TestVerifySession(t *testing.T) {
+	prev := config
+	t.Cleanup(func() { config = prev })
+
+	config.Authenticator = auth.AuthenticatorFunc(func(token string) (auth.Principal, error) {
+		return auth.Principal{Subject: token, Claims: map[string]any{"exp": 100}}, nil
+	})
+
+	principal, err := authenticate("alice")
+	require.NoError(t, err)
+	require.Equal(t, int64(100), principalExpiresAt(*principal))
+
+	token, err := newSessionToken()
+	require.NoError(t, err)
+
+	params := easyjson.NewJSONObject()
+	params.SetByPath(_SESSION_PRINCIPAL, principalJSON(*principal))
+	params.SetByPath(_SESSION_TOKEN, easyjson.NewJSON(token))
+	params.SetByPath(_SESSION_EXPIRES_AT, easyjson.NewJSON(principalExpiresAt(*principal)))
+
+	require.NoError(t, verifySession(&params, token, 99))
+
+	// the session id is known to anyone who knows the client id, the token isn't
+	require.Error(t, verifySession(&params, "", 99))
+	require.Error(t, verifySession(&params, token+"0", 99))
+
+	require.False(t, sessionExpired(&params, 99))
+	require.True(t, sessionExpired(&params, 100))
+	require.Error(t, verifySession(&params, token, 100))
+}
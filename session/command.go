@@ -1,10 +1,23 @@
 package session
 
-type Command string
+import "github.com/foliagecp/ui-app-lib/protocol"
 
+type Command = protocol.Command
+
+const (
+	START_SESSION    = protocol.START_SESSION
+	CLOSE_SESSION    = protocol.CLOSE_SESSION
+	START_CONTROLLER = protocol.START_CONTROLLER
+	CLEAR_CONTROLLER = protocol.CLEAR_CONTROLLER
+	PONG             = protocol.PONG
+	INFO             = protocol.INFO
+	RESYNC           = protocol.RESYNC
+	ACK              = protocol.ACK
+	BROADCAST        = protocol.BROADCAST
+)
+
+// sent by server
 const (
-	START_SESSION    Command = "START_SESSION"
-	CLOSE_SESSION    Command = "CLOSE_SESSION"
-	START_CONTROLLER Command = "START_CONTROLLER"
-	CLEAR_CONTROLLER Command = "CLEAR_CONTROLLER"
+	PING            = protocol.PING
+	CLOSING_SESSION = protocol.CLOSING_SESSION
 )
@@ -0,0 +1,78 @@
+package session
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/foliagecp/ui-app-lib/auth"
+)
+
+// Config holds session functions settings, use DefaultConfig as a base
+type Config struct {
+	// InactivityTimeout is how long the session lives without client commands
+	InactivityTimeout time.Duration
+	// HeartbeatInterval is how often PING is sent to the client, zero disables the heartbeat
+	HeartbeatInterval time.Duration
+	// MaxMissedPongs is how many PINGs in a row may stay unanswered before the session is declared dead
+	MaxMissedPongs int
+	// ClosingTimeout is how long the client has to answer CLOSING_SESSION warning
+	ClosingTimeout time.Duration
+	// MaxIdHandlers is passed to every session function type config, -1 means unlimited
+	MaxIdHandlers int
+	// Authenticator verifies START_SESSION tokens, commands of sessions without a verified principal are rejected.
+	// Clients aren't authenticated if it's nil.
+	Authenticator auth.Authenticator
+	// GroupClaimedUsers groups sessions by the user claimed on START_SESSION if Authenticator is nil.
+	// The claim isn't verified, any client may join sessions of another user, so enable it only for trusted clients.
+	GroupClaimedUsers bool
+	// CommandRate is how many commands per second a session may send, zero means unlimited. PONG isn't limited.
+	CommandRate float64
+	// CommandBurst is how many commands a session may send at once
+	CommandBurst int
+	// MaxControllers is how many controllers a session may have, zero means unlimited
+	MaxControllers int
+	// MaxControllerObjects is how many uuids a controller may list, zero means unlimited
+	MaxControllerObjects int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		InactivityTimeout: SessionInactivityTimeout,
+		HeartbeatInterval: 0,
+		MaxMissedPongs:    MaxMissedPongs,
+		ClosingTimeout:    SessionClosingTimeout,
+		MaxIdHandlers:     -1,
+	}
+}
+
+// config is set once by RegisterFunctions before the runtime starts
+var config = DefaultConfig()
+
+// validated replaces values which would break the runtime with defaults, e.g. a zero InactivityTimeout makes
+// the scheduler wake up all the time. Negative values of settings where zero means "disabled" or "unlimited" become zero.
+func (c Config) validated() Config {
+	def := DefaultConfig()
+
+	if c.InactivityTimeout <= 0 {
+		slog.Warn("invalid session config, default is used", "field", "InactivityTimeout", "value", c.InactivityTimeout.String())
+		c.InactivityTimeout = def.InactivityTimeout
+	}
+
+	if c.ClosingTimeout <= 0 {
+		slog.Warn("invalid session config, default is used", "field", "ClosingTimeout", "value", c.ClosingTimeout.String())
+		c.ClosingTimeout = def.ClosingTimeout
+	}
+
+	if c.MaxMissedPongs <= 0 {
+		slog.Warn("invalid session config, default is used", "field", "MaxMissedPongs", "value", c.MaxMissedPongs)
+		c.MaxMissedPongs = def.MaxMissedPongs
+	}
+
+	c.HeartbeatInterval = max(c.HeartbeatInterval, 0)
+	c.CommandRate = max(c.CommandRate, 0)
+	c.CommandBurst = max(c.CommandBurst, 0)
+	c.MaxControllers = max(c.MaxControllers, 0)
+	c.MaxControllerObjects = max(c.MaxControllerObjects, 0)
+
+	return c
+}
@@ -0,0 +1,33 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_Validated(t *testing.T) {
+	cfg := Config{
+		InactivityTimeout:    0,
+		HeartbeatInterval:    -time.Second,
+		ClosingTimeout:       0,
+		MaxMissedPongs:       0,
+		CommandRate:          -1,
+		CommandBurst:         -1,
+		MaxControllers:       -1,
+		MaxControllerObjects: -1,
+	}.validated()
+
+	require.Equal(t, SessionInactivityTimeout, cfg.InactivityTimeout)
+	require.Zero(t, cfg.HeartbeatInterval)
+	require.Equal(t, SessionClosingTimeout, cfg.ClosingTimeout)
+	require.Equal(t, MaxMissedPongs, cfg.MaxMissedPongs)
+	require.Zero(t, cfg.CommandRate)
+	require.Zero(t, cfg.CommandBurst)
+	require.Zero(t, cfg.MaxControllers)
+	require.Zero(t, cfg.MaxControllerObjects)
+
+	// valid values are kept
+	require.Equal(t, DefaultConfig(), DefaultConfig().validated())
+}
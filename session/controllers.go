@@ -0,0 +1,138 @@
+package session
+
+import (
+	"log/slog"
+	"strconv"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/foliagecp/sdk/clients/go/db"
+	sf "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/ui-app-lib/internal/common"
+	"github.com/foliagecp/ui-app-lib/internal/generate"
+	inStatefun "github.com/foliagecp/ui-app-lib/internal/statefun"
+	"github.com/foliagecp/ui-app-lib/protocol"
+	"github.com/google/uuid"
+)
+
+type controllerFilter func(plugin, name string) bool
+
+// controllerID identifies the controller shared by sessions of the same principal. The debounce window applies to all
+// subscribers of the controller, so sessions asking for different windows get different controllers.
+func controllerID(plugin, name string, declaration easyjson.JSON, subject string, debounceMs *int) uuid.UUID {
+	id := generate.UUID(plugin + name + declaration.ToString() + subject)
+	if debounceMs == nil {
+		return id
+	}
+
+	return generate.UUID(id.String() + "debounce_ms" + strconv.Itoa(*debounceMs))
+}
+
+type detachResult struct {
+	// plugin -> controller names
+	detached map[string][]string
+	failed   []string
+	found    bool
+}
+
+// detachControllers asks every controller linked with the session and matched by filter to drop the session from its subscribers
+func detachControllers(ctx *sf.StatefunContextProcessor, sessionID string, filter controllerFilter) detachResult {
+	result := detachResult{
+		detached: make(map[string][]string),
+		failed:   make([]string, 0),
+	}
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(ctx.Request)
+	if err != nil {
+		slog.Error(err.Error())
+		return result
+	}
+
+	for _, controllerID := range common.OutLinkTargets(ctx.Domain.Cache(), sessionID, inStatefun.CONTROLLER_TYPE) {
+		controller, err := cmdb.ObjectRead(controllerID)
+		if err != nil {
+			slog.Warn("failed to read controller", "id", controllerID, "err", err.Error())
+			continue
+		}
+
+		plugin := controller.GetByPath("body.plugin").AsStringDefault("")
+		name := controller.GetByPath("body.name").AsStringDefault("")
+
+		if !filter(plugin, name) {
+			continue
+		}
+
+		result.found = true
+
+		payload := easyjson.NewJSONObjectWithKeyValue("session_id", easyjson.NewJSON(sessionID))
+
+		reply, err := ctx.Request(sf.AutoRequestSelect, inStatefun.CONTROLLER_CLEAR, controllerID, &payload, nil)
+		if err != nil {
+			slog.Warn("failed to clear controller", "id", controllerID, "err", err.Error())
+			result.failed = append(result.failed, name)
+			continue
+		}
+
+		if reply.GetByPath("status").AsStringDefault("failed") != "ok" {
+			slog.Warn("failed to clear controller", "id", controllerID, "err", reply.GetByPath("result.message").AsStringDefault(""))
+			result.failed = append(result.failed, name)
+			continue
+		}
+
+		result.detached[plugin] = append(result.detached[plugin], name)
+	}
+
+	return result
+}
+
+// listControllers describes every controller linked with the session
+func listControllers(ctx *sf.StatefunContextProcessor, sessionID string) []protocol.ControllerInfo {
+	list := make([]protocol.ControllerInfo, 0)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(ctx.Request)
+	if err != nil {
+		slog.Error(err.Error())
+		return list
+	}
+
+	for _, controllerID := range common.OutLinkTargets(ctx.Domain.Cache(), sessionID, inStatefun.CONTROLLER_TYPE) {
+		controller, err := cmdb.ObjectRead(controllerID)
+		if err != nil {
+			slog.Warn("failed to read controller", "id", controllerID, "err", err.Error())
+			continue
+		}
+
+		objects := common.OutLinkTargets(ctx.Domain.Cache(), controllerID, inStatefun.CONTROLLER_OBJECT_TYPE)
+
+		list = append(list, protocol.ControllerInfo{
+			ID:      controllerID,
+			Plugin:  controller.GetByPath("body.plugin").AsStringDefault(""),
+			Name:    controller.GetByPath("body.name").AsStringDefault(""),
+			Objects: len(objects),
+		})
+	}
+
+	return list
+}
+
+// matchControllers returns ids of controllers linked with the session and matched by filter
+func matchControllers(ctx *sf.StatefunContextProcessor, sessionID string, filter controllerFilter) []string {
+	ids := make([]string, 0)
+
+	for _, controllerID := range common.OutLinkTargets(ctx.Domain.Cache(), sessionID, inStatefun.CONTROLLER_TYPE) {
+		controller, err := ctx.Domain.Cache().GetValueAsJSON(controllerID)
+		if err != nil {
+			slog.Warn("failed to read controller", "id", controllerID, "err", err.Error())
+			continue
+		}
+
+		if filter(controller.GetByPath("plugin").AsStringDefault(""), controller.GetByPath("name").AsStringDefault("")) {
+			ids = append(ids, controllerID)
+		}
+	}
+
+	return ids
+}
+
+func allControllers(_, _ string) bool {
+	return true
+}
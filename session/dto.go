@@ -1,11 +1,14 @@
 package session
 
+import "github.com/foliagecp/ui-app-lib/protocol"
+
+// Deprecated: use inbound messages of protocol package
 type IngressPayload struct {
 	Command     string                `json:"command,omitempty"`
+	RequestID   string                `json:"request_id,omitempty"`
 	Controllers map[string]Controller `json:"controllers,omitempty"`
+	Plugin      string                `json:"plugin,omitempty"`
+	Name        string                `json:"name,omitempty"`
 }
 
-type Controller struct {
-	Body  map[string]string `json:"body"`
-	UUIDs []string          `json:"uuids"`
-}
+type Controller = protocol.Controller
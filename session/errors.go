@@ -0,0 +1,58 @@
+package session
+
+import (
+	"log/slog"
+
+	sf "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/ui-app-lib/internal/egress"
+	"github.com/foliagecp/ui-app-lib/protocol"
+)
+
+// ErrorCode is a stable code of the error reply, the catalogue lives in protocol package
+type ErrorCode = protocol.ErrorCode
+
+const (
+	ERR_UNKNOWN_COMMAND      = protocol.ERR_UNKNOWN_COMMAND
+	ERR_INVALID_PAYLOAD      = protocol.ERR_INVALID_PAYLOAD
+	ERR_INTERNAL             = protocol.ERR_INTERNAL
+	ERR_UNSUPPORTED_VERSION  = protocol.ERR_UNSUPPORTED_VERSION
+	ERR_SESSION_NOT_FOUND    = protocol.ERR_SESSION_NOT_FOUND
+	ERR_SESSION_START        = protocol.ERR_SESSION_START
+	ERR_SESSION_CLOSE        = protocol.ERR_SESSION_CLOSE
+	ERR_CONTROLLER_NOT_FOUND = protocol.ERR_CONTROLLER_NOT_FOUND
+	ERR_CONTROLLER_START     = protocol.ERR_CONTROLLER_START
+	ERR_CONTROLLER_CLEAR     = protocol.ERR_CONTROLLER_CLEAR
+	ERR_INVALID_DECLARATION  = protocol.ERR_INVALID_DECLARATION
+	ERR_UNAUTHENTICATED      = protocol.ERR_UNAUTHENTICATED
+	ERR_FORBIDDEN            = protocol.ERR_FORBIDDEN
+	ERR_RATE_LIMITED         = protocol.ERR_RATE_LIMITED
+	ERR_QUOTA_EXCEEDED       = protocol.ERR_QUOTA_EXCEEDED
+)
+
+// sendError sends the error reply to the session client,
+// client_id from the payload is used when the session doesn't exist
+func sendError(ctx *sf.StatefunContextProcessor, reply any) {
+	if err := egress.SendMessageToSession(ctx, ctx.Self.ID, reply); err == nil {
+		return
+	}
+
+	clientID := ctx.Payload.GetByPath("client_id").AsStringDefault("")
+
+	if err := egress.SendMessageToClient(ctx, clientID, reply); err != nil {
+		slog.Warn("failed to send error", "session_id", ctx.Self.ID, "err", err.Error())
+	}
+}
+
+/*
+replyError sends the error envelope:
+
+	{
+		command: "START_SESSION",
+		status: "error",
+		code: "SESSION_START_FAILED",
+		message: "..."
+	}
+*/
+func replyError(ctx *sf.StatefunContextProcessor, command Command, code ErrorCode, message string) {
+	sendError(ctx, protocol.Error(command, code, message))
+}
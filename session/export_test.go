@@ -0,0 +1,6 @@
+package session
+
+// ResetConfig restores the default config after tests which register functions with their own
+func ResetConfig() {
+	config = DefaultConfig()
+}
@@ -0,0 +1,156 @@
+package session
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+	sf "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/ui-app-lib/internal/egress"
+	"github.com/foliagecp/ui-app-lib/protocol"
+)
+
+// defaults of Config
+const (
+	// HeartbeatInterval is the suggested interval of PINGs, the heartbeat is disabled by default
+	// since clients which don't answer PING would be closed
+	HeartbeatInterval = 30 * time.Second
+	// MaxMissedPongs is how many PINGs in a row may stay unanswered before the session is declared dead
+	MaxMissedPongs = 3
+	// SessionClosingTimeout is how long the client has to answer CLOSING_SESSION warning to keep the session alive
+	SessionClosingTimeout = 30 * time.Second
+)
+
+const (
+	_LAST_PING_AT   = "last_ping_at"
+	_LAST_PONG_AT   = "last_pong_at"
+	_MISSED_PONGS   = "missed_pongs"
+	_CLOSING_AT     = "closing_at"
+	_CLOSING_REASON = "closing_reason"
+)
+
+const (
+	closingReasonInactivity = "inactivity"
+	closingReasonHeartbeat  = "heartbeat"
+)
+
+func heartbeatEnabled() bool {
+	return config.HeartbeatInterval > 0
+}
+
+// heartbeat sends PING to the client when it's time to,
+// the previous PING without PONG or any other command in response is counted as missed
+func heartbeat(ctx *sf.StatefunContextProcessor, params *easyjson.JSON, now int64) {
+	if !heartbeatEnabled() {
+		return
+	}
+
+	lastPing := int64(params.GetByPath(_LAST_PING_AT).AsNumericDefault(0))
+	if now-lastPing < int64(config.HeartbeatInterval.Seconds()) {
+		return
+	}
+
+	lastPong := int64(params.GetByPath(_LAST_PONG_AT).AsNumericDefault(0))
+	if lastPing > 0 && lastPong < lastPing {
+		missed := int(params.GetByPath(_MISSED_PONGS).AsNumericDefault(0))
+		params.SetByPath(_MISSED_PONGS, easyjson.NewJSON(missed+1))
+	}
+
+	params.SetByPath(_LAST_PING_AT, easyjson.NewJSON(now))
+
+	ping := protocol.Ping{
+		Command:   PING,
+		Timestamp: now,
+	}
+
+	if err := egress.SendMessageToSession(ctx, ctx.Self.ID, ping); err != nil {
+		slog.Warn("failed to send ping", "session_id", ctx.Self.ID, "err", err.Error())
+	}
+}
+
+// closingReason returns why the session has to be closed or empty string if it's alive
+func closingReason(params *easyjson.JSON, now int64) string {
+	updatedAt := int64(params.GetByPath("updated_at").AsNumericDefault(float64(now)))
+	if updatedAt+int64(config.InactivityTimeout.Seconds()) < now {
+		return closingReasonInactivity
+	}
+
+	if heartbeatEnabled() && int(params.GetByPath(_MISSED_PONGS).AsNumericDefault(0)) >= config.MaxMissedPongs {
+		return closingReasonHeartbeat
+	}
+
+	return ""
+}
+
+// warnClosing sends the final warning to the client before the session will be closed
+func warnClosing(ctx *sf.StatefunContextProcessor, params *easyjson.JSON, reason string, now int64) {
+	params.SetByPath(_CLOSING_AT, easyjson.NewJSON(now))
+	params.SetByPath(_CLOSING_REASON, easyjson.NewJSON(reason))
+
+	warning := protocol.ClosingSession{
+		Command: CLOSING_SESSION,
+		Reason:  reason,
+		Timeout: config.ClosingTimeout.String(),
+	}
+
+	if err := egress.SendMessageToSession(ctx, ctx.Self.ID, warning); err != nil {
+		slog.Warn("failed to send closing warning", "session_id", ctx.Self.ID, "err", err.Error())
+	}
+}
+
+// closingExpired reports whether the client hasn't answered the closing warning in time
+func closingExpired(params *easyjson.JSON, now int64) bool {
+	if !params.PathExists(_CLOSING_AT) {
+		return false
+	}
+
+	closingAt := int64(params.GetByPath(_CLOSING_AT).AsNumericDefault(0))
+
+	return closingAt+int64(config.ClosingTimeout.Seconds()) <= now
+}
+
+// answerPing marks the client alive, any command of the client answers PING the same way PONG does
+func answerPing(params *easyjson.JSON, now int64) {
+	params.SetByPath(_LAST_PONG_AT, easyjson.NewJSON(now))
+	params.SetByPath(_MISSED_PONGS, easyjson.NewJSON(0))
+}
+
+// resetClosing cancels the pending close of the session
+func resetClosing(params *easyjson.JSON) {
+	params.RemoveByPath(_CLOSING_AT)
+	params.RemoveByPath(_CLOSING_REASON)
+}
+
+/*
+	{
+		command: "PONG"
+	}
+
+PONG doesn't count as user activity, but it's the answer to CLOSING_SESSION warning which keeps the session alive.
+*/
+func Pong(_ sf.StatefunExecutor, ctx *sf.StatefunContextProcessor) {
+	if err := ctx.ObjectMutexLock(ctx.Self.ID, false); err != nil {
+		slog.Warn(err.Error())
+		return
+	}
+	defer ctx.ObjectMutexUnlock(ctx.Self.ID)
+
+	params := ctx.GetObjectContext()
+	if !params.IsNonEmptyObject() {
+		replyError(ctx, PONG, ERR_SESSION_NOT_FOUND, "session not started")
+		return
+	}
+
+	now := time.Now().Unix()
+
+	answerPing(params, now)
+
+	if params.PathExists(_CLOSING_AT) {
+		resetClosing(params)
+		params.SetByPath("updated_at", easyjson.NewJSON(now))
+	}
+
+	ctx.SetObjectContext(params)
+
+	scheduleSession(ctx.Self.ID, params)
+}
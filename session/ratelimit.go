@@ -0,0 +1,108 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+	sf "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/ui-app-lib/internal/common"
+	inStatefun "github.com/foliagecp/ui-app-lib/internal/statefun"
+)
+
+// _RATE_BUCKET is the token bucket of session commands, it's kept in the router function context
+const _RATE_BUCKET = "bucket"
+
+// tokenBucket refills Rate tokens per second up to Burst, every command takes one token
+type tokenBucket struct {
+	Tokens float64 `json:"tokens"`
+	// Updated is unix milliseconds of the last refill
+	Updated int64 `json:"updated"`
+}
+
+// take refills the bucket and takes a token, if there is none it returns how long to wait for the next one
+func (b *tokenBucket) take(now time.Time, rate float64, burst int) (bool, time.Duration) {
+	capacity := math.Max(float64(burst), 1)
+
+	if b.Updated == 0 {
+		b.Tokens = capacity
+	} else if elapsed := now.Sub(time.UnixMilli(b.Updated)).Seconds(); elapsed > 0 {
+		b.Tokens = math.Min(capacity, b.Tokens+elapsed*rate)
+	}
+
+	b.Updated = now.UnixMilli()
+
+	if b.Tokens < 1 {
+		return false, time.Duration((1 - b.Tokens) / rate * float64(time.Second))
+	}
+
+	b.Tokens--
+
+	return true, 0
+}
+
+// allowCommand takes a token of the session bucket, commands aren't limited if Config.CommandRate is zero
+func allowCommand(ctx *sf.StatefunContextProcessor) (bool, time.Duration) {
+	if config.CommandRate <= 0 {
+		return true, 0
+	}
+
+	fctx := ctx.GetFunctionContext()
+
+	var bucket tokenBucket
+	if fctx.PathExists(_RATE_BUCKET) {
+		json.Unmarshal(fctx.GetByPath(_RATE_BUCKET).ToBytes(), &bucket)
+	}
+
+	allowed, wait := bucket.take(time.Now(), config.CommandRate, config.CommandBurst)
+
+	data, _ := json.Marshal(bucket)
+	if b, ok := easyjson.JSONFromBytes(data); ok {
+		fctx.SetByPath(_RATE_BUCKET, b)
+		ctx.SetFunctionContext(fctx)
+	}
+
+	return allowed, wait
+}
+
+// controllerQuota counts controllers of the session while START_CONTROLLER adds new ones
+type controllerQuota struct {
+	started map[string]struct{}
+}
+
+func newControllerQuota(ctx *sf.StatefunContextProcessor, sessionID string) *controllerQuota {
+	q := &controllerQuota{started: make(map[string]struct{})}
+
+	if config.MaxControllers > 0 {
+		for _, id := range common.OutLinkTargets(ctx.Domain.Cache(), sessionID, inStatefun.CONTROLLER_TYPE) {
+			q.started[id] = struct{}{}
+		}
+	}
+
+	return q
+}
+
+// check reports why the controller can't be started, nil if it can. Controllers the session already has are restarted freely.
+func (q *controllerQuota) check(controllerID string, objects int) error {
+	if config.MaxControllerObjects > 0 && objects > config.MaxControllerObjects {
+		return fmt.Errorf("%d objects exceed the limit of %d per controller", objects, config.MaxControllerObjects)
+	}
+
+	if config.MaxControllers <= 0 {
+		return nil
+	}
+
+	if _, ok := q.started[controllerID]; ok {
+		return nil
+	}
+
+	if len(q.started) >= config.MaxControllers {
+		return fmt.Errorf("the limit of %d controllers per session is reached", config.MaxControllers)
+	}
+
+	q.started[controllerID] = struct{}{}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket_Take(t *testing.T) {
+	now := time.UnixMilli(1700000000000)
+	bucket := tokenBucket{}
+
+	for i := 0; i < 3; i++ {
+		ok, _ := bucket.take(now, 2, 3)
+		require.True(t, ok)
+	}
+
+	ok, wait := bucket.take(now, 2, 3)
+	require.False(t, ok)
+	require.Equal(t, 500*time.Millisecond, wait)
+
+	// refilled by rate, never above burst
+	ok, _ = bucket.take(now.Add(500*time.Millisecond), 2, 3)
+	require.True(t, ok)
+
+	bucket.take(now.Add(time.Hour), 2, 3)
+	require.Equal(t, float64(2), bucket.Tokens)
+}
+
+func TestControllerQuota_Check(t *testing.T) {
+	defer func(cfg Config) { config = cfg }(config)
+
+	config.MaxControllers = 2
+	config.MaxControllerObjects = 3
+
+	q := &controllerQuota{started: map[string]struct{}{"a": {}}}
+
+	require.Error(t, q.check("b", 4))
+	require.NoError(t, q.check("b", 3))
+	require.NoError(t, q.check("a", 1))
+	require.Error(t, q.check("c", 1))
+}
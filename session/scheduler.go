@@ -0,0 +1,221 @@
+package session
+
+import (
+	"container/heap"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/foliagecp/sdk/clients/go/db"
+	"github.com/foliagecp/sdk/statefun"
+	sf "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/ui-app-lib/internal/common"
+	inStatefun "github.com/foliagecp/ui-app-lib/internal/statefun"
+)
+
+// schedulerIdleTimeout is how long scheduler sleeps when there is nothing to wait for
+const schedulerIdleTimeout = time.Hour
+
+type deadline struct {
+	sessionID string
+	at        time.Time
+	index     int
+}
+
+type deadlineHeap []*deadline
+
+func (h deadlineHeap) Len() int           { return len(h) }
+func (h deadlineHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+
+func (h deadlineHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *deadlineHeap) Push(x any) {
+	d := x.(*deadline)
+	d.index = len(*h)
+	*h = append(*h, d)
+}
+
+func (h *deadlineHeap) Pop() any {
+	old := *h
+	n := len(old)
+	d := old[n-1]
+	old[n-1] = nil
+	d.index = -1
+	*h = old[:n-1]
+	return d
+}
+
+// scheduler keeps a single deadline per session and fires it when time has come.
+// All sessions are served by one goroutine instead of a sleeping handler per session.
+// The deadline only wakes SESSION_WATCH up, it's WatchSession which decides what to do under the session lock,
+// so a deadline outdated by a concurrent command doesn't close the session.
+type scheduler struct {
+	mu        sync.Mutex
+	deadlines deadlineHeap
+	sessions  map[string]*deadline
+	wake      chan struct{}
+	fire      func(sessionID string)
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		sessions: make(map[string]*deadline),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Schedule sets or moves the session deadline
+func (s *scheduler) Schedule(sessionID string, at time.Time) {
+	s.mu.Lock()
+
+	if d, ok := s.sessions[sessionID]; ok {
+		d.at = at
+		heap.Fix(&s.deadlines, d.index)
+	} else {
+		d := &deadline{sessionID: sessionID, at: at}
+		heap.Push(&s.deadlines, d)
+		s.sessions[sessionID] = d
+	}
+
+	earliest := s.deadlines[0].sessionID == sessionID
+
+	s.mu.Unlock()
+
+	if earliest {
+		s.notify()
+	}
+}
+
+// Cancel forgets the session deadline
+func (s *scheduler) Cancel(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.sessions[sessionID]
+	if !ok {
+		return
+	}
+
+	heap.Remove(&s.deadlines, d.index)
+	delete(s.sessions, sessionID)
+}
+
+// Len returns count of scheduled sessions
+func (s *scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.deadlines)
+}
+
+func (s *scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// expired pops all passed deadlines and returns how long to wait for the next one
+func (s *scheduler) expired(now time.Time) ([]*deadline, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fired := make([]*deadline, 0)
+
+	for len(s.deadlines) > 0 && !s.deadlines[0].at.After(now) {
+		d := heap.Pop(&s.deadlines).(*deadline)
+		delete(s.sessions, d.sessionID)
+		fired = append(fired, d)
+	}
+
+	if len(s.deadlines) == 0 {
+		return fired, schedulerIdleTimeout
+	}
+
+	return fired, s.deadlines[0].at.Sub(now)
+}
+
+// Run fires deadlines until stop is closed
+func (s *scheduler) Run(stop <-chan struct{}) {
+	for {
+		fired, wait := s.expired(time.Now())
+
+		for _, d := range fired {
+			s.fire(d.sessionID)
+		}
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+
+		timer.Stop()
+	}
+}
+
+var sessionScheduler = newScheduler()
+
+// scheduleSession plans the next session check at its earliest deadline:
+// end of the closing warning if it's pending, otherwise the next heartbeat, inactivity timeout or token expiration
+func scheduleSession(sessionID string, params *easyjson.JSON) {
+	if params.PathExists(_CLOSING_AT) {
+		closingAt := int64(params.GetByPath(_CLOSING_AT).AsNumericDefault(0))
+		sessionScheduler.Schedule(sessionID, time.Unix(closingAt, 0).Add(config.ClosingTimeout))
+		return
+	}
+
+	now := time.Now().Unix()
+	updatedAt := int64(params.GetByPath("updated_at").AsNumericDefault(float64(now)))
+	lastPing := int64(params.GetByPath(_LAST_PING_AT).AsNumericDefault(float64(now)))
+
+	next := time.Unix(updatedAt, 0).Add(config.InactivityTimeout)
+	if ping := time.Unix(lastPing, 0).Add(config.HeartbeatInterval); heartbeatEnabled() && ping.Before(next) {
+		next = ping
+	}
+
+	if expiresAt := int64(params.GetByPath(_SESSION_EXPIRES_AT).AsNumericDefault(0)); expiresAt > 0 && time.Unix(expiresAt, 0).Before(next) {
+		next = time.Unix(expiresAt, 0)
+	}
+
+	sessionScheduler.Schedule(sessionID, next)
+}
+
+// startScheduler runs session scheduler and schedules sessions which were alive before restart
+func startScheduler(runtime *statefun.Runtime) error {
+	sessionScheduler.fire = func(sessionID string) {
+		if err := runtime.Signal(sf.JetstreamGlobalSignal, inStatefun.SESSION_WATCH, sessionID, nil, nil); err != nil {
+			slog.Warn(err.Error())
+		}
+	}
+
+	if runtime.Domain.Name() == runtime.Domain.HubDomainName() {
+		cmdb, err := db.NewCMDBSyncClientFromRequestFunction(runtime.Request)
+		if err != nil {
+			return err
+		}
+
+		sessionType, err := cmdb.TypeRead(common.SetHubPreffix(runtime.Domain, inStatefun.SESSION_TYPE))
+		if err != nil {
+			slog.Warn("failed to read session type", "err", err.Error())
+		}
+
+		sessions, _ := sessionType.GetByPath("object_ids").AsArrayString()
+		for _, sessionID := range sessions {
+			sessionScheduler.Schedule(sessionID, time.Now())
+		}
+	}
+
+	sessionScheduler.Run(nil)
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduler_Expired(t *testing.T) {
+	s := newScheduler()
+	now := time.Now()
+
+	s.Schedule("a", now.Add(time.Minute))
+	s.Schedule("b", now.Add(-time.Second))
+	s.Schedule("c", now.Add(-time.Minute))
+
+	fired, wait := s.expired(now)
+
+	require.Len(t, fired, 2)
+	require.Equal(t, "c", fired[0].sessionID)
+	require.Equal(t, "b", fired[1].sessionID)
+	require.Equal(t, time.Minute, wait)
+	require.Equal(t, 1, s.Len())
+}
+
+func TestScheduler_Reschedule(t *testing.T) {
+	s := newScheduler()
+	now := time.Now()
+
+	s.Schedule("a", now.Add(-time.Second))
+	s.Schedule("a", now.Add(time.Hour))
+
+	fired, wait := s.expired(now)
+
+	require.Empty(t, fired)
+	require.Equal(t, time.Hour, wait)
+	require.Equal(t, 1, s.Len())
+}
+
+func TestScheduler_Cancel(t *testing.T) {
+	s := newScheduler()
+	now := time.Now()
+
+	s.Schedule("a", now.Add(-time.Second))
+	s.Schedule("b", now.Add(time.Second))
+	s.Cancel("a")
+	s.Cancel("unknown")
+
+	fired, _ := s.expired(now)
+
+	require.Empty(t, fired)
+	require.Equal(t, 1, s.Len())
+}
+
+func TestScheduler_Run(t *testing.T) {
+	s := newScheduler()
+
+	fired := make(chan string, 1)
+	s.fire = func(sessionID string) {
+		fired <- sessionID
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go s.Run(stop)
+
+	s.Schedule("a", time.Now().Add(10*time.Millisecond))
+
+	select {
+	case id := <-fired:
+		require.Equal(t, "a", id)
+	case <-time.After(time.Second):
+		t.Fatal("deadline wasn't fired")
+	}
+}
@@ -2,8 +2,9 @@ package session
 
 import (
 	"encoding/json"
-	"errors"
+	"fmt"
 	"log/slog"
+	"strings"
 	"time"
 
 	"github.com/foliagecp/easyjson"
@@ -15,25 +16,49 @@ import (
 	"github.com/foliagecp/ui-app-lib/internal/egress"
 	"github.com/foliagecp/ui-app-lib/internal/generate"
 	inStatefun "github.com/foliagecp/ui-app-lib/internal/statefun"
+	"github.com/foliagecp/ui-app-lib/protocol"
 )
 
+// default of Config.InactivityTimeout
 const (
-	SessionWatchTimeout      = 5 * time.Second
 	SessionInactivityTimeout = 24 * time.Hour
 )
 
-func RegisterFunctions(runtime *statefun.Runtime) {
-	statefun.NewFunctionType(runtime, inStatefun.INGRESS, Ingress, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1))
-	statefun.NewFunctionType(runtime, inStatefun.SESSION_ROUTER, SessionRouter, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1))
-	statefun.NewFunctionType(runtime, inStatefun.SESSION_START, StartSession, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1))
-	statefun.NewFunctionType(runtime, inStatefun.SESSION_CLOSE, CloseSession, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1))
-	statefun.NewFunctionType(runtime, inStatefun.SESSION_WATCH, WatchSession, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1))
-	statefun.NewFunctionType(runtime, inStatefun.SESSION_UPDATE_ACTIVITY, UpdateSessionActivity, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1))
-	statefun.NewFunctionType(runtime, inStatefun.SESSION_START_CONTROLLER, StartController, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1))
-	statefun.NewFunctionType(runtime, inStatefun.SESSION_CLEAR_CONTROLLER, ClearController, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1))
-	statefun.NewFunctionType(runtime, inStatefun.EGRESS, Egress, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1))
+// Deprecated: sessions aren't polled anymore, they are watched at their deadlines. SessionWatchTimeout isn't used.
+const SessionWatchTimeout = 5 * time.Second
+
+// RegisterFunctions registers session functions with the config, DefaultConfig is used if it's omitted
+func RegisterFunctions(runtime *statefun.Runtime, configs ...Config) {
+	cfg := DefaultConfig()
+	if len(configs) > 0 {
+		cfg = configs[0]
+	}
+
+	cfg = cfg.validated()
+	config = cfg
+
+	fnCfg := func() *statefun.FunctionTypeConfig {
+		return statefun.NewFunctionTypeConfig().SetMaxIdHandlers(cfg.MaxIdHandlers)
+	}
+
+	statefun.NewFunctionType(runtime, inStatefun.INGRESS, Ingress, *fnCfg())
+	statefun.NewFunctionType(runtime, inStatefun.SESSION_ROUTER, SessionRouter, *fnCfg())
+	statefun.NewFunctionType(runtime, inStatefun.SESSION_START, StartSession, *fnCfg())
+	statefun.NewFunctionType(runtime, inStatefun.SESSION_CLOSE, CloseSession, *fnCfg())
+	statefun.NewFunctionType(runtime, inStatefun.SESSION_WATCH, WatchSession, *fnCfg())
+	statefun.NewFunctionType(runtime, inStatefun.SESSION_UPDATE_ACTIVITY, UpdateSessionActivity, *fnCfg())
+	statefun.NewFunctionType(runtime, inStatefun.SESSION_PONG, Pong, *fnCfg())
+	statefun.NewFunctionType(runtime, inStatefun.SESSION_INFO, Info, *fnCfg())
+	statefun.NewFunctionType(runtime, inStatefun.SESSION_START_CONTROLLER, StartController, *fnCfg())
+	statefun.NewFunctionType(runtime, inStatefun.SESSION_CLEAR_CONTROLLER, ClearController, *fnCfg())
+	statefun.NewFunctionType(runtime, inStatefun.SESSION_RESYNC, Resync, *fnCfg())
+	statefun.NewFunctionType(runtime, inStatefun.SESSION_ACK, Ack, *fnCfg())
+	statefun.NewFunctionType(runtime, inStatefun.SESSION_BROADCAST, Broadcast, *fnCfg())
+	statefun.NewFunctionType(runtime, inStatefun.SESSION_DELIVER, Deliver, *fnCfg())
+	statefun.NewFunctionType(runtime, inStatefun.EGRESS, Egress, *fnCfg())
 
 	runtime.RegisterOnAfterStartFunction(InitSchema, false)
+	runtime.RegisterOnAfterStartFunction(startScheduler, true)
 }
 
 func InitSchema(runtime *statefun.Runtime) error {
@@ -58,6 +83,22 @@ func InitSchema(runtime *statefun.Runtime) error {
 		return err
 	}
 
+	if err := c.TypeCreate(
+		common.SetHubPreffix(runtime.Domain, inStatefun.USER_TYPE),
+		easyjson.NewJSONObject(),
+	); err != nil {
+		return err
+	}
+
+	if err := c.TypesLinkCreate(
+		common.SetHubPreffix(runtime.Domain, inStatefun.USER_TYPE),
+		common.SetHubPreffix(runtime.Domain, inStatefun.SESSION_TYPE),
+		inStatefun.SESSION_TYPE,
+		[]string{},
+	); err != nil {
+		return err
+	}
+
 	if err := c.ObjectCreate(
 		common.SetHubPreffix(runtime.Domain, inStatefun.SESSIONS_ENTYPOINT),
 		common.SetHubPreffix(runtime.Domain, crud.BUILT_IN_TYPE_GROUP),
@@ -73,7 +114,17 @@ func InitSchema(runtime *statefun.Runtime) error {
 Payload:
 
 	{
-		command: "START_SESSION" | "CLOSE_SESSION" | "CLEAR_CONTROLLER",
+		command: "START_SESSION" | "CLOSE_SESSION" | "CLEAR_CONTROLLER" | "RESYNC" | "ACK" | "BROADCAST" | "PONG" | "INFO",
+		request_id: "id", // optional, echoed back in every reply to the request
+		version: 1, // START_SESSION only
+		encoding: "full" | "patch", // START_SESSION only
+		token: "...", // START_SESSION only
+		session_token: "...", // every command except START_SESSION, if clients are authenticated
+		user: "name", // START_SESSION only
+		message: {...}, // BROADCAST only
+		plugin: "plugin", // CLEAR_CONTROLLER, RESYNC and ACK only
+		name: "controller_name", // CLEAR_CONTROLLER, RESYNC and ACK only
+		seq: {"<object id>": 42}, // ACK only
 		controllers: {
 			controller_name {
 				body: {},
@@ -91,7 +142,21 @@ func Ingress(_ sf.StatefunExecutor, ctx *sf.StatefunContextProcessor) {
 
 	payload.SetByPath("client_id", easyjson.NewJSON(id))
 
-	if err := ctx.Signal(sf.JetstreamGlobalSignal, inStatefun.SESSION_ROUTER, sessionID, payload, nil); err != nil {
+	// request id and session token travel in options, so the payload stays the same for all commands
+	requestID := payload.GetByPath("request_id").AsStringDefault("")
+	payload.RemoveByPath("request_id")
+
+	options := egress.WithRequestID(requestID)
+	if payload.PathExists(_SESSION_TOKEN) {
+		if options == nil {
+			options = easyjson.NewJSONObject().GetPtr()
+		}
+
+		options.SetByPath(_SESSION_TOKEN, payload.GetByPath(_SESSION_TOKEN))
+		payload.RemoveByPath(_SESSION_TOKEN)
+	}
+
+	if err := ctx.Signal(sf.JetstreamGlobalSignal, inStatefun.SESSION_ROUTER, sessionID, payload, options); err != nil {
 		slog.Warn(err.Error())
 	}
 }
@@ -99,7 +164,11 @@ func Ingress(_ sf.StatefunExecutor, ctx *sf.StatefunContextProcessor) {
 /*
 	{
 		client_id: "id",
-		command: "START_SESSION" | "CLOSE_SESSION" | "CLEAR_CONTROLLER",
+		command: "START_SESSION" | "CLOSE_SESSION" | "CLEAR_CONTROLLER" | "RESYNC" | "ACK" | "BROADCAST" | "PONG" | "INFO",
+		plugin: "plugin", // CLEAR_CONTROLLER, RESYNC and ACK only
+		name: "controller_name", // CLEAR_CONTROLLER, RESYNC and ACK only
+		seq: {"<object id>": 42}, // ACK only
+		message: {...}, // BROADCAST only
 		controllers: {
 			controller_name {
 				body: {},
@@ -114,6 +183,11 @@ var routes = map[Command]string{
 	CLOSE_SESSION:    inStatefun.SESSION_CLOSE,
 	START_CONTROLLER: inStatefun.SESSION_START_CONTROLLER,
 	CLEAR_CONTROLLER: inStatefun.SESSION_CLEAR_CONTROLLER,
+	RESYNC:           inStatefun.SESSION_RESYNC,
+	ACK:              inStatefun.SESSION_ACK,
+	BROADCAST:        inStatefun.SESSION_BROADCAST,
+	PONG:             inStatefun.SESSION_PONG,
+	INFO:             inStatefun.SESSION_INFO,
 }
 
 func SessionRouter(_ sf.StatefunExecutor, ctx *sf.StatefunContextProcessor) {
@@ -124,7 +198,7 @@ func SessionRouter(_ sf.StatefunExecutor, ctx *sf.StatefunContextProcessor) {
 	var command Command
 
 	if payload.PathExists("command") {
-		command = Command(payload.GetByPath("command").AsStringDefault(""))
+		command = Command(strings.ToUpper(payload.GetByPath("command").AsStringDefault("")))
 	} else if len(payload.ObjectKeys()) > 0 {
 		payload.RemoveByPath("client_id")
 		payload.RemoveByPath("command")
@@ -135,41 +209,162 @@ func SessionRouter(_ sf.StatefunExecutor, ctx *sf.StatefunContextProcessor) {
 	next, ok := routes[command]
 	if !ok {
 		logger.Warn("Command not found", "command", command)
+		replyError(ctx, command, ERR_UNKNOWN_COMMAND, "unknown command: "+string(command))
 		return
 	}
 
+	params := ctx.GetObjectContext()
+
+	if command != START_SESSION {
+		if err := verifySession(params, sessionTokenOption(ctx.Options), time.Now().Unix()); err != nil {
+			logger.Warn("Command of unauthenticated session", "command", command, "err", err.Error())
+			replyError(ctx, command, ERR_UNAUTHENTICATED, err.Error())
+			return
+		}
+	}
+
+	// only commands of the session owner are charged, otherwise anyone could drain the bucket of the session.
+	// START_SESSION of a started session can't be verified here, it's answered without changes unless its token is valid.
+	// Heartbeat must pass too, otherwise a throttled client would be declared dead.
+	if command != PONG && (command != START_SESSION || !params.IsNonEmptyObject()) {
+		if allowed, wait := allowCommand(ctx); !allowed {
+			logger.Warn("Command is rate limited", "command", command)
+			replyError(ctx, command, ERR_RATE_LIMITED, "too many commands, retry in "+wait.Round(time.Millisecond).String())
+			return
+		}
+	}
+
 	logger.Info("Forward to next route", "next", next)
 
-	ctx.Signal(sf.JetstreamGlobalSignal, next, sessionID, payload, nil)
-	ctx.Signal(sf.JetstreamGlobalSignal, inStatefun.SESSION_UPDATE_ACTIVITY, sessionID, nil, nil)
+	// the session token isn't passed further
+	ctx.Signal(sf.JetstreamGlobalSignal, next, sessionID, payload, egress.RequestOptions(ctx))
+
+	// heartbeat and acknowledgements are sent by the client itself, they aren't a user activity
+	if command != PONG && command != ACK {
+		ctx.Signal(sf.JetstreamGlobalSignal, inStatefun.SESSION_UPDATE_ACTIVITY, sessionID, nil, nil)
+	}
 }
 
+/*
+	{
+		command: "START_SESSION",
+		version: 2, // optional, version 1 is used if empty
+		encoding: "full" | "patch", // optional, encoding of controller updates, "full" if empty
+		token: "...", // required if Config.Authenticator is set
+		user: "name", // optional, groups sessions of the user if Config.GroupClaimedUsers is set, the verified subject is used if Config.Authenticator is set
+	}
+
+	Response: {
+		command: "START_SESSION",
+		status: "ok",
+		version: 2, // negotiated protocol version
+		encoding: "full" | "patch",
+		subject: "user", // verified principal, if Config.Authenticator is set
+		user: "name", // user the session belongs to
+		session_token: "...", // if Config.Authenticator is set, required with every other command
+	}
+
+The session of an authenticated client is closed when its token expires, START_SESSION with a fresh token of the same
+subject extends it.
+*/
 func StartSession(_ sf.StatefunExecutor, ctx *sf.StatefunContextProcessor) {
 	sessionID := ctx.Self.ID
 	payload := ctx.Payload
 	params := ctx.GetObjectContext()
 
+	var request protocol.StartSession
+	if err := json.Unmarshal(payload.ToBytes(), &request); err != nil {
+		replyError(ctx, START_SESSION, ERR_INVALID_PAYLOAD, err.Error())
+		return
+	}
+
+	principal, err := authenticate(request.Token)
+	if err != nil {
+		slog.Warn("failed to authenticate session", "session_id", sessionID, "err", err.Error())
+		replyError(ctx, START_SESSION, ERR_UNAUTHENTICATED, err.Error())
+		return
+	}
+
 	if params.IsNonEmptyObject() {
-		response := easyjson.NewJSONObject()
-		response.SetByPath("command", easyjson.NewJSON(START_SESSION))
-		response.SetByPath("status", easyjson.NewJSON("ok"))
-		response.SetByPath("message", easyjson.NewJSON("already started"))
+		// the session belongs to whoever has started it
+		if principal != nil && principal.Subject != sessionSubject(params) {
+			replyError(ctx, START_SESSION, ERR_UNAUTHENTICATED, "session is started by another principal")
+			return
+		}
 
-		egress.SendToSessionEgress(ctx, sessionID, easyjson.NewJSONObjectWithKeyValue("payload", response).GetPtr())
+		// e.g. the client has reconnected with a fresh token
+		if principal != nil {
+			if err := renewSession(ctx, *principal); err != nil {
+				replyError(ctx, START_SESSION, ERR_INTERNAL, err.Error())
+				return
+			}
+		}
+
+		reply := protocol.StartSessionReply{
+			Reply:        protocol.OK(START_SESSION),
+			Version:      sessionVersion(params),
+			Encoding:     protocol.Encoding(params.GetByPath("encoding").AsStringDefault(string(protocol.EncodingFull))),
+			Subject:      sessionSubject(params),
+			User:         sessionUser(params),
+			SessionToken: params.GetByPath(_SESSION_TOKEN).AsStringDefault(""),
+		}
+		reply.Message = "already started"
+
+		egress.SendMessageToSession(ctx, sessionID, reply)
 
 		return
 	}
 
+	version, ok := protocol.Negotiate(request.Version)
+	if !ok {
+		replyError(ctx, START_SESSION, ERR_UNSUPPORTED_VERSION, fmt.Sprintf("protocol version %d is not supported, min is %d", request.Version, protocol.MinVersion))
+		return
+	}
+
+	encoding, ok := protocol.NegotiateEncoding(request.Encoding)
+	if !ok {
+		replyError(ctx, START_SESSION, ERR_INVALID_PAYLOAD, fmt.Sprintf("encoding %q is not supported", request.Encoding))
+		return
+	}
+
 	now := time.Now().Unix()
 
 	body := easyjson.NewJSONObject()
 	body.SetByPath("created_at", easyjson.NewJSON(now))
 	body.SetByPath("updated_at", easyjson.NewJSON(now))
 	body.SetByPath("client_id", payload.GetByPath("client_id"))
+	body.SetByPath("version", easyjson.NewJSON(version))
+	body.SetByPath("encoding", easyjson.NewJSON(string(encoding)))
+
+	if principal != nil {
+		token, err := newSessionToken()
+		if err != nil {
+			replyError(ctx, START_SESSION, ERR_INTERNAL, err.Error())
+			return
+		}
+
+		body.SetByPath(_SESSION_PRINCIPAL, principalJSON(*principal))
+		body.SetByPath(_SESSION_TOKEN, easyjson.NewJSON(token))
 
-	cmdb, _ := db.NewCMDBSyncClientFromRequestFunction(ctx.Request)
+		if expiresAt := principalExpiresAt(*principal); expiresAt > 0 {
+			body.SetByPath(_SESSION_EXPIRES_AT, easyjson.NewJSON(expiresAt))
+		}
+	}
+
+	user := sessionUserName(principal, request.User)
+	if user != "" {
+		body.SetByPath(_SESSION_USER, easyjson.NewJSON(user))
+	}
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(ctx.Request)
+	if err != nil {
+		replyError(ctx, START_SESSION, ERR_INTERNAL, err.Error())
+		return
+	}
 
 	if err := cmdb.ObjectCreate(sessionID, inStatefun.SESSION_TYPE, body); err != nil {
+		slog.Warn("failed to create session", "session_id", sessionID, "err", err.Error())
+		replyError(ctx, START_SESSION, ERR_SESSION_START, err.Error())
 		return
 	}
 
@@ -179,38 +374,77 @@ func StartSession(_ sf.StatefunExecutor, ctx *sf.StatefunContextProcessor) {
 		sessionID,
 		[]string{},
 	); err != nil {
+		slog.Warn("failed to link session", "session_id", sessionID, "err", err.Error())
+		replyError(ctx, START_SESSION, ERR_SESSION_START, err.Error())
 		return
 	}
 
-	response := easyjson.NewJSONObject()
-	response.SetByPath("command", easyjson.NewJSON(START_SESSION))
-	response.SetByPath("status", easyjson.NewJSON("ok"))
+	if user != "" {
+		if err := attachUser(ctx, cmdb, sessionID, user); err != nil {
+			slog.Warn("failed to attach session to user", "session_id", sessionID, "user", user, "err", err.Error())
+			replyError(ctx, START_SESSION, ERR_SESSION_START, err.Error())
+			return
+		}
+	}
 
-	egress.SendToSessionEgress(ctx, sessionID, easyjson.NewJSONObjectWithKeyValue("payload", response).GetPtr())
+	egress.SendMessageToSession(ctx, sessionID, protocol.StartSessionReply{
+		Reply:        protocol.OK(START_SESSION),
+		Version:      version,
+		Encoding:     encoding,
+		Subject:      sessionSubject(&body),
+		User:         user,
+		SessionToken: body.GetByPath(_SESSION_TOKEN).AsStringDefault(""),
+	})
 
 	ctx.Signal(sf.JetstreamGlobalSignal, inStatefun.SESSION_WATCH, sessionID, nil, nil)
 }
 
+/*
+Signaled by session scheduler when the session deadline passes: it's time for heartbeat or inactivity check.
+*/
 func WatchSession(_ sf.StatefunExecutor, ctx *sf.StatefunContextProcessor) {
+	sessionID := ctx.Self.ID
+
+	if err := ctx.ObjectMutexLock(sessionID, false); err != nil {
+		slog.Warn(err.Error())
+		return
+	}
+	defer ctx.ObjectMutexUnlock(sessionID)
+
 	params := ctx.GetObjectContext()
 	if !params.IsNonEmptyObject() {
+		sessionScheduler.Cancel(sessionID)
 		return
 	}
 
 	now := time.Now().Unix()
-	updatedAt := int64(params.GetByPath("updated_at").AsNumericDefault(float64(now)))
 
-	// session expired
-	if updatedAt+int64(SessionInactivityTimeout.Seconds()) < now {
-		ctx.Signal(sf.JetstreamGlobalSignal, inStatefun.SESSION_CLOSE, ctx.Self.ID, nil, nil)
+	// client didn't answer the closing warning or its token has expired
+	if closingExpired(params, now) || sessionExpired(params, now) {
+		ctx.Signal(sf.JetstreamGlobalSignal, inStatefun.SESSION_CLOSE, sessionID, nil, nil)
 		return
 	}
 
-	time.Sleep(SessionWatchTimeout)
-	ctx.Signal(sf.JetstreamGlobalSignal, inStatefun.SESSION_WATCH, ctx.Self.ID, nil, nil)
+	if !params.PathExists(_CLOSING_AT) {
+		if reason := closingReason(params, now); reason != "" {
+			warnClosing(ctx, params, reason, now)
+		} else {
+			heartbeat(ctx, params, now)
+		}
+
+		ctx.SetObjectContext(params)
+	}
+
+	scheduleSession(sessionID, params)
 }
 
 func UpdateSessionActivity(_ sf.StatefunExecutor, ctx *sf.StatefunContextProcessor) {
+	if err := ctx.ObjectMutexLock(ctx.Self.ID, false); err != nil {
+		slog.Warn(err.Error())
+		return
+	}
+	defer ctx.ObjectMutexUnlock(ctx.Self.ID)
+
 	params := ctx.GetObjectContext()
 	if !params.IsNonEmptyObject() {
 		return
@@ -218,34 +452,138 @@ func UpdateSessionActivity(_ sf.StatefunExecutor, ctx *sf.StatefunContextProcess
 
 	now := time.Now().Unix()
 	params.SetByPath("updated_at", easyjson.NewJSON(now))
+	answerPing(params, now)
+	resetClosing(params)
 
 	ctx.SetObjectContext(params)
+
+	scheduleSession(ctx.Self.ID, params)
 }
 
+/*
+Closes the session explicitly or on inactivity, every controller linked with the session gets detached.
+
+	Response: {
+		command: "CLOSE_SESSION",
+		status: "ok" | "error",
+		code: "SESSION_CLOSE_FAILED" | "CONTROLLER_CLEAR_FAILED", // error only
+		message: "...", // error only
+		controllers: {
+			plugin: [controller_name, ...]
+		}
+	}
+*/
 func CloseSession(_ sf.StatefunExecutor, ctx *sf.StatefunContextProcessor) {
 	sessionID := ctx.Self.ID
 
 	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(ctx.Request)
 	if err != nil {
+		replyError(ctx, CLOSE_SESSION, ERR_INTERNAL, err.Error())
+		return
+	}
+
+	session, err := cmdb.ObjectRead(sessionID)
+	if err != nil {
+		slog.Warn("failed to read session", "session_id", sessionID, "err", err.Error())
+		replyError(ctx, CLOSE_SESSION, ERR_SESSION_NOT_FOUND, "session not found")
+		return
+	}
+
+	clientID := session.GetByPath("body.client_id").AsStringDefault("")
+	user := session.GetByPath("body." + _SESSION_USER).AsStringDefault("")
+
+	sessionScheduler.Cancel(sessionID)
+
+	result := detachControllers(ctx, sessionID, allControllers)
+
+	reply := protocol.CloseSessionReply{
+		Reply:       protocol.OK(CLOSE_SESSION),
+		Controllers: result.detached,
+	}
+
+	if err := cmdb.ObjectDelete(sessionID); err != nil {
+		reply.Reply = protocol.Error(CLOSE_SESSION, ERR_SESSION_CLOSE, err.Error())
+	} else if user != "" {
+		// other sessions of the user stay open
+		releaseUser(ctx, cmdb, user, sessionID)
+	}
+
+	if reply.Status == protocol.StatusOK && len(result.failed) > 0 {
+		reply.Reply = protocol.Error(CLOSE_SESSION, ERR_CONTROLLER_CLEAR, "failed to clear controllers: "+strings.Join(result.failed, ", "))
+	}
+
+	egress.SendMessageToClient(ctx, clientID, reply)
+}
+
+/*
+	Response: {
+		command: "INFO",
+		status: "ok" | "error",
+		client_id: "id",
+		creation_time: 1695292826, // unix seconds
+		last_activity_time: 1695292826, // unix seconds
+		inactivity_timeout: "24h0m0s",
+		life_time: 120, // seconds since creation
+		controllers: [
+			{
+				id: "controller_id",
+				plugin: "plugin",
+				name: "controller_name",
+				objects: 1
+			}
+		],
+		user: "name", // if the session belongs to a user
+		user_sessions: 2 // sessions of the user including this one
+	}
+*/
+func Info(_ sf.StatefunExecutor, ctx *sf.StatefunContextProcessor) {
+	sessionID := ctx.Self.ID
+	params := ctx.GetObjectContext()
+
+	if !params.IsNonEmptyObject() {
+		replyError(ctx, INFO, ERR_SESSION_NOT_FOUND, "session not started")
 		return
 	}
 
-	cmdb.ObjectDelete(ctx.Self.ID)
+	createdAt := int64(params.GetByPath("created_at").AsNumericDefault(0))
 
-	response := easyjson.NewJSONObject()
-	response.SetByPath("command", easyjson.NewJSON(CLOSE_SESSION))
-	response.SetByPath("status", easyjson.NewJSON("ok"))
+	user := sessionUser(params)
+	sessions := 0
+	if user != "" {
+		sessions = len(userSessions(ctx, user))
+	}
 
-	egress.SendToSessionEgress(ctx, sessionID, easyjson.NewJSONObjectWithKeyValue("payload", response).GetPtr())
+	egress.SendMessageToSession(ctx, sessionID, protocol.InfoReply{
+		Reply:             protocol.OK(INFO),
+		ClientID:          params.GetByPath("client_id").AsStringDefault(""),
+		CreationTime:      createdAt,
+		LastActivityTime:  int64(params.GetByPath("updated_at").AsNumericDefault(0)),
+		InactivityTimeout: config.InactivityTimeout.String(),
+		LifeTime:          time.Now().Unix() - createdAt,
+		Controllers:       listControllers(ctx, sessionID),
+		User:              user,
+		UserSessions:      sessions,
+	})
 }
 
+/*
+Since protocol version 2 every controller of the command is answered on its own: rejected ones here,
+started ones by the adapter. Older versions get one reply for the whole command.
+*/
 func StartController(_ sf.StatefunExecutor, ctx *sf.StatefunContextProcessor) {
 	sessionID := ctx.Self.ID
+	params := ctx.GetObjectContext()
+	subject := sessionSubject(params)
+	quota := newControllerQuota(ctx, sessionID)
+	perController := sessionVersion(params) >= protocol.VersionControllerReplies
+	answered := 0
+	rejected := make([]string, 0)
 
 	for _, plugin := range ctx.Payload.ObjectKeys() {
 		var controllers map[string]Controller
 		if err := json.Unmarshal(ctx.Payload.GetByPath(plugin).ToBytes(), &controllers); err != nil {
 			slog.Error(err.Error())
+			replyError(ctx, START_CONTROLLER, ERR_INVALID_PAYLOAD, "invalid controllers of plugin "+plugin+": "+err.Error())
 			return
 		}
 
@@ -254,7 +592,7 @@ func StartController(_ sf.StatefunExecutor, ctx *sf.StatefunContextProcessor) {
 				continue
 			}
 
-			body := easyjson.NewJSON(controller.Body)
+			body := easyjson.NewJSON(map[string]any(controller.Body))
 
 			payload := easyjson.NewJSONObject()
 			payload.SetByPath("plugin", easyjson.NewJSON(plugin))
@@ -262,41 +600,226 @@ func StartController(_ sf.StatefunExecutor, ctx *sf.StatefunContextProcessor) {
 			payload.SetByPath("uuids", easyjson.JSONFromArray(controller.UUIDs))
 			payload.SetByPath("name", easyjson.NewJSON(name))
 
-			controllerID := generate.UUID(plugin + name + body.ToString())
+			if controller.DebounceMs != nil {
+				payload.SetByPath("debounce_ms", easyjson.NewJSON(*controller.DebounceMs))
+			}
+
+			if len(controller.Fields) > 0 {
+				payload.SetByPath("fields", easyjson.JSONFromArray(controller.Fields))
+			}
+
+			// controllers are shared only by sessions of the same principal, so results are authorized once
+			if subject != "" {
+				payload.SetByPath(_SESSION_PRINCIPAL, params.GetByPath(_SESSION_PRINCIPAL))
+			}
+
 			controllerIDWithDomain := ctx.Domain.CreateObjectIDWithDomain(
 				ctx.Domain.GetDomainFromObjectID(controller.UUIDs[0]),
-				controllerID.String(),
+				controllerID(plugin, name, body, subject, controller.DebounceMs).String(),
 				false,
 			)
 
-			err := ctx.Signal(sf.JetstreamGlobalSignal, inStatefun.CONTROLLER_START, controllerIDWithDomain, &payload, nil)
+			answered++
+
+			// other controllers of the command are started anyway
+			if err := quota.check(controllerIDWithDomain, len(controller.UUIDs)); err != nil {
+				if perController {
+					replyControllerError(ctx, plugin, name, ERR_QUOTA_EXCEEDED, err.Error())
+				} else {
+					rejected = append(rejected, plugin+"/"+name+": "+err.Error())
+				}
+				continue
+			}
+
+			// the adapter replies once the declaration is validated and objects are authorized and attached
+			err := ctx.Signal(sf.JetstreamGlobalSignal, inStatefun.CONTROLLER_START, controllerIDWithDomain, &payload, egress.RequestOptions(ctx))
 			if err != nil {
 				slog.Error(err.Error())
-				return
+
+				if !perController {
+					replyError(ctx, START_CONTROLLER, ERR_CONTROLLER_START, err.Error())
+					return
+				}
+
+				replyControllerError(ctx, plugin, name, ERR_CONTROLLER_START, err.Error())
 			}
 		}
 	}
 
-	response := easyjson.NewJSONObject()
-	response.SetByPath("command", easyjson.NewJSON(START_CONTROLLER))
-	response.SetByPath("status", easyjson.NewJSON("ok"))
+	switch {
+	case !perController && len(rejected) > 0:
+		replyError(ctx, START_CONTROLLER, ERR_QUOTA_EXCEEDED, strings.Join(rejected, "; "))
+	case !perController || answered == 0:
+		egress.SendMessageToSession(ctx, sessionID, protocol.OK(START_CONTROLLER))
+	}
+}
+
+// sessionVersion is the negotiated protocol version, sessions started before versions were negotiated speak the default one
+func sessionVersion(params *easyjson.JSON) int {
+	return int(params.GetByPath("version").AsNumericDefault(protocol.DefaultVersion))
+}
 
-	egress.SendToSessionEgress(ctx, sessionID, easyjson.NewJSONObjectWithKeyValue("payload", response).GetPtr())
+// replyControllerError tells the client that the controller of START_CONTROLLER isn't started
+func replyControllerError(ctx *sf.StatefunContextProcessor, plugin, name string, code ErrorCode, message string) {
+	sendError(ctx, protocol.ControllerReply{
+		Reply:  protocol.Error(START_CONTROLLER, code, message),
+		Plugin: plugin,
+		Name:   name,
+	})
 }
 
-// find all controller objects
-// delete links
-// clear declaration
+/*
+	{
+		command: "CLEAR_CONTROLLER",
+		plugin: "plugin",
+		name: "controller_name", // optional, all plugin's controllers will be cleared if empty
+	}
+*/
 func ClearController(_ sf.StatefunExecutor, ctx *sf.StatefunContextProcessor) {
 	sessionID := ctx.Self.ID
 
-	slog.Error(errors.ErrUnsupported.Error())
+	var request protocol.ClearController
+	if err := json.Unmarshal(ctx.Payload.ToBytes(), &request); err != nil {
+		replyError(ctx, CLEAR_CONTROLLER, ERR_INVALID_PAYLOAD, err.Error())
+		return
+	}
+
+	plugin, name := request.Plugin, request.Name
+
+	if plugin == "" {
+		replyError(ctx, CLEAR_CONTROLLER, ERR_INVALID_PAYLOAD, "missing plugin")
+		return
+	}
+
+	result := detachControllers(ctx, sessionID, func(p, n string) bool {
+		return p == plugin && (name == "" || n == name)
+	})
+
+	cleared, ok := result.detached[plugin]
+	if !ok {
+		cleared = make([]string, 0)
+	}
+
+	reply := protocol.ClearControllerReply{
+		Reply:       protocol.OK(CLEAR_CONTROLLER),
+		Plugin:      plugin,
+		Controllers: cleared,
+	}
+
+	switch {
+	case !result.found:
+		reply.Reply = protocol.Error(CLEAR_CONTROLLER, ERR_CONTROLLER_NOT_FOUND, "controller not found")
+	case len(result.failed) > 0:
+		reply.Reply = protocol.Error(CLEAR_CONTROLLER, ERR_CONTROLLER_CLEAR, "failed to clear controllers: "+strings.Join(result.failed, ", "))
+	}
+
+	egress.SendMessageToSession(ctx, sessionID, reply)
+}
+
+/*
+	{
+		command: "RESYNC",
+		plugin: "plugin",
+		name: "controller_name", // optional, all plugin's controllers are resent if empty
+	}
+
+	Response, one for every matched controller: {
+		command: "RESYNC",
+		status: "ok",
+		plugin: "plugin",
+		name: "controller_name",
+		objects: {
+			"<object id>": {
+				seq: 42,
+				result: {...}
+			}
+		}
+	}
+*/
+func Resync(_ sf.StatefunExecutor, ctx *sf.StatefunContextProcessor) {
+	sessionID := ctx.Self.ID
+
+	var request protocol.Resync
+	if err := json.Unmarshal(ctx.Payload.ToBytes(), &request); err != nil {
+		replyError(ctx, RESYNC, ERR_INVALID_PAYLOAD, err.Error())
+		return
+	}
+
+	plugin, name := request.Plugin, request.Name
 
-	response := easyjson.NewJSONObject()
-	response.SetByPath("command", easyjson.NewJSON(CLEAR_CONTROLLER))
-	response.SetByPath("status", easyjson.NewJSON("ok"))
+	if plugin == "" {
+		replyError(ctx, RESYNC, ERR_INVALID_PAYLOAD, "missing plugin")
+		return
+	}
 
-	egress.SendToSessionEgress(ctx, sessionID, easyjson.NewJSONObjectWithKeyValue("payload", response).GetPtr())
+	controllers := matchControllers(ctx, sessionID, func(p, n string) bool {
+		return p == plugin && (name == "" || n == name)
+	})
+
+	if len(controllers) == 0 {
+		replyError(ctx, RESYNC, ERR_CONTROLLER_NOT_FOUND, "controller not found")
+		return
+	}
+
+	payload := easyjson.NewJSONObjectWithKeyValue("snapshot", easyjson.NewJSON(sessionID))
+	payload.SetByPath("reply", easyjson.NewJSON(true))
+
+	for _, controllerID := range controllers {
+		if err := ctx.Signal(sf.JetstreamGlobalSignal, inStatefun.CONTROLLER_UPDATE, controllerID, &payload, egress.RequestOptions(ctx)); err != nil {
+			slog.Warn("failed to request controller snapshot", "id", controllerID, "err", err.Error())
+		}
+	}
+}
+
+/*
+	{
+		command: "ACK",
+		plugin: "plugin",
+		name: "controller_name",
+		seq: {
+			"<object id>": 42 // sequence number of the result applied by the client
+		}
+	}
+
+Patches of the acknowledged objects start from the acknowledged results, so a patch lost on the way is sent again
+within the next one. ACK is answered only with an error.
+*/
+func Ack(_ sf.StatefunExecutor, ctx *sf.StatefunContextProcessor) {
+	sessionID := ctx.Self.ID
+
+	var request protocol.Ack
+	if err := json.Unmarshal(ctx.Payload.ToBytes(), &request); err != nil {
+		replyError(ctx, ACK, ERR_INVALID_PAYLOAD, err.Error())
+		return
+	}
+
+	// sequence numbers are counted per controller object, so the controller must be named
+	if request.Plugin == "" || request.Name == "" {
+		replyError(ctx, ACK, ERR_INVALID_PAYLOAD, "missing plugin or name")
+		return
+	}
+
+	controllers := matchControllers(ctx, sessionID, func(p, n string) bool {
+		return p == request.Plugin && n == request.Name
+	})
+
+	if len(controllers) == 0 {
+		replyError(ctx, ACK, ERR_CONTROLLER_NOT_FOUND, "controller not found")
+		return
+	}
+
+	// object ids aren't used as paths, they may contain dots
+	data, _ := json.Marshal(request.Seq)
+	seq, _ := easyjson.JSONFromBytes(data)
+
+	payload := easyjson.NewJSONObjectWithKeyValue("ack", easyjson.NewJSON(sessionID))
+	payload.SetByPath("seq", seq)
+
+	for _, controllerID := range controllers {
+		if err := ctx.Signal(sf.JetstreamGlobalSignal, inStatefun.CONTROLLER_UPDATE, controllerID, &payload, nil); err != nil {
+			slog.Warn("failed to acknowledge controller results", "id", controllerID, "err", err.Error())
+		}
+	}
 }
 
 func Egress(_ sf.StatefunExecutor, ctx *sf.StatefunContextProcessor) {
@@ -304,3 +827,102 @@ func Egress(_ sf.StatefunExecutor, ctx *sf.StatefunContextProcessor) {
 		slog.Warn(err.Error())
 	}
 }
+
+/*
+	{
+		command: "BROADCAST",
+		message: {...}, // any JSON, delivered as is
+		include_self: false // optional, the sending session gets the message too
+	}
+
+	Response: {
+		command: "BROADCAST",
+		status: "ok",
+		sessions: 2 // sessions the message is sent to
+	}
+
+Other sessions of the user get:
+
+	{
+		event: "broadcast",
+		message: {...},
+		unsolicited: true
+	}
+*/
+func Broadcast(_ sf.StatefunExecutor, ctx *sf.StatefunContextProcessor) {
+	sessionID := ctx.Self.ID
+
+	var request protocol.Broadcast
+	if err := json.Unmarshal(ctx.Payload.ToBytes(), &request); err != nil {
+		replyError(ctx, BROADCAST, ERR_INVALID_PAYLOAD, err.Error())
+		return
+	}
+
+	if len(request.Message) == 0 {
+		replyError(ctx, BROADCAST, ERR_INVALID_PAYLOAD, "missing message")
+		return
+	}
+
+	user := sessionUser(ctx.GetObjectContext())
+	if user == "" {
+		replyError(ctx, BROADCAST, ERR_INVALID_PAYLOAD, "session doesn't belong to a user")
+		return
+	}
+
+	message, ok := easyjson.JSONFromBytes(request.Message)
+	if !ok {
+		replyError(ctx, BROADCAST, ERR_INVALID_PAYLOAD, "invalid message")
+		return
+	}
+
+	payload := easyjson.NewJSONObject()
+	payload.SetByPath(_SESSION_USER, easyjson.NewJSON(user))
+	payload.SetByPath("message", message)
+
+	sent := 0
+
+	for _, targetID := range userSessions(ctx, user) {
+		if targetID == sessionID && !request.IncludeSelf {
+			continue
+		}
+
+		// delivered by the target session, so the message doesn't carry the request id of the sender
+		if err := ctx.Signal(sf.JetstreamGlobalSignal, inStatefun.SESSION_DELIVER, targetID, &payload, nil); err != nil {
+			slog.Warn("failed to broadcast", "session_id", targetID, "err", err.Error())
+			continue
+		}
+
+		sent++
+	}
+
+	egress.SendMessageToSession(ctx, sessionID, protocol.BroadcastReply{
+		Reply:    protocol.OK(BROADCAST),
+		Sessions: sent,
+	})
+}
+
+/*
+Deliver sends the broadcast message to the session client if the session still belongs to the user.
+
+	{
+		user: "name",
+		message: {...}
+	}
+*/
+func Deliver(_ sf.StatefunExecutor, ctx *sf.StatefunContextProcessor) {
+	params := ctx.GetObjectContext()
+
+	if !params.IsNonEmptyObject() || sessionUser(params) != ctx.Payload.GetByPath(_SESSION_USER).AsStringDefault("") {
+		return
+	}
+
+	msg := protocol.BroadcastMessage{
+		Event:       protocol.EventBroadcast,
+		Message:     ctx.Payload.GetByPath("message").ToBytes(),
+		Unsolicited: true,
+	}
+
+	if err := egress.SendMessageToSession(ctx, ctx.Self.ID, msg); err != nil {
+		slog.Warn("failed to deliver broadcast", "session_id", ctx.Self.ID, "err", err.Error())
+	}
+}
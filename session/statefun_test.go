@@ -12,10 +12,14 @@ import (
 	"github.com/foliagecp/sdk/statefun/plugins"
 	"github.com/foliagecp/sdk/statefun/test"
 	"github.com/foliagecp/ui-app-lib/adapter"
+	"github.com/foliagecp/ui-app-lib/adapter/decorators"
+	"github.com/foliagecp/ui-app-lib/auth"
 	"github.com/foliagecp/ui-app-lib/internal/generate"
 	inStatefun "github.com/foliagecp/ui-app-lib/internal/statefun"
+	"github.com/foliagecp/ui-app-lib/protocol"
 	"github.com/foliagecp/ui-app-lib/session"
 	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -138,7 +142,7 @@ func (s *sessionTestSuite) Test_SessionRouter_Controllers_Correct() {
 
 	controllers := map[string]session.Controller{
 		"ctrl": {
-			Body:  make(map[string]string),
+			Body:  make(protocol.Declaration),
 			UUIDs: []string{"uuid"},
 		},
 	}
@@ -182,24 +186,110 @@ func (s *sessionTestSuite) Test_StartSession_Correct() {
 	msg, err := sub.NextMsg(5 * time.Second)
 	s.Require().NoError(err)
 
-	wantResponse := `{"payload":{"command":"START_SESSION","status":"ok"}}`
-	s.Require().Equal(wantResponse, string(msg.Data))
+	wantResponse := `{"payload":{"command":"START_SESSION","status":"ok","version":1,"encoding":"full"}}`
+	s.Require().JSONEq(wantResponse, string(msg.Data))
 
 	gotSession, err := s.CacheValue(sessionID)
 	s.Require().NoError(err)
 
 	s.Equal(clientID, gotSession.GetByPath("client_id").AsStringDefault(""))
+	s.Equal(float64(protocol.DefaultVersion), gotSession.GetByPath("version").AsNumericDefault(0))
+	s.Equal(string(protocol.EncodingFull), gotSession.GetByPath("encoding").AsStringDefault(""))
 	// TODO: check link from SESSION_ENTRYPOINT to session
 }
 
+func (s *sessionTestSuite) Test_StartSession_UnsupportedVersion() {
+	typename := inStatefun.SESSION_START
+	cfg := *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1)
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	s.OnAfterStartFunction(session.InitSchema, true)
+	s.RegisterFunction(inStatefun.EGRESS, session.Egress, cfg)
+	s.RegisterFunction(typename, session.StartSession, cfg)
+	s.StartRuntime()
+
+	clientID := uuid.New().String()
+	sessionID := generate.SessionID(clientID).String()
+
+	sub, err := s.SubscribeEgress(inStatefun.EGRESS, clientID)
+	s.Require().NoError(err)
+
+	defer sub.Unsubscribe()
+
+	payload := easyjson.NewJSONObject()
+	payload.SetByPath("client_id", easyjson.NewJSON(clientID))
+	payload.SetByPath("version", easyjson.NewJSON(-1))
+
+	err = s.Signal(plugins.JetstreamGlobalSignal, typename, sessionID, &payload, nil)
+	s.Require().NoError(err)
+
+	msg, err := sub.NextMsg(5 * time.Second)
+	s.Require().NoError(err)
+
+	reply, ok := easyjson.JSONFromBytes(msg.Data)
+	s.Require().True(ok)
+
+	s.Equal("error", reply.GetByPath("payload.status").AsStringDefault(""))
+	s.Equal(string(protocol.ERR_UNSUPPORTED_VERSION), reply.GetByPath("payload.code").AsStringDefault(""))
+
+	_, err = s.CacheValue(sessionID)
+	s.Error(err)
+}
+
+func (s *sessionTestSuite) Test_StartSession_PatchEncoding() {
+	typename := inStatefun.SESSION_START
+	cfg := *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1)
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	s.OnAfterStartFunction(session.InitSchema, true)
+	s.RegisterFunction(inStatefun.EGRESS, session.Egress, cfg)
+	s.RegisterFunction(typename, session.StartSession, cfg)
+	s.StartRuntime()
+
+	clientID := uuid.New().String()
+	sessionID := generate.SessionID(clientID).String()
+
+	sub, err := s.SubscribeEgress(inStatefun.EGRESS, clientID)
+	s.Require().NoError(err)
+
+	defer sub.Unsubscribe()
+
+	payload := easyjson.NewJSONObject()
+	payload.SetByPath("client_id", easyjson.NewJSON(clientID))
+	payload.SetByPath("encoding", easyjson.NewJSON("gzip"))
+
+	err = s.Signal(plugins.JetstreamGlobalSignal, typename, sessionID, &payload, nil)
+	s.Require().NoError(err)
+
+	msg, err := sub.NextMsg(5 * time.Second)
+	s.Require().NoError(err)
+
+	reply, ok := easyjson.JSONFromBytes(msg.Data)
+	s.Require().True(ok)
+	s.Equal(string(protocol.ERR_INVALID_PAYLOAD), reply.GetByPath("payload.code").AsStringDefault(""))
+
+	payload.SetByPath("encoding", easyjson.NewJSON(string(protocol.EncodingPatch)))
+
+	err = s.Signal(plugins.JetstreamGlobalSignal, typename, sessionID, &payload, nil)
+	s.Require().NoError(err)
+
+	msg, err = sub.NextMsg(5 * time.Second)
+	s.Require().NoError(err)
+
+	wantResponse := `{"payload":{"command":"START_SESSION","status":"ok","version":1,"encoding":"patch"}}`
+	s.Require().JSONEq(wantResponse, string(msg.Data))
+
+	gotSession, err := s.CacheValue(sessionID)
+	s.Require().NoError(err)
+	s.Equal(string(protocol.EncodingPatch), gotSession.GetByPath("encoding").AsStringDefault(""))
+}
+
 func (s *sessionTestSuite) Test_StartController_Correct() {
 	typename := inStatefun.SESSION_START_CONTROLLER
 
 	crud.RegisterAllFunctionTypes(s.Runtime())
-	s.RegisterFunction(inStatefun.CONTROLLER_START, adapter.StartController, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1))
-	s.RegisterFunction(inStatefun.EGRESS, session.Egress, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1))
-	s.RegisterFunction(typename, session.StartController, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1))
-	s.OnAfterStartFunction(session.InitSchema, true)
+	session.RegisterFunctions(s.Runtime())
+	adapter.RegisterFunctions(s.Runtime())
 
 	err := s.StartRuntime()
 	s.Require().NoError(err)
@@ -210,12 +300,18 @@ func (s *sessionTestSuite) Test_StartController_Correct() {
 	clientID := "1"
 	sessionID := generate.SessionID(clientID).String()
 
-	cmdb.ObjectCreate(sessionID, inStatefun.SESSION_TYPE, easyjson.NewJSONObjectWithKeyValue("client_id", easyjson.NewJSON(clientID)))
+	sessionBody := easyjson.NewJSONObjectWithKeyValue("client_id", easyjson.NewJSON(clientID))
+	sessionBody.SetByPath("version", easyjson.NewJSON(protocol.VersionControllerReplies))
+	cmdb.ObjectCreate(sessionID, inStatefun.SESSION_TYPE, sessionBody)
+
+	s.Require().NoError(cmdb.TypeCreate("test_uuid"))
+	s.Require().NoError(cmdb.ObjectCreate("uuid_1", "test_uuid"))
+	s.Require().NoError(cmdb.ObjectCreate("uuid_2", "test_uuid"))
 
 	plugin := map[string]map[string]session.Controller{
 		"viewer": {
 			"test_controller": {
-				Body: map[string]string{
+				Body: protocol.Declaration{
 					"props": "@property:",
 				},
 				UUIDs: []string{"uuid_1", "uuid_2"},
@@ -230,9 +326,797 @@ func (s *sessionTestSuite) Test_StartController_Correct() {
 	err = s.Signal(plugins.JetstreamGlobalSignal, typename, sessionID, &payload, nil)
 	s.Require().NoError(err)
 
-	msg, err := sub.NextMsg(1 * time.Second)
+	wantPayload := `{"payload":{"command":"START_CONTROLLER","status":"ok","plugin":"viewer","name":"test_controller"}}`
+	s.JSONEq(wantPayload, string(s.nextReply(sub).Data))
+}
+
+func (s *sessionTestSuite) Test_StartController_DefaultVersion() {
+	typename := inStatefun.SESSION_START_CONTROLLER
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	session.RegisterFunctions(s.Runtime())
+	adapter.RegisterFunctions(s.Runtime())
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	// the session didn't ask for a protocol version
+	clientID := "default_version"
+	sessionID := generate.SessionID(clientID).String()
+
+	cmdb.ObjectCreate(sessionID, inStatefun.SESSION_TYPE, easyjson.NewJSONObjectWithKeyValue("client_id", easyjson.NewJSON(clientID)))
+
+	s.Require().NoError(cmdb.TypeCreate("test_uuid"))
+	s.Require().NoError(cmdb.ObjectCreate("uuid_1", "test_uuid"))
+
+	plugin := map[string]map[string]session.Controller{
+		"viewer": {
+			"first":  {Body: protocol.Declaration{"props": "@property:"}, UUIDs: []string{"uuid_1"}},
+			"second": {Body: protocol.Declaration{"name": "@property:name"}, UUIDs: []string{"uuid_1"}},
+		},
+	}
+
+	sub, err := s.SubscribeEgress(inStatefun.EGRESS, clientID)
+	s.Require().NoError(err)
+
+	payload := easyjson.NewJSON(plugin)
+	err = s.Signal(plugins.JetstreamGlobalSignal, typename, sessionID, &payload, nil)
+	s.Require().NoError(err)
+
+	// the whole command is answered once, controllers send only their updates
+	s.JSONEq(`{"payload":{"command":"START_CONTROLLER","status":"ok"}}`, string(s.nextReply(sub).Data))
+
+	for {
+		msg, err := sub.NextMsg(2 * time.Second)
+		if err != nil {
+			break
+		}
+
+		data, _ := easyjson.JSONFromBytes(msg.Data)
+		s.False(data.PathExists("payload.status"), string(msg.Data))
+	}
+}
+
+func (s *sessionTestSuite) Test_StartController_InvalidDeclaration() {
+	typename := inStatefun.SESSION_START_CONTROLLER
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	session.RegisterFunctions(s.Runtime())
+	adapter.RegisterFunctions(s.Runtime())
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	clientID := "invalid_declaration"
+	sessionID := generate.SessionID(clientID).String()
+
+	sessionBody := easyjson.NewJSONObjectWithKeyValue("client_id", easyjson.NewJSON(clientID))
+	sessionBody.SetByPath("version", easyjson.NewJSON(protocol.VersionControllerReplies))
+	cmdb.ObjectCreate(sessionID, inStatefun.SESSION_TYPE, sessionBody)
+
+	plugin := map[string]map[string]session.Controller{
+		"viewer": {
+			"broken": {
+				Body:  protocol.Declaration{"name": "@function:unknownFunction()"},
+				UUIDs: []string{"uuid_1"},
+			},
+		},
+	}
+
+	sub, err := s.SubscribeEgress(inStatefun.EGRESS, clientID)
+	s.Require().NoError(err)
+
+	payload := easyjson.NewJSON(plugin)
+	err = s.Signal(plugins.JetstreamGlobalSignal, typename, sessionID, &payload, nil)
+	s.Require().NoError(err)
+
+	// the error is the only reply, no "ok" comes before it
+	reply, ok := easyjson.JSONFromBytes(s.nextReply(sub).Data)
+	s.Require().True(ok)
+	s.Equal("error", reply.GetByPath("payload.status").AsStringDefault(""))
+	s.Equal(string(protocol.ERR_INVALID_DECLARATION), reply.GetByPath("payload.code").AsStringDefault(""))
+	s.Equal("broken", reply.GetByPath("payload.name").AsStringDefault(""))
+
+	_, err = sub.NextMsg(time.Second)
+	s.ErrorIs(err, nats.ErrTimeout)
+}
+
+func (s *sessionTestSuite) Test_ClearController_Correct() {
+	typename := inStatefun.SESSION_CLEAR_CONTROLLER
+	cfg := *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1)
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	decorators.Register(s.Runtime())
+	s.RegisterFunction(inStatefun.CONTROLLER_CLEAR, adapter.ClearController, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1).SetAllowedRequestProviders(plugins.AutoRequestSelect))
+	s.RegisterFunction(inStatefun.EGRESS, session.Egress, cfg)
+	s.RegisterFunction(typename, session.ClearController, cfg)
+	s.OnAfterStartFunction(session.InitSchema, true)
+	s.OnAfterStartFunction(adapter.InitSchema, true)
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	clientID := "1"
+	sessionID := generate.SessionID(clientID).String()
+
+	err = cmdb.ObjectCreate(sessionID, inStatefun.SESSION_TYPE, easyjson.NewJSONObjectWithKeyValue("client_id", easyjson.NewJSON(clientID)))
+	s.Require().NoError(err)
+
+	controllerID := generate.UUID("test_controller").String()
+	controllerBody := easyjson.NewJSONObject()
+	controllerBody.SetByPath("plugin", easyjson.NewJSON("viewer"))
+	controllerBody.SetByPath("name", easyjson.NewJSON("test_controller"))
+
+	err = cmdb.ObjectCreate(controllerID, inStatefun.CONTROLLER_TYPE, controllerBody)
+	s.Require().NoError(err)
+
+	err = cmdb.ObjectsLinkCreate(controllerID, sessionID, sessionID, []string{})
+	s.Require().NoError(err)
+
+	err = cmdb.ObjectsLinkCreate(sessionID, controllerID, controllerID, []string{})
+	s.Require().NoError(err)
+
+	sub, err := s.SubscribeEgress(inStatefun.EGRESS, clientID)
+	s.Require().NoError(err)
+
+	defer sub.Unsubscribe()
+
+	payload := easyjson.NewJSONObject()
+	payload.SetByPath("command", easyjson.NewJSON(session.CLEAR_CONTROLLER))
+	payload.SetByPath("plugin", easyjson.NewJSON("viewer"))
+	payload.SetByPath("name", easyjson.NewJSON("test_controller"))
+
+	err = s.Signal(plugins.JetstreamGlobalSignal, typename, sessionID, &payload, nil)
+	s.Require().NoError(err)
+
+	msg, err := sub.NextMsg(5 * time.Second)
+	s.Require().NoError(err)
+
+	wantPayload := `{"payload":{"command":"CLEAR_CONTROLLER","status":"ok","plugin":"viewer","controllers":["test_controller"]}}`
+	s.JSONEq(wantPayload, string(msg.Data))
+
+	gotController, err := s.CacheValue(controllerID)
+	s.Require().NoError(err)
+
+	s.True(gotController.PathExists("released_at"))
+}
+
+func (s *sessionTestSuite) Test_ClearController_NotFound() {
+	typename := inStatefun.SESSION_CLEAR_CONTROLLER
+	cfg := *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1)
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	s.RegisterFunction(inStatefun.EGRESS, session.Egress, cfg)
+	s.RegisterFunction(typename, session.ClearController, cfg)
+	s.OnAfterStartFunction(session.InitSchema, true)
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	clientID := "1"
+	sessionID := generate.SessionID(clientID).String()
+
+	err = cmdb.ObjectCreate(sessionID, inStatefun.SESSION_TYPE, easyjson.NewJSONObjectWithKeyValue("client_id", easyjson.NewJSON(clientID)))
+	s.Require().NoError(err)
+
+	sub, err := s.SubscribeEgress(inStatefun.EGRESS, clientID)
+	s.Require().NoError(err)
+
+	defer sub.Unsubscribe()
+
+	payload := easyjson.NewJSONObject()
+	payload.SetByPath("command", easyjson.NewJSON(session.CLEAR_CONTROLLER))
+	payload.SetByPath("plugin", easyjson.NewJSON("viewer"))
+
+	err = s.Signal(plugins.JetstreamGlobalSignal, typename, sessionID, &payload, nil)
+	s.Require().NoError(err)
+
+	msg, err := sub.NextMsg(5 * time.Second)
 	s.Require().NoError(err)
 
-	wantPayload := `{"payload":{"command":"START_CONTROLLER","status":"ok"}}`
+	wantPayload := `{"payload":{"command":"CLEAR_CONTROLLER","status":"error","code":"CONTROLLER_NOT_FOUND","message":"controller not found","plugin":"viewer","controllers":[]}}`
 	s.JSONEq(wantPayload, string(msg.Data))
 }
+
+func (s *sessionTestSuite) Test_Ack_NotFound() {
+	typename := inStatefun.SESSION_ACK
+	cfg := *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1)
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	s.RegisterFunction(inStatefun.EGRESS, session.Egress, cfg)
+	s.RegisterFunction(typename, session.Ack, cfg)
+	s.OnAfterStartFunction(session.InitSchema, true)
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	clientID := "1"
+	sessionID := generate.SessionID(clientID).String()
+
+	err = cmdb.ObjectCreate(sessionID, inStatefun.SESSION_TYPE, easyjson.NewJSONObjectWithKeyValue("client_id", easyjson.NewJSON(clientID)))
+	s.Require().NoError(err)
+
+	sub, err := s.SubscribeEgress(inStatefun.EGRESS, clientID)
+	s.Require().NoError(err)
+
+	defer sub.Unsubscribe()
+
+	// sequence numbers are counted per controller, the name is required
+	payload := easyjson.NewJSONObject()
+	payload.SetByPath("command", easyjson.NewJSON(session.ACK))
+	payload.SetByPath("plugin", easyjson.NewJSON("viewer"))
+
+	err = s.Signal(plugins.JetstreamGlobalSignal, typename, sessionID, &payload, nil)
+	s.Require().NoError(err)
+
+	msg, err := sub.NextMsg(5 * time.Second)
+	s.Require().NoError(err)
+
+	s.JSONEq(`{"payload":{"command":"ACK","status":"error","code":"INVALID_PAYLOAD","message":"missing plugin or name"}}`, string(msg.Data))
+
+	payload.SetByPath("name", easyjson.NewJSON("test_controller"))
+	payload.SetByPath("seq", easyjson.NewJSONObjectWithKeyValue("uuid", easyjson.NewJSON(1)))
+
+	err = s.Signal(plugins.JetstreamGlobalSignal, typename, sessionID, &payload, nil)
+	s.Require().NoError(err)
+
+	msg, err = sub.NextMsg(5 * time.Second)
+	s.Require().NoError(err)
+
+	s.JSONEq(`{"payload":{"command":"ACK","status":"error","code":"CONTROLLER_NOT_FOUND","message":"controller not found"}}`, string(msg.Data))
+}
+
+func (s *sessionTestSuite) Test_CloseSession_DetachControllers() {
+	typename := inStatefun.SESSION_CLOSE
+	cfg := *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1)
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	decorators.Register(s.Runtime())
+	s.RegisterFunction(inStatefun.CONTROLLER_CLEAR, adapter.ClearController, *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1).SetAllowedRequestProviders(plugins.AutoRequestSelect))
+	s.RegisterFunction(inStatefun.EGRESS, session.Egress, cfg)
+	s.RegisterFunction(typename, session.CloseSession, cfg)
+	s.OnAfterStartFunction(session.InitSchema, true)
+	s.OnAfterStartFunction(adapter.InitSchema, true)
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	clientID := "1"
+	sessionID := generate.SessionID(clientID).String()
+
+	err = cmdb.ObjectCreate(sessionID, inStatefun.SESSION_TYPE, easyjson.NewJSONObjectWithKeyValue("client_id", easyjson.NewJSON(clientID)))
+	s.Require().NoError(err)
+
+	controllerID := generate.UUID("test_controller").String()
+	controllerBody := easyjson.NewJSONObject()
+	controllerBody.SetByPath("plugin", easyjson.NewJSON("viewer"))
+	controllerBody.SetByPath("name", easyjson.NewJSON("test_controller"))
+
+	err = cmdb.ObjectCreate(controllerID, inStatefun.CONTROLLER_TYPE, controllerBody)
+	s.Require().NoError(err)
+
+	err = cmdb.ObjectsLinkCreate(controllerID, sessionID, sessionID, []string{})
+	s.Require().NoError(err)
+
+	err = cmdb.ObjectsLinkCreate(sessionID, controllerID, controllerID, []string{})
+	s.Require().NoError(err)
+
+	sub, err := s.SubscribeEgress(inStatefun.EGRESS, clientID)
+	s.Require().NoError(err)
+
+	defer sub.Unsubscribe()
+
+	err = s.Signal(plugins.JetstreamGlobalSignal, typename, sessionID, nil, nil)
+	s.Require().NoError(err)
+
+	msg, err := sub.NextMsg(5 * time.Second)
+	s.Require().NoError(err)
+
+	wantPayload := `{"payload":{"command":"CLOSE_SESSION","status":"ok","controllers":{"viewer":["test_controller"]}}}`
+	s.JSONEq(wantPayload, string(msg.Data))
+
+	_, err = s.CacheValue(sessionID)
+	s.Error(err)
+
+	gotController, err := s.CacheValue(controllerID)
+	s.Require().NoError(err)
+
+	s.True(gotController.PathExists("released_at"))
+}
+
+func (s *sessionTestSuite) Test_WatchSession_ClosingWarning() {
+	typename := inStatefun.SESSION_WATCH
+	cfg := *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1)
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	s.RegisterFunction(inStatefun.EGRESS, session.Egress, cfg)
+	s.RegisterFunction(typename, session.WatchSession, cfg)
+	s.OnAfterStartFunction(session.InitSchema, true)
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	clientID := "1"
+	sessionID := generate.SessionID(clientID).String()
+	updatedAt := time.Now().Add(-session.SessionInactivityTimeout).Add(-time.Minute).Unix()
+
+	body := easyjson.NewJSONObject()
+	body.SetByPath("client_id", easyjson.NewJSON(clientID))
+	body.SetByPath("updated_at", easyjson.NewJSON(updatedAt))
+
+	err = cmdb.ObjectCreate(sessionID, inStatefun.SESSION_TYPE, body)
+	s.Require().NoError(err)
+
+	sub, err := s.SubscribeEgress(inStatefun.EGRESS, clientID)
+	s.Require().NoError(err)
+
+	defer sub.Unsubscribe()
+
+	err = s.Signal(plugins.JetstreamGlobalSignal, typename, sessionID, nil, nil)
+	s.Require().NoError(err)
+
+	msg, err := sub.NextMsg(5 * time.Second)
+	s.Require().NoError(err)
+
+	wantPayload := fmt.Sprintf(`{"payload":{"command":"CLOSING_SESSION","reason":"inactivity","timeout":"%s"}}`, session.SessionClosingTimeout)
+	s.JSONEq(wantPayload, string(msg.Data))
+
+	gotSession, err := s.CacheValue(sessionID)
+	s.Require().NoError(err)
+
+	s.True(gotSession.PathExists("closing_at"))
+}
+
+func (s *sessionTestSuite) Test_Pong_KeepsSessionAlive() {
+	typename := inStatefun.SESSION_PONG
+	cfg := *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1)
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	s.RegisterFunction(typename, session.Pong, cfg)
+	s.OnAfterStartFunction(session.InitSchema, true)
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	clientID := "1"
+	sessionID := generate.SessionID(clientID).String()
+
+	body := easyjson.NewJSONObject()
+	body.SetByPath("client_id", easyjson.NewJSON(clientID))
+	body.SetByPath("missed_pongs", easyjson.NewJSON(session.MaxMissedPongs))
+	body.SetByPath("closing_at", easyjson.NewJSON(time.Now().Unix()))
+	body.SetByPath("closing_reason", easyjson.NewJSON("heartbeat"))
+
+	err = cmdb.ObjectCreate(sessionID, inStatefun.SESSION_TYPE, body)
+	s.Require().NoError(err)
+
+	payload := easyjson.NewJSONObjectWithKeyValue("command", easyjson.NewJSON(session.PONG))
+
+	err = s.Signal(plugins.JetstreamGlobalSignal, typename, sessionID, &payload, nil)
+	s.Require().NoError(err)
+
+	time.Sleep(1 * time.Second)
+
+	gotSession, err := s.CacheValue(sessionID)
+	s.Require().NoError(err)
+
+	s.False(gotSession.PathExists("closing_at"))
+	s.Equal(float64(0), gotSession.GetByPath("missed_pongs").AsNumericDefault(-1))
+	s.True(gotSession.PathExists("last_pong_at"))
+}
+
+func (s *sessionTestSuite) Test_WatchSession_HeartbeatDisabled() {
+	typename := inStatefun.SESSION_WATCH
+	cfg := *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1)
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	s.RegisterFunction(inStatefun.EGRESS, session.Egress, cfg)
+	s.RegisterFunction(typename, session.WatchSession, cfg)
+	s.OnAfterStartFunction(session.InitSchema, true)
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	clientID := "1"
+	sessionID := generate.SessionID(clientID).String()
+
+	// the client has never answered PING, it doesn't matter while the heartbeat is disabled
+	body := easyjson.NewJSONObject()
+	body.SetByPath("client_id", easyjson.NewJSON(clientID))
+	body.SetByPath("updated_at", easyjson.NewJSON(time.Now().Unix()))
+	body.SetByPath("missed_pongs", easyjson.NewJSON(session.MaxMissedPongs))
+
+	err = cmdb.ObjectCreate(sessionID, inStatefun.SESSION_TYPE, body)
+	s.Require().NoError(err)
+
+	sub, err := s.SubscribeEgress(inStatefun.EGRESS, clientID)
+	s.Require().NoError(err)
+
+	defer sub.Unsubscribe()
+
+	err = s.Signal(plugins.JetstreamGlobalSignal, typename, sessionID, nil, nil)
+	s.Require().NoError(err)
+
+	_, err = sub.NextMsg(2 * time.Second)
+	s.ErrorIs(err, nats.ErrTimeout)
+
+	gotSession, err := s.CacheValue(sessionID)
+	s.Require().NoError(err)
+
+	s.False(gotSession.PathExists("closing_at"))
+	s.False(gotSession.PathExists("last_ping_at"))
+}
+
+func (s *sessionTestSuite) Test_UpdateSessionActivity_AnswersPing() {
+	typename := inStatefun.SESSION_UPDATE_ACTIVITY
+	cfg := *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1)
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	s.RegisterFunction(typename, session.UpdateSessionActivity, cfg)
+	s.OnAfterStartFunction(session.InitSchema, true)
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	clientID := "1"
+	sessionID := generate.SessionID(clientID).String()
+	lastPing := time.Now().Add(-time.Second).Unix()
+
+	body := easyjson.NewJSONObject()
+	body.SetByPath("client_id", easyjson.NewJSON(clientID))
+	body.SetByPath("last_ping_at", easyjson.NewJSON(lastPing))
+	body.SetByPath("missed_pongs", easyjson.NewJSON(session.MaxMissedPongs-1))
+
+	err = cmdb.ObjectCreate(sessionID, inStatefun.SESSION_TYPE, body)
+	s.Require().NoError(err)
+
+	err = s.Signal(plugins.JetstreamGlobalSignal, typename, sessionID, nil, nil)
+	s.Require().NoError(err)
+
+	time.Sleep(1 * time.Second)
+
+	gotSession, err := s.CacheValue(sessionID)
+	s.Require().NoError(err)
+
+	s.Equal(float64(0), gotSession.GetByPath("missed_pongs").AsNumericDefault(-1))
+	s.GreaterOrEqual(gotSession.GetByPath("last_pong_at").AsNumericDefault(0), float64(lastPing))
+}
+
+func (s *sessionTestSuite) Test_Info() {
+	typename := inStatefun.SESSION_INFO
+	cfg := *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1)
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	s.RegisterFunction(inStatefun.EGRESS, session.Egress, cfg)
+	s.RegisterFunction(typename, session.Info, cfg)
+	s.OnAfterStartFunction(session.InitSchema, true)
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	cmdb, err := db.NewCMDBSyncClientFromRequestFunction(s.Request)
+	s.Require().NoError(err)
+
+	clientID := "1"
+	sessionID := generate.SessionID(clientID).String()
+	createdAt := time.Now().Add(-time.Minute).Unix()
+
+	body := easyjson.NewJSONObject()
+	body.SetByPath("client_id", easyjson.NewJSON(clientID))
+	body.SetByPath("created_at", easyjson.NewJSON(createdAt))
+	body.SetByPath("updated_at", easyjson.NewJSON(createdAt))
+
+	err = cmdb.ObjectCreate(sessionID, inStatefun.SESSION_TYPE, body)
+	s.Require().NoError(err)
+
+	sub, err := s.SubscribeEgress(inStatefun.EGRESS, clientID)
+	s.Require().NoError(err)
+
+	defer sub.Unsubscribe()
+
+	payload := easyjson.NewJSONObject()
+	payload.SetByPath("command", easyjson.NewJSON(session.INFO))
+	payload.SetByPath("client_id", easyjson.NewJSON(clientID))
+
+	err = s.Signal(plugins.JetstreamGlobalSignal, typename, sessionID, &payload, nil)
+	s.Require().NoError(err)
+
+	msg, err := sub.NextMsg(5 * time.Second)
+	s.Require().NoError(err)
+
+	reply, ok := easyjson.JSONFromBytes(msg.Data)
+	s.Require().True(ok)
+
+	s.Equal("INFO", reply.GetByPath("payload.command").AsStringDefault(""))
+	s.Equal("ok", reply.GetByPath("payload.status").AsStringDefault(""))
+	s.Equal(clientID, reply.GetByPath("payload.client_id").AsStringDefault(""))
+	s.Equal(float64(createdAt), reply.GetByPath("payload.creation_time").AsNumericDefault(0))
+	s.Equal(float64(createdAt), reply.GetByPath("payload.last_activity_time").AsNumericDefault(0))
+	s.Equal(session.SessionInactivityTimeout.String(), reply.GetByPath("payload.inactivity_timeout").AsStringDefault(""))
+	s.GreaterOrEqual(reply.GetByPath("payload.life_time").AsNumericDefault(0), float64(60))
+	s.Equal(0, reply.GetByPath("payload.controllers").ArraySize())
+}
+
+func (s *sessionTestSuite) Test_SessionRouter_UnknownCommand() {
+	typename := inStatefun.SESSION_ROUTER
+	cfg := *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1)
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	s.RegisterFunction(inStatefun.EGRESS, session.Egress, cfg)
+	s.RegisterFunction(typename, session.SessionRouter, cfg)
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	clientID := "1"
+	sessionID := generate.SessionID(clientID).String()
+
+	sub, err := s.SubscribeEgress(inStatefun.EGRESS, clientID)
+	s.Require().NoError(err)
+
+	defer sub.Unsubscribe()
+
+	payload := easyjson.NewJSONObject()
+	payload.SetByPath("command", easyjson.NewJSON("UNKNOWN"))
+	payload.SetByPath("client_id", easyjson.NewJSON(clientID))
+
+	err = s.Signal(plugins.JetstreamGlobalSignal, typename, sessionID, &payload, nil)
+	s.Require().NoError(err)
+
+	msg, err := sub.NextMsg(5 * time.Second)
+	s.Require().NoError(err)
+
+	wantPayload := `{"payload":{"command":"UNKNOWN","status":"error","code":"UNKNOWN_COMMAND","message":"unknown command: UNKNOWN"}}`
+	s.JSONEq(wantPayload, string(msg.Data))
+}
+
+func (s *sessionTestSuite) Test_Ingress_EchoRequestID() {
+	cfg := *statefun.NewFunctionTypeConfig().SetMaxIdHandlers(-1)
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	s.RegisterFunction(inStatefun.EGRESS, session.Egress, cfg)
+	s.RegisterFunction(inStatefun.INGRESS, session.Ingress, cfg)
+	s.RegisterFunction(inStatefun.SESSION_ROUTER, session.SessionRouter, cfg)
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	clientID := "1"
+
+	sub, err := s.SubscribeEgress(inStatefun.EGRESS, clientID)
+	s.Require().NoError(err)
+
+	defer sub.Unsubscribe()
+
+	payload := easyjson.NewJSONObject()
+	payload.SetByPath("command", easyjson.NewJSON("UNKNOWN"))
+	payload.SetByPath("request_id", easyjson.NewJSON("42"))
+
+	err = s.Signal(plugins.JetstreamGlobalSignal, inStatefun.INGRESS, clientID, &payload, nil)
+	s.Require().NoError(err)
+
+	msg, err := sub.NextMsg(5 * time.Second)
+	s.Require().NoError(err)
+
+	wantPayload := `{"payload":{"command":"UNKNOWN","status":"error","code":"UNKNOWN_COMMAND","message":"unknown command: UNKNOWN","request_id":"42"}}`
+	s.JSONEq(wantPayload, string(msg.Data))
+}
+
+func (s *sessionTestSuite) Test_Broadcast_UserSessions() {
+	cfg := session.DefaultConfig()
+	// the token is the subject, sessions are grouped only by verified users
+	cfg.Authenticator = auth.AuthenticatorFunc(func(token string) (auth.Principal, error) {
+		return auth.Principal{Subject: token}, nil
+	})
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	session.RegisterFunctions(s.Runtime(), cfg)
+	defer session.ResetConfig()
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	tabs := []string{"1", "2"}
+	subs := make(map[string]*nats.Subscription)
+
+	for _, clientID := range tabs {
+		sub, err := s.SubscribeEgress(inStatefun.EGRESS, clientID)
+		s.Require().NoError(err)
+
+		defer sub.Unsubscribe()
+		subs[clientID] = sub
+
+		payload := easyjson.NewJSONObject()
+		payload.SetByPath("client_id", easyjson.NewJSON(clientID))
+		payload.SetByPath("token", easyjson.NewJSON("alice"))
+
+		err = s.Signal(plugins.JetstreamGlobalSignal, inStatefun.SESSION_START, generate.SessionID(clientID).String(), &payload, nil)
+		s.Require().NoError(err)
+
+		reply, _ := easyjson.JSONFromBytes(s.nextMessage(sub).Data)
+
+		s.Equal("ok", reply.GetByPath("payload.status").AsStringDefault(""))
+		s.Equal("alice", reply.GetByPath("payload.user").AsStringDefault(""))
+	}
+
+	payload := easyjson.NewJSONObject()
+	payload.SetByPath("command", easyjson.NewJSON("BROADCAST"))
+	payload.SetByPath("message.layout", easyjson.NewJSON("grid"))
+
+	err = s.Signal(plugins.JetstreamGlobalSignal, inStatefun.SESSION_BROADCAST, generate.SessionID("1").String(), &payload, nil)
+	s.Require().NoError(err)
+
+	msg := s.nextMessage(subs["1"])
+
+	s.JSONEq(`{"payload":{"command":"BROADCAST","status":"ok","sessions":1}}`, string(msg.Data))
+
+	msg = s.nextMessage(subs["2"])
+
+	s.JSONEq(`{"payload":{"event":"broadcast","message":{"layout":"grid"},"unsolicited":true}}`, string(msg.Data))
+}
+
+func (s *sessionTestSuite) Test_SessionRouter_SessionToken() {
+	cfg := session.DefaultConfig()
+	cfg.Authenticator = auth.AuthenticatorFunc(func(token string) (auth.Principal, error) {
+		return auth.Principal{Subject: token}, nil
+	})
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	session.RegisterFunctions(s.Runtime(), cfg)
+	defer session.ResetConfig()
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	clientID := "1"
+
+	sub, err := s.SubscribeEgress(inStatefun.EGRESS, clientID)
+	s.Require().NoError(err)
+
+	defer sub.Unsubscribe()
+
+	send := func(payload easyjson.JSON) easyjson.JSON {
+		err := s.Signal(plugins.JetstreamGlobalSignal, inStatefun.INGRESS, clientID, &payload, nil)
+		s.Require().NoError(err)
+
+		data, _ := easyjson.JSONFromBytes(s.nextMessage(sub).Data)
+		return data.GetByPath("payload")
+	}
+
+	start := easyjson.NewJSONObject()
+	start.SetByPath("command", easyjson.NewJSON("START_SESSION"))
+	start.SetByPath("token", easyjson.NewJSON("alice"))
+
+	reply := send(start)
+	s.Require().Equal("ok", reply.GetByPath("status").AsStringDefault(""))
+
+	sessionToken := reply.GetByPath("session_token").AsStringDefault("")
+	s.Require().NotEmpty(sessionToken)
+
+	// the session id is derived from the client id, so it must not be enough to take the session over
+	info := easyjson.NewJSONObject()
+	info.SetByPath("command", easyjson.NewJSON("INFO"))
+
+	reply = send(info)
+	s.Equal("error", reply.GetByPath("status").AsStringDefault(""))
+	s.Equal(string(protocol.ERR_UNAUTHENTICATED), reply.GetByPath("code").AsStringDefault(""))
+
+	info.SetByPath("session_token", easyjson.NewJSON(sessionToken))
+
+	reply = send(info)
+	s.Equal("INFO", reply.GetByPath("command").AsStringDefault(""))
+	s.Equal("ok", reply.GetByPath("status").AsStringDefault(""))
+}
+
+func (s *sessionTestSuite) Test_SessionRouter_RateLimitUnauthenticated() {
+	cfg := session.DefaultConfig()
+	cfg.Authenticator = auth.AuthenticatorFunc(func(token string) (auth.Principal, error) {
+		return auth.Principal{Subject: token}, nil
+	})
+	cfg.CommandRate = 0.01
+	cfg.CommandBurst = 2
+
+	crud.RegisterAllFunctionTypes(s.Runtime())
+	session.RegisterFunctions(s.Runtime(), cfg)
+	defer session.ResetConfig()
+
+	err := s.StartRuntime()
+	s.Require().NoError(err)
+
+	clientID := "1"
+
+	sub, err := s.SubscribeEgress(inStatefun.EGRESS, clientID)
+	s.Require().NoError(err)
+
+	defer sub.Unsubscribe()
+
+	send := func(payload easyjson.JSON) easyjson.JSON {
+		err := s.Signal(plugins.JetstreamGlobalSignal, inStatefun.INGRESS, clientID, &payload, nil)
+		s.Require().NoError(err)
+
+		data, _ := easyjson.JSONFromBytes(s.nextMessage(sub).Data)
+		return data.GetByPath("payload")
+	}
+
+	start := easyjson.NewJSONObject()
+	start.SetByPath("command", easyjson.NewJSON("START_SESSION"))
+	start.SetByPath("token", easyjson.NewJSON("alice"))
+
+	reply := send(start)
+	s.Require().Equal("ok", reply.GetByPath("status").AsStringDefault(""))
+
+	sessionToken := reply.GetByPath("session_token").AsStringDefault("")
+
+	// commands without the session token don't take tokens of the session bucket
+	info := easyjson.NewJSONObject()
+	info.SetByPath("command", easyjson.NewJSON("INFO"))
+
+	for i := 0; i < 3; i++ {
+		reply = send(info)
+		s.Equal(string(protocol.ERR_UNAUTHENTICATED), reply.GetByPath("code").AsStringDefault(""))
+	}
+
+	reply = send(start)
+	s.Equal("ok", reply.GetByPath("status").AsStringDefault(""))
+
+	info.SetByPath("session_token", easyjson.NewJSON(sessionToken))
+
+	reply = send(info)
+	s.Equal("INFO", reply.GetByPath("command").AsStringDefault(""))
+	s.Equal("ok", reply.GetByPath("status").AsStringDefault(""))
+}
+
+// nextMessage skips heartbeat PINGs which the session sends meanwhile
+// nextReply skips messages until a reply to a command, e.g. PING or controller events
+func (s *sessionTestSuite) nextReply(sub *nats.Subscription) *nats.Msg {
+	for {
+		msg := s.nextMessage(sub)
+
+		data, _ := easyjson.JSONFromBytes(msg.Data)
+		if data.PathExists("payload.status") {
+			return msg
+		}
+	}
+}
+
+func (s *sessionTestSuite) nextMessage(sub *nats.Subscription) *nats.Msg {
+	for {
+		msg, err := sub.NextMsg(5 * time.Second)
+		s.Require().NoError(err)
+
+		data, _ := easyjson.JSONFromBytes(msg.Data)
+		if data.GetByPath("payload.command").AsStringDefault("") != "PING" {
+			return msg
+		}
+	}
+}
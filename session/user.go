@@ -0,0 +1,107 @@
+package session
+
+import (
+	"log/slog"
+
+	"github.com/foliagecp/easyjson"
+	"github.com/foliagecp/sdk/clients/go/db"
+	sf "github.com/foliagecp/sdk/statefun/plugins"
+	"github.com/foliagecp/ui-app-lib/auth"
+	"github.com/foliagecp/ui-app-lib/internal/common"
+	"github.com/foliagecp/ui-app-lib/internal/generate"
+	inStatefun "github.com/foliagecp/ui-app-lib/internal/statefun"
+)
+
+/*
+Sessions of the same user, e.g. browser tabs or devices, are linked from the user object:
+
+	ui_user --ui_session--> ui_session
+
+The user name is stored in the session body:
+
+	"user": "name"
+
+The user object lives while the user has sessions, closing one of them doesn't affect others.
+*/
+const _SESSION_USER = "user"
+
+// sessionUserName is the verified subject if clients are authenticated. The user claimed on START_SESSION
+// isn't verified, it's used only if Config.GroupClaimedUsers is set.
+func sessionUserName(principal *auth.Principal, claimed string) string {
+	if config.Authenticator != nil {
+		if principal == nil {
+			return ""
+		}
+
+		return principal.Subject
+	}
+
+	if config.GroupClaimedUsers {
+		return claimed
+	}
+
+	return ""
+}
+
+func sessionUser(params *easyjson.JSON) string {
+	return params.GetByPath(_SESSION_USER).AsStringDefault("")
+}
+
+// userObjectID is the id of the user object, it lives in the hub domain like sessions
+func userObjectID(ctx *sf.StatefunContextProcessor, user string) string {
+	return ctx.Domain.CreateObjectIDWithHubDomain(generate.UserID(user).String(), false)
+}
+
+// attachUser creates the user object if it's the first session of the user and links the session to it
+func attachUser(ctx *sf.StatefunContextProcessor, cmdb db.CMDBSyncClient, sessionID, user string) error {
+	userID := userObjectID(ctx, user)
+
+	// the user object may be deleted by releaseUser meanwhile
+	if err := ctx.ObjectMutexLock(userID, false); err != nil {
+		return err
+	}
+	defer ctx.ObjectMutexUnlock(userID)
+
+	body := easyjson.NewJSONObjectWithKeyValue("name", easyjson.NewJSON(user))
+
+	if err := cmdb.ObjectCreate(userID, inStatefun.USER_TYPE, body); err != nil && !common.ErrorAlreadyExists(err) {
+		return err
+	}
+
+	sessionID = ctx.Domain.CreateObjectIDWithHubDomain(sessionID, false)
+
+	if err := cmdb.ObjectsLinkCreate(userID, sessionID, sessionID, []string{}); err != nil && !common.ErrorAlreadyExists(err) {
+		return err
+	}
+
+	return nil
+}
+
+// userSessions returns domain qualified ids of all sessions of the user
+func userSessions(ctx *sf.StatefunContextProcessor, user string) []string {
+	return common.OutLinkTargets(ctx.Domain.Cache(), userObjectID(ctx, user), inStatefun.SESSION_TYPE)
+}
+
+// releaseUser deletes the user object after its last session has been closed, the closed session may still be cached
+func releaseUser(ctx *sf.StatefunContextProcessor, cmdb db.CMDBSyncClient, user, closedSessionID string) {
+	userID := userObjectID(ctx, user)
+
+	// a session being attached meanwhile must find the user object
+	if err := ctx.ObjectMutexLock(userID, false); err != nil {
+		slog.Warn("failed to lock user", "user", user, "err", err.Error())
+		return
+	}
+	defer ctx.ObjectMutexUnlock(userID)
+
+	closedSessionID = ctx.Domain.CreateObjectIDWithHubDomain(closedSessionID, false)
+
+	for _, sessionID := range userSessions(ctx, user) {
+		if sessionID != closedSessionID {
+			return
+		}
+	}
+
+	if err := cmdb.ObjectDelete(userID); err != nil {
+		slog.Warn("failed to delete user", "user", user, "err", err.Error())
+	}
+}
@@ -0,0 +1,24 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/foliagecp/ui-app-lib/auth"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUser_SessionUserName(t *testing.T) {
+	defer func(cfg Config) { config = cfg }(config)
+
+	// the claimed user isn't trusted by default
+	config.Authenticator = nil
+	require.Equal(t, "", sessionUserName(nil, "alice"))
+
+	config.GroupClaimedUsers = true
+	require.Equal(t, "alice", sessionUserName(nil, "alice"))
+
+	// the claimed user can't override the verified subject
+	config.Authenticator = auth.NewHMACVerifier([]byte("secret"))
+	require.Equal(t, "bob", sessionUserName(&auth.Principal{Subject: "bob"}, "alice"))
+	require.Equal(t, "", sessionUserName(nil, "alice"))
+}
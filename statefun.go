@@ -1,5 +1,3 @@
-
-
 package uilib
 
 import (
@@ -8,7 +6,9 @@ import (
 	"github.com/foliagecp/ui-app-lib/session"
 )
 
-func RegisterAllFunctions(runtime *statefun.Runtime) {
-	session.RegisterFunctions(runtime)
-	adapter.RegisterFunctions(runtime)
+func RegisterAllFunctions(runtime *statefun.Runtime, opts ...Option) {
+	cfg := newConfig(opts...)
+
+	session.RegisterFunctions(runtime, cfg.Session)
+	adapter.RegisterFunctions(runtime, cfg.Adapter)
 }